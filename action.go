@@ -3,6 +3,7 @@ package retropvp
 import (
 	"context"
 	"errors"
+	"time"
 
 	protoenum "github.com/kralamoure/retroproto/enum"
 	"github.com/kralamoure/retroproto/msgcli"
@@ -15,38 +16,29 @@ func (s *session) actionMovement(ctx context.Context, m msgcli.GameActionsSendAc
 		return errInvalidRequest
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	gameMap, ok := s.svr.cache.static.gameMaps[char.GameMapId]
+	c := s.svr.cache()
+	gameMap, ok := c.static.gameMaps[char.GameMapId]
 	if !ok {
 		return errors.New("game map not found")
 	}
 
-	s.svr.mu.Lock()
-	cells, ok := s.svr.cache.gameMapCells[gameMap.Id]
-	s.svr.mu.Unlock()
+	geometry, err := c.gameMapGeometry(gameMap)
+	if err != nil {
+		return err
+	}
 
-	if !ok {
-		cells, err = gameMap.Cells()
+	path, err := validatedPath(m.DirAndCells, char.Cell, geometry, pathOptions{mode: pathRoleplay})
+	if err != nil {
+		err = s.recordViolation(ctx, ViolationInvalidPath, err.Error())
 		if err != nil {
 			return err
 		}
 
-		s.svr.mu.Lock()
-		s.svr.cache.gameMapCells[gameMap.Id] = cells
-		s.svr.mu.Unlock()
-	}
-
-	validated, err := validatedPath(m.DirAndCells, char.Cell, gameMap.Width, cells)
-	if err != nil {
-		s.svr.logger.Debugw("could not get validated path",
-			"error", err,
-			"client_address", s.conn.RemoteAddr().String(),
-		)
-
 		s.sendMessage(msgsvr.GameActions{
 			ActionType: protoenum.GameActionType.Default,
 		})
@@ -54,7 +46,7 @@ func (s *session) actionMovement(ctx context.Context, m msgcli.GameActionsSendAc
 		return nil
 	}
 
-	if len(validated) == 0 {
+	if len(path) == 0 {
 		s.sendMessage(msgsvr.GameActions{
 			ActionType: protoenum.GameActionType.Default,
 		})
@@ -69,11 +61,12 @@ func (s *session) actionMovement(ctx context.Context, m msgcli.GameActionsSendAc
 			DirAndCells: append([]prototyp.CommonDirAndCell{{
 				DirId:  0,
 				CellId: char.Cell,
-			}}, validated...),
+			}}, geometry.dirAndCellsOf(char.Cell, path)...),
 		},
 	}
 	s.busy.Inc()
 	s.gameActions[0] = gameAction
+	s.movement = newMovement(char.Cell, path, geometry, char.Mounting, time.Now())
 
 	err = s.svr.sendMsgToMap(ctx, char.GameMapId, gameAction)
 	if err != nil {
@@ -83,6 +76,58 @@ func (s *session) actionMovement(ctx context.Context, m msgcli.GameActionsSendAc
 	return nil
 }
 
+// Skills of the zaaps, the interactive objects of the waypoints.
+const (
+	skillIdSaveWaypoint = 44
+	skillIdUseWaypoint  = 114
+)
+
+// actionUseObject uses the object on the cell the character stands on or next to, with the skill if it's an interactive
+// object. The zaaps save or open their waypoint, and the items on the ground are picked up.
+func (s *session) actionUseObject(ctx context.Context, m gameActionsUseObject) error {
+	if s.busy.Load() > 0 {
+		return errNotAllowed
+	}
+
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := s.svr.cache()
+	gameMap, ok := c.static.gameMaps[char.GameMapId]
+	if !ok {
+		return errors.New("game map not found")
+	}
+
+	geometry, err := c.gameMapGeometry(gameMap)
+	if err != nil {
+		return err
+	}
+
+	near := m.CellId == char.Cell
+	for dir := 0; dir < 8 && !near; dir++ {
+		id, ok := geometry.neighbor(char.Cell, dir)
+		near = ok && id == m.CellId
+	}
+	if !near {
+		return errInvalidRequest
+	}
+
+	s.sendMessage(msgsvr.BasicsNothing{})
+
+	switch m.SkillId {
+	case 0:
+		return s.pickUpGroundItem(ctx, char.GameMapId, m.CellId)
+	case skillIdSaveWaypoint:
+		return s.saveWaypoint(ctx, char.GameMapId, m.CellId)
+	case skillIdUseWaypoint:
+		return s.openWaypoint(ctx, char.GameMapId, m.CellId)
+	default:
+		return errNotImplemented
+	}
+}
+
 // TODO
 func (s *session) actionChallenge(ctx context.Context, m msgcli.GameActionsSendActionsActionChallenge) error {
 	if s.busy.Load() > 0 {
@@ -96,12 +141,12 @@ func (s *session) actionChallenge(ctx context.Context, m msgcli.GameActionsSendA
 		return nil
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	otherChar, err := s.svr.retro.Character(ctx, m.ChallengedId)
+	otherChar, err := s.svr.character(ctx, m.ChallengedId)
 	if err != nil {
 		return err
 	}
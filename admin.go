@@ -0,0 +1,117 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+// onlineSession describes a connected session for administration.
+type onlineSession struct {
+	AccountId     string `json:"accountId,omitempty"`
+	CharacterId   int    `json:"characterId,omitempty"`
+	CharacterName string `json:"characterName,omitempty"`
+	GameMapId     int    `json:"gameMapId,omitempty"`
+	Address       string `json:"address"`
+}
+
+func (s *Server) onlineSessions(ctx context.Context) ([]onlineSession, error) {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	sli := make([]onlineSession, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+		sli = append(sli, onlineSession{
+			AccountId:   sess.accountId,
+			CharacterId: sess.characterId,
+			Address:     sess.remoteAddr.String(),
+		})
+	}
+	s.mu.Unlock()
+
+	for i, v := range sli {
+		if v.CharacterId == 0 {
+			continue
+		}
+
+		char, err := s.character(ctx, v.CharacterId)
+		if err != nil {
+			return nil, err
+		}
+		sli[i].CharacterName = string(char.Name)
+
+		s.gameMapsMu.Lock()
+		sli[i].GameMapId = sessions[i].gameMapId
+		s.gameMapsMu.Unlock()
+	}
+
+	sort.Slice(sli, func(i, j int) bool {
+		if sli[i].AccountId != sli[j].AccountId {
+			return sli[i].AccountId < sli[j].AccountId
+		}
+		return sli[i].Address < sli[j].Address
+	})
+
+	return sli, nil
+}
+
+// kick disconnects the session of the account, or of the character if accountId is empty. It returns false if it's
+// not online.
+func (s *Server) kick(accountId string, characterId int) bool {
+	s.mu.Lock()
+	sess, ok := s.sessionByAccountId[accountId]
+	if accountId == "" {
+		sess, ok = s.sessionByCharacterId[characterId]
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	sess.conn.close()
+	return true
+}
+
+func (s *Server) broadcast(message string) {
+	s.sendMsgToAll(msgsvr.ChatServerMessage{Message: message})
+}
+
+// teleportCharacter moves the character to the cell of the game map, whether it's online or not. from is the session
+// that asks for it, if any.
+func (s *Server) teleportCharacter(ctx context.Context, from *session, characterId, gameMapId, cellId int) error {
+	_, err := s.teleportDestination(gameMapId, cellId)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	sess, ok := s.sessionByCharacterId[characterId]
+	s.mu.Unlock()
+
+	if ok {
+		err := sess.do(ctx, from, func(ctx context.Context) error {
+			return sess.teleport(ctx, gameMapId, cellId)
+		})
+		if !errors.Is(err, errSessionClosed) {
+			return err
+		}
+
+		// The session is ending and its state is about to be flushed, so the character is moved there instead of in
+		// the storage, where the flush could overwrite it.
+		sess.state.move(gameMapId, cellId)
+		return sess.flushCharacter(ctx)
+	}
+
+	// Sessions are only forgotten once their state is flushed, so nothing can overwrite this.
+	char, err := s.retro.Character(ctx, characterId)
+	if err != nil {
+		return err
+	}
+	char.GameMapId = gameMapId
+	char.Cell = cellId
+
+	return s.retro.UpdateCharacter(ctx, char)
+}
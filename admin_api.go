@@ -0,0 +1,320 @@
+package retropvp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kralamoure/retro"
+)
+
+// serveAdmin serves the admin API until ctx is done. Every request must carry the admin token as a bearer token.
+func (s *Server) serveAdmin(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.adminAddr)
+	if err != nil {
+		return err
+	}
+	s.logger.Infow("serving admin api",
+		"address", ln.Addr().String(),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleAdminSessions)
+	mux.HandleFunc("POST /kick", s.handleAdminKick)
+	mux.HandleFunc("GET /bans", s.handleAdminBans)
+	mux.HandleFunc("POST /bans", s.handleAdminBan)
+	mux.HandleFunc("DELETE /bans/{kind}/{value}", s.handleAdminUnban)
+	mux.HandleFunc("POST /broadcast", s.handleAdminBroadcast)
+	mux.HandleFunc("POST /characters/{id}/teleport", s.handleAdminTeleport)
+	mux.HandleFunc("GET /characters/{id}/items", s.handleAdminCharacterItems)
+	mux.HandleFunc("POST /save", s.handleAdminSave)
+	mux.HandleFunc("POST /reload", s.handleAdminReload)
+	mux.HandleFunc("GET /violations", s.handleAdminViolations)
+
+	srv := &http.Server{
+		Handler:           s.adminAuth(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		srv.Close()
+		return nil
+	}
+}
+
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		s.logger.Infow("admin api request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client_address", r.RemoteAddr,
+		)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminTarget struct {
+	AccountId   string `json:"accountId"`
+	CharacterId int    `json:"characterId"`
+}
+
+func (t adminTarget) validate() error {
+	if (t.AccountId == "") == (t.CharacterId == 0) {
+		return errors.New("either accountId or characterId is required")
+	}
+	return nil
+}
+
+func (s *Server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.onlineSessions(r.Context())
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, sessions)
+}
+
+func (s *Server) handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	var req adminTarget
+	if !readAdminRequest(w, r, &req) {
+		return
+	}
+	err := req.validate()
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.kick(req.AccountId, req.CharacterId) {
+		writeAdminError(w, http.StatusNotFound, errors.New("not online"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, s.activeBans())
+}
+
+func (s *Server) handleAdminBan(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind        string `json:"kind"`
+		Value       string `json:"value"`
+		CharacterId int    `json:"characterId"`
+		Duration    string `json:"duration"`
+		Reason      string `json:"reason"`
+	}
+	if !readAdminRequest(w, r, &req) {
+		return
+	}
+	if (req.Value == "") == (req.CharacterId == 0) {
+		writeAdminError(w, http.StatusBadRequest, errors.New("either value or characterId is required"))
+		return
+	}
+
+	kind := BanAccount
+	if req.Kind != "" {
+		var err error
+		kind, err = ParseBanKind(req.Kind)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var dur time.Duration
+	if req.Duration != "" {
+		var err error
+		dur, err = time.ParseDuration(req.Duration)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var b Ban
+	var err error
+	if req.CharacterId != 0 {
+		b, err = s.banCharacter(r.Context(), kind, req.CharacterId, dur, req.Reason)
+	} else {
+		b, err = s.ban(r.Context(), kind, req.Value, dur, req.Reason)
+	}
+	if err != nil {
+		writeAdminError(w, adminErrorStatus(err), err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, b)
+}
+
+func (s *Server) handleAdminUnban(w http.ResponseWriter, r *http.Request) {
+	kind, err := ParseBanKind(r.PathValue("kind"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ok, err := s.unban(r.Context(), kind, r.PathValue("value"))
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, errors.New("not banned"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminBroadcast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+	}
+	if !readAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Message == "" {
+		writeAdminError(w, http.StatusBadRequest, errors.New("message is required"))
+		return
+	}
+
+	s.broadcast(req.Message)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminTeleport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req struct {
+		GameMapId int `json:"gameMapId"`
+		CellId    int `json:"cellId"`
+	}
+	if !readAdminRequest(w, r, &req) {
+		return
+	}
+
+	err = s.teleportCharacter(r.Context(), nil, id, req.GameMapId, req.CellId)
+	if err != nil {
+		writeAdminError(w, adminErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminCharacterItems(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.characterItems(r.Context(), id)
+	if err != nil {
+		writeAdminError(w, adminErrorStatus(err), err)
+		return
+	}
+
+	sli := make([]retro.CharacterItem, 0, len(items))
+	for _, v := range items {
+		sli = append(sli, v)
+	}
+	sort.Slice(sli, func(i, j int) bool { return sli[i].Id < sli[j].Id })
+
+	writeAdminJSON(w, http.StatusOK, sli)
+}
+
+func (s *Server) handleAdminSave(w http.ResponseWriter, r *http.Request) {
+	err := s.flushCharacters(r.Context())
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	err := s.Reload(r.Context())
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAdminViolations(w http.ResponseWriter, r *http.Request) {
+	if s.violations == nil {
+		writeAdminError(w, http.StatusNotImplemented, errors.New("violations are not stored"))
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 1000 {
+			writeAdminError(w, http.StatusBadRequest, errors.New("limit must be between 1 and 1000"))
+			return
+		}
+	}
+
+	violations, err := s.violations.Violations(r.Context(), r.URL.Query().Get("accountId"), limit)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, violations)
+}
+
+func readAdminRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func adminErrorStatus(err error) int {
+	if errors.Is(err, retro.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, errInvalidRequest) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,43 @@
+package retropvp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/happybydefault/logging"
+)
+
+func Test_Server_adminAuth(t *testing.T) {
+	s := &Server{logger: logging.Noop{}, adminToken: "secret"}
+	h := s.adminAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	type testCase struct {
+		name          string
+		authorization string
+		want          int
+	}
+
+	testCases := []testCase{
+		{name: "valid token", authorization: "Bearer secret", want: http.StatusNoContent},
+		{name: "invalid token", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "missing token", want: http.StatusUnauthorized},
+		{name: "not bearer", authorization: "secret", want: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tc.want {
+			t.Errorf("%s: status code: want %d, got %d", tc.name, tc.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package retropvp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	protoenum "github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+// BanKind is what a ban applies to.
+type BanKind string
+
+const (
+	BanAccount BanKind = "account"
+	BanUser    BanKind = "user"
+	BanIP      BanKind = "ip"
+)
+
+// ParseBanKind parses a ban kind.
+func ParseBanKind(s string) (BanKind, error) {
+	switch k := BanKind(s); k {
+	case BanAccount, BanUser, BanIP:
+		return k, nil
+	}
+	return "", fmt.Errorf("%w: invalid ban kind: %q", errInvalidRequest, s)
+}
+
+// Ban keeps an account, the accounts of a user or an IP address from logging in until Until, or forever if it's zero.
+type Ban struct {
+	Kind      BanKind   `json:"kind"`
+	Value     string    `json:"value"`
+	Until     time.Time `json:"until"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.Until.IsZero() && !now.Before(b.Until)
+}
+
+// BanStorer persists the bans so they survive restarts.
+type BanStorer interface {
+	// CreateBan creates the ban, replacing the one of the same kind and value if any.
+	CreateBan(ctx context.Context, b Ban) error
+	DeleteBan(ctx context.Context, kind BanKind, value string) error
+	// Bans returns the bans that aren't expired.
+	Bans(ctx context.Context) ([]Ban, error)
+}
+
+type banKey struct {
+	kind  BanKind
+	value string
+}
+
+// loadBans loads the stored bans, if they're stored.
+func (s *Server) loadBans(ctx context.Context) error {
+	if s.banStore == nil {
+		return nil
+	}
+
+	bans, err := s.banStore.Bans(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load bans: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range bans {
+		s.bans[banKey{kind: b.Kind, value: b.Value}] = b
+	}
+	return nil
+}
+
+// ban bans for the duration, or forever if it's zero, and kicks the sessions it applies to.
+func (s *Server) ban(ctx context.Context, kind BanKind, value string, dur time.Duration, reason string) (Ban, error) {
+	if dur < 0 {
+		return Ban{}, fmt.Errorf("%w: ban duration must not be negative", errInvalidRequest)
+	}
+	if value == "" {
+		return Ban{}, fmt.Errorf("%w: missing ban value", errInvalidRequest)
+	}
+	if kind == BanIP && net.ParseIP(value) == nil {
+		return Ban{}, fmt.Errorf("%w: invalid ip address: %q", errInvalidRequest, value)
+	}
+
+	now := time.Now()
+	b := Ban{
+		Kind:      kind,
+		Value:     value,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	if dur > 0 {
+		b.Until = now.Add(dur)
+	}
+
+	if s.banStore != nil {
+		err := s.banStore.CreateBan(ctx, b)
+		if err != nil {
+			return Ban{}, err
+		}
+	}
+
+	s.mu.Lock()
+	s.bans[banKey{kind: kind, value: value}] = b
+	var kicked []*session
+	for sess := range s.sessions {
+		if b.appliesTo(sess.accountId, sess.userId, sess.ip) {
+			kicked = append(kicked, sess)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sess := range kicked {
+		sess.conn.close()
+	}
+
+	s.logger.Infow("banned",
+		"kind", kind,
+		"value", value,
+		"until", b.Until,
+		"reason", reason,
+		"kicked_sessions", len(kicked),
+	)
+
+	return b, nil
+}
+
+func (b Ban) appliesTo(accountId, userId, ip string) bool {
+	var v string
+	switch b.Kind {
+	case BanAccount:
+		v = accountId
+	case BanUser:
+		v = userId
+	case BanIP:
+		v = ip
+	}
+	return v != "" && v == b.Value
+}
+
+// banAccount bans the account, or the account of the character if accountId is empty. It returns the id of the
+// banned account.
+func (s *Server) banAccount(ctx context.Context, accountId string, characterId int, dur time.Duration, reason string) (string, error) {
+	if accountId == "" {
+		char, err := s.character(ctx, characterId)
+		if err != nil {
+			return "", err
+		}
+		accountId = char.AccountId
+	}
+
+	_, err := s.ban(ctx, BanAccount, accountId, dur, reason)
+	if err != nil {
+		return "", err
+	}
+	return accountId, nil
+}
+
+// banCharacter bans the account, the user or the last IP address of the character.
+func (s *Server) banCharacter(ctx context.Context, kind BanKind, characterId int, dur time.Duration, reason string) (Ban, error) {
+	char, err := s.character(ctx, characterId)
+	if err != nil {
+		return Ban{}, err
+	}
+
+	value := char.AccountId
+	if kind != BanAccount {
+		account, err := s.dofus.Account(ctx, char.AccountId)
+		if err != nil {
+			return Ban{}, err
+		}
+		value = account.UserId
+		if kind == BanIP {
+			value = account.LastIP
+		}
+	}
+
+	return s.ban(ctx, kind, value, dur, reason)
+}
+
+// unban lifts a ban. It returns false if there was none.
+func (s *Server) unban(ctx context.Context, kind BanKind, value string) (bool, error) {
+	key := banKey{kind: kind, value: value}
+
+	s.mu.Lock()
+	b, ok := s.bans[key]
+	s.mu.Unlock()
+	if !ok || b.expired(time.Now()) {
+		return false, nil
+	}
+
+	if s.banStore != nil {
+		err := s.banStore.DeleteBan(ctx, kind, value)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.bans, key)
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// activeBans returns the bans that aren't expired, the latest first.
+func (s *Server) activeBans() []Ban {
+	now := time.Now()
+
+	s.mu.Lock()
+	bans := make([]Ban, 0, len(s.bans))
+	for key, b := range s.bans {
+		if b.expired(now) {
+			delete(s.bans, key)
+			continue
+		}
+		bans = append(bans, b)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].CreatedAt.After(bans[j].CreatedAt)
+	})
+	return bans
+}
+
+// banned returns the ban that applies to the account, the user or the IP address, if any. The longest one is returned
+// if there are several.
+func (s *Server) banned(accountId, userId, ip string) (Ban, bool) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found Ban
+	var ok bool
+	for _, key := range []banKey{{BanAccount, accountId}, {BanUser, userId}, {BanIP, ip}} {
+		b, exists := s.bans[key]
+		if !exists || key.value == "" {
+			continue
+		}
+		if b.expired(now) {
+			delete(s.bans, key)
+			continue
+		}
+		if !ok || b.Until.IsZero() || (!found.Until.IsZero() && b.Until.After(found.Until)) {
+			found = b
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// loginError is the error the client shows to the players who are banned: the remaining duration if the ban is
+// timed.
+func (b Ban) loginError(now time.Time) msgsvr.AccountLoginError {
+	if b.Until.IsZero() {
+		return msgsvr.AccountLoginError{Reason: protoenum.AccountLoginErrorReason.Banned}
+	}
+
+	remaining := b.Until.Sub(now).Round(time.Minute)
+	if remaining < time.Minute {
+		remaining = time.Minute
+	}
+	days := int(remaining / (24 * time.Hour))
+	hours := int(remaining % (24 * time.Hour) / time.Hour)
+	minutes := int(remaining % time.Hour / time.Minute)
+
+	return msgsvr.AccountLoginError{
+		Reason: protoenum.AccountLoginErrorReason.Kicked,
+		Extra:  fmt.Sprintf("%d|%d|%d", days, hours, minutes),
+	}
+}
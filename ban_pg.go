@@ -0,0 +1,77 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// BanDb is a PostgreSQL BanStorer, which stores the bans in the retro.bans table of sql/retropvp.sql.
+type BanDb struct {
+	pool *pgxpool.Pool
+}
+
+func NewBanDb(pool *pgxpool.Pool) (*BanDb, error) {
+	if pool == nil {
+		return nil, errors.New("pool is nil")
+	}
+
+	return &BanDb{pool: pool}, nil
+}
+
+func (r *BanDb) CreateBan(ctx context.Context, b Ban) error {
+	query := "INSERT INTO retro.bans (kind, value, until, reason, created_at)" +
+		" VALUES ($1, $2, $3, $4, $5)" +
+		" ON CONFLICT (kind, value) DO UPDATE" +
+		" SET until = EXCLUDED.until, reason = EXCLUDED.reason, created_at = EXCLUDED.created_at;"
+
+	var until *time.Time
+	if !b.Until.IsZero() {
+		until = &b.Until
+	}
+
+	_, err := r.pool.Exec(ctx, query, string(b.Kind), b.Value, until, b.Reason, b.CreatedAt)
+	return err
+}
+
+func (r *BanDb) DeleteBan(ctx context.Context, kind BanKind, value string) error {
+	query := "DELETE FROM retro.bans" +
+		" WHERE kind = $1 AND value = $2;"
+
+	_, err := r.pool.Exec(ctx, query, string(kind), value)
+	return err
+}
+
+func (r *BanDb) Bans(ctx context.Context) ([]Ban, error) {
+	query := "SELECT kind, value, until, reason, created_at" +
+		" FROM retro.bans" +
+		" WHERE until IS NULL OR until > now();"
+
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []Ban{}
+	for rows.Next() {
+		var b Ban
+		var kind string
+		var until *time.Time
+
+		err = rows.Scan(&kind, &b.Value, &until, &b.Reason, &b.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		b.Kind = BanKind(kind)
+		if until != nil {
+			b.Until = *until
+		}
+
+		bans = append(bans, b)
+	}
+
+	return bans, rows.Err()
+}
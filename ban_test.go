@@ -0,0 +1,94 @@
+package retropvp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/happybydefault/logging"
+	protoenum "github.com/kralamoure/retroproto/enum"
+)
+
+func Test_Server_banned(t *testing.T) {
+	s := &Server{
+		logger:   logging.Noop{},
+		sessions: make(map[*session]struct{}),
+		bans:     make(map[banKey]Ban),
+	}
+	ctx := context.Background()
+
+	type ban struct {
+		kind  BanKind
+		value string
+		dur   time.Duration
+	}
+
+	bans := []ban{
+		{kind: BanAccount, value: "account"},
+		{kind: BanUser, value: "user", dur: 2 * time.Hour},
+		{kind: BanIP, value: "10.0.0.1", dur: 1 * time.Hour},
+		{kind: BanIP, value: "10.0.0.2", dur: 1 * time.Nanosecond},
+	}
+	for _, b := range bans {
+		_, err := s.ban(ctx, b.kind, b.value, b.dur, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(1 * time.Millisecond)
+
+	type testCase struct {
+		name                  string
+		accountId, userId, ip string
+		want                  bool
+		wantKind              BanKind
+	}
+
+	testCases := []testCase{
+		{name: "account", accountId: "account", userId: "other", ip: "10.0.0.9", want: true, wantKind: BanAccount},
+		{name: "user", accountId: "other", userId: "user", ip: "10.0.0.9", want: true, wantKind: BanUser},
+		{name: "ip", accountId: "other", userId: "other", ip: "10.0.0.1", want: true, wantKind: BanIP},
+		{name: "longest", accountId: "other", userId: "user", ip: "10.0.0.1", want: true, wantKind: BanUser},
+		{name: "permanent", accountId: "account", userId: "user", ip: "10.0.0.1", want: true, wantKind: BanAccount},
+		{name: "expired", accountId: "other", userId: "other", ip: "10.0.0.2"},
+		{name: "not banned", accountId: "other", userId: "other", ip: "10.0.0.9"},
+	}
+
+	for _, tc := range testCases {
+		got, ok := s.banned(tc.accountId, tc.userId, tc.ip)
+		if ok != tc.want {
+			t.Errorf("%s: banned: want %t, got %t", tc.name, tc.want, ok)
+			continue
+		}
+		if ok && got.Kind != tc.wantKind {
+			t.Errorf("%s: kind: want %q, got %q", tc.name, tc.wantKind, got.Kind)
+		}
+	}
+
+	ok, err := s.unban(ctx, BanAccount, "account")
+	if err != nil || !ok {
+		t.Fatalf("unban: want true, got %t, %v", ok, err)
+	}
+	if _, ok := s.banned("account", "", ""); ok {
+		t.Error("after unban: want not banned, got banned")
+	}
+
+	_, err = s.ban(ctx, BanIP, "not an ip", 0, "")
+	if err == nil {
+		t.Error("invalid ip: want error, got nil")
+	}
+}
+
+func Test_Ban_loginError(t *testing.T) {
+	now := time.Now()
+
+	got := Ban{}.loginError(now)
+	if got.Reason != protoenum.AccountLoginErrorReason.Banned {
+		t.Errorf("permanent: want %q, got %q", protoenum.AccountLoginErrorReason.Banned, got.Reason)
+	}
+
+	got = Ban{Until: now.Add(26*time.Hour + 30*time.Minute)}.loginError(now)
+	if got.Reason != protoenum.AccountLoginErrorReason.Kicked || got.Extra != "1|2|30" {
+		t.Errorf("timed: want %q %q, got %q %q", protoenum.AccountLoginErrorReason.Kicked, "1|2|30", got.Reason, got.Extra)
+	}
+}
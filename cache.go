@@ -0,0 +1,230 @@
+package retropvp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+// cache is a snapshot of the game data. It's never modified once it's stored, so it can be read without locks, and a
+// reload swaps a new snapshot in. Reading it more than once within an operation may observe a reload in between, so
+// the operations that cross-reference its parts take it once.
+//
+// Its market listings and the geometries of its game maps are parts of the snapshot too, so a reload publishes them with
+// the rest in a single step. The listings never change either, while the geometries are built on demand and
+// synchronize themselves. Each snapshot has its own, so one built from a game map that was since reloaded is never
+// seen by the readers of the newer snapshots.
+type cache struct {
+	static cacheStatic
+
+	npcsByMapId map[int][]retro.NPC
+	markets     map[string]retro.Market
+
+	listings   *marketListings
+	geometries *gameMapGeometries
+}
+
+type cacheStatic struct {
+	gameMaps     map[int]retro.GameMap
+	effects      map[int]retro.EffectTemplate
+	itemSets     map[int]retro.ItemSet
+	items        map[int]retro.ItemTemplate
+	npcs         map[int]retro.NPCTemplate
+	npcDialogs   map[int]retro.NPCDialog
+	npcResponses map[int]retro.NPCResponse
+	classes      map[retrotyp.ClassId]retro.Class
+	spells       map[int]retro.Spell
+	mounts       map[int]retro.MountTemplate
+}
+
+// loadCache builds a new cache, with its market listings, from the database and stores it in a single step, so readers
+// always see a complete one.
+func (s *Server) loadCache(ctx context.Context) error {
+	s.loadCacheMu.Lock()
+	defer s.loadCacheMu.Unlock()
+
+	c := &cache{geometries: newGameMapGeometries()}
+
+	gameMaps, err := s.retro.GameMaps(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.gameMaps = gameMaps
+
+	effectTemplates, err := s.retro.EffectTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.effects = effectTemplates
+
+	itemSetTemplates, err := s.retro.ItemSets(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.itemSets = itemSetTemplates
+
+	itemTemplates, err := s.retro.ItemTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.items = itemTemplates
+
+	npcTemplates, err := s.retro.NPCTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.npcs = npcTemplates
+
+	npcDialogs, err := s.retro.NPCDialogs(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.npcDialogs = npcDialogs
+
+	npcResponse, err := s.retro.NPCResponses(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.npcResponses = npcResponse
+
+	markets, err := s.retro.Markets(ctx)
+	if err != nil {
+		return err
+	}
+	c.markets = markets
+
+	marketItemsByMarketId := make(map[string]map[int]retro.MarketItem, len(c.markets))
+	marketPricesByMarketId := make(map[string]map[int][3]int, len(c.markets))
+	for id := range markets {
+		marketItems, err := s.retro.MarketItemsByMarketId(ctx, id)
+		if err != nil {
+			return err
+		}
+		marketItemsByMarketId[id] = marketItems
+
+		if s.marketPriceStore != nil {
+			marketPrices, err := s.marketPriceStore.MarketItemPrices(ctx, id)
+			if err != nil {
+				return err
+			}
+			marketPricesByMarketId[id] = marketPrices
+		}
+	}
+
+	c.listings, err = newMarketListings(marketItemsByMarketId, marketPricesByMarketId, c)
+	if err != nil {
+		return err
+	}
+
+	npcs, err := s.retro.NPCs(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.npcsByMapId = make(map[int][]retro.NPC)
+	for _, v := range npcs {
+		if c.npcsByMapId[v.MapId] == nil {
+			c.npcsByMapId[v.MapId] = []retro.NPC{v}
+		} else {
+			c.npcsByMapId[v.MapId] = append(c.npcsByMapId[v.MapId], v)
+		}
+	}
+
+	classes, err := s.retro.Classes(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.classes = classes
+
+	spells, err := s.retro.Spells(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.spells = spells
+
+	mountTemplates, err := s.retro.MountTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	c.static.mounts = mountTemplates
+
+	s.currentCache.Store(c)
+
+	if s.warmGameMaps {
+		s.warmGameMapGeometries(ctx, c)
+	}
+
+	return nil
+}
+
+// cache returns the current cache, which must not be modified.
+func (s *Server) cache() *cache {
+	return s.currentCache.Load()
+}
+
+// Reload reloads the static game data, NPCs and markets from the database without interrupting the sessions.
+func (s *Server) Reload(ctx context.Context) error {
+	start := time.Now()
+
+	err := s.loadCache(ctx)
+	if err != nil {
+		return fmt.Errorf("could not reload cache: %w", err)
+	}
+
+	s.logger.Infow("reloaded cache",
+		"duration", time.Since(start).String(),
+	)
+
+	return nil
+}
+
+// marketListings are the items for sale in each market, with their prices and their search index. Buying an item
+// doesn't use it up, so they only change with a reload, which builds new ones.
+type marketListings struct {
+	itemsByMarketId  map[string]map[int]retro.MarketItem
+	pricesByMarketId map[string]map[int][3]int
+	indexByMarketId  map[string]marketIndex
+}
+
+// newMarketListings builds the listings of the items with their prices. An item without prices is only sold in the
+// first quantity of its market, at its own price.
+func newMarketListings(itemsByMarketId map[string]map[int]retro.MarketItem, pricesByMarketId map[string]map[int][3]int, c *cache) (*marketListings, error) {
+	l := &marketListings{
+		itemsByMarketId:  itemsByMarketId,
+		pricesByMarketId: make(map[string]map[int][3]int, len(itemsByMarketId)),
+		indexByMarketId:  make(map[string]marketIndex, len(itemsByMarketId)),
+	}
+	for id, items := range itemsByMarketId {
+		prices := make(map[int][3]int, len(items))
+		for itemId, item := range items {
+			v, ok := pricesByMarketId[id][itemId]
+			if !ok {
+				v = [3]int{item.Price}
+			}
+			prices[itemId] = v
+		}
+		l.pricesByMarketId[id] = prices
+
+		idx, err := newMarketIndex(items, c.static.items, c.static.effects)
+		if err != nil {
+			return nil, err
+		}
+		l.indexByMarketId[id] = idx
+	}
+	return l, nil
+}
+
+func (l *marketListings) items(marketId string) map[int]retro.MarketItem {
+	return l.itemsByMarketId[marketId]
+}
+
+func (l *marketListings) prices(marketId string) map[int][3]int {
+	return l.pricesByMarketId[marketId]
+}
+
+func (l *marketListings) index(marketId string) marketIndex {
+	return l.indexByMarketId[marketId]
+}
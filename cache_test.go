@@ -0,0 +1,174 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/happybydefault/logging"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrosvc"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+// testRetroStore is a retro.Storer with the game data of the cache only. Each load of the cache gets the data of a new
+// generation, where the width of the game maps, the names of the item templates and the prices of the market items
+// are the generation, and fails while fail is set.
+type testRetroStore struct {
+	retro.Storer
+
+	mu         sync.Mutex
+	generation int
+	fail       bool
+}
+
+func (r *testRetroStore) GameMaps(ctx context.Context) (map[int]retro.GameMap, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	return map[int]retro.GameMap{1: {Id: 1, Width: r.generation}}, nil
+}
+
+func (r *testRetroStore) ItemTemplates(ctx context.Context) (map[int]retro.ItemTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return map[int]retro.ItemTemplate{1: {Id: 1, Name: strconv.Itoa(r.generation)}}, nil
+}
+
+func (r *testRetroStore) EffectTemplates(ctx context.Context) (map[int]retro.EffectTemplate, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) ItemSets(ctx context.Context) (map[int]retro.ItemSet, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) NPCTemplates(ctx context.Context) (map[int]retro.NPCTemplate, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) NPCDialogs(ctx context.Context) (map[int]retro.NPCDialog, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) NPCResponses(ctx context.Context) (map[int]retro.NPCResponse, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) Markets(ctx context.Context, gameServerId int) (map[string]retro.Market, error) {
+	return map[string]retro.Market{"m": {Id: "m"}}, nil
+}
+
+func (r *testRetroStore) MarketItemsByMarketId(ctx context.Context, marketId string) (map[int]retro.MarketItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return map[int]retro.MarketItem{
+		1: {Item: retro.Item{Id: 1, TemplateId: 1, Quantity: 1}, Price: r.generation, MarketId: marketId},
+	}, nil
+}
+
+func (r *testRetroStore) NPCs(ctx context.Context, gameServerId int) (map[string]retro.NPC, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) Classes(ctx context.Context) (map[retrotyp.ClassId]retro.Class, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) Spells(ctx context.Context) (map[int]retro.Spell, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail {
+		return nil, errors.New("unavailable")
+	}
+	return nil, nil
+}
+
+func (r *testRetroStore) MountTemplates(ctx context.Context) (map[int]retro.MountTemplate, error) {
+	return nil, nil
+}
+
+func (r *testRetroStore) setFail(fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fail = fail
+}
+
+func Test_Server_Reload(t *testing.T) {
+	ctx := context.Background()
+
+	store := &testRetroStore{}
+	svc, err := retrosvc.NewService(retrosvc.Config{Storer: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{logger: logging.Noop{}, retro: svc}
+
+	err = s.loadCache(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers never see parts of different generations, even while the cache is reloaded.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				c := s.cache()
+				gameMap := c.static.gameMaps[1]
+				if name := c.static.items[1].Name; name != strconv.Itoa(gameMap.Width) {
+					t.Errorf("item template of generation %s in a cache of generation %d", name, gameMap.Width)
+					return
+				}
+				if price := c.listings.items("m")[1].Price; price != gameMap.Width {
+					t.Errorf("market item of generation %d in a cache of generation %d", price, gameMap.Width)
+					return
+				}
+				g, err := c.gameMapGeometry(gameMap)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if g.width != gameMap.Width {
+					t.Errorf("geometry of generation %d in a cache of generation %d", g.width, gameMap.Width)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		err := s.Reload(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	// A reload that fails keeps the current cache.
+	before := s.cache()
+	store.setFail(true)
+	if err := s.Reload(ctx); err == nil {
+		t.Error("failed reload: want error, got nil")
+	}
+	if s.cache() != before {
+		t.Error("failed reload: want the cache kept")
+	}
+}
@@ -0,0 +1,229 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+// CharacterDb is a PostgreSQL CharacterStorer, which writes to the tables of the retro schema.
+type CharacterDb struct {
+	pool *pgxpool.Pool
+}
+
+func NewCharacterDb(pool *pgxpool.Pool) (*CharacterDb, error) {
+	if pool == nil {
+		return nil, errors.New("pool is nil")
+	}
+
+	return &CharacterDb{pool: pool}, nil
+}
+
+func (r *CharacterDb) CharacterIdByName(ctx context.Context, gameServerId int, name string) (int, error) {
+	query := "SELECT id" +
+		" FROM retro.characters" +
+		" WHERE gameserver_id = $1 AND lower(name) = lower($2);"
+
+	var id int
+	err := r.pool.QueryRow(ctx, query, gameServerId, name).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, retro.ErrNotFound
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+func (r *CharacterDb) NextCharacterItemId(ctx context.Context) (int, error) {
+	query := "SELECT nextval(pg_get_serial_sequence('retro.characters_items', 'id'));"
+
+	var id int
+	err := r.pool.QueryRow(ctx, query).Scan(&id)
+	return id, err
+}
+
+func (r *CharacterDb) NextMountId(ctx context.Context) (int, error) {
+	query := "SELECT nextval(pg_get_serial_sequence('retro.mounts', 'id'));"
+
+	var id int
+	err := r.pool.QueryRow(ctx, query).Scan(&id)
+	return id, err
+}
+
+func (r *CharacterDb) SaveCharacter(ctx context.Context, changes CharacterChanges) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The character may point to a mount it created, and stop pointing to one it deleted, so mounts are created and
+	// updated before the character, and deleted after.
+	for _, mount := range changes.CreatedMounts {
+		query := "INSERT INTO retro.mounts (id, template_id, character_id, name, sex, xp, capacities, validity)" +
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8);"
+
+		characterId, capacities, validity := mountColumns(mount)
+		_, err := tx.Exec(ctx, query, mount.Id, mount.TemplateId, characterId, mount.Name, mount.Sex, mount.XP,
+			capacities, validity)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, mount := range changes.UpdatedMounts {
+		query := "UPDATE retro.mounts" +
+			" SET template_id = $2, character_id = $3, name = $4, sex = $5, xp = $6, capacities = $7, validity = $8" +
+			" WHERE id = $1;"
+
+		characterId, capacities, validity := mountColumns(mount)
+		tag, err := tx.Exec(ctx, query, mount.Id, mount.TemplateId, characterId, mount.Name, mount.Sex, mount.XP,
+			capacities, validity)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("mount %d: %w", mount.Id, retro.ErrNotFound)
+		}
+	}
+
+	if changes.Character != nil {
+		err := updateCharacter(ctx, tx, *changes.Character)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range changes.CreatedItems {
+		query := "INSERT INTO retro.characters_items (id, template_id, quantity, effects, position, character_id)" +
+			" VALUES ($1, $2, $3, $4, $5, $6);"
+
+		_, err := tx.Exec(ctx, query, item.Id, item.TemplateId, item.Quantity, encodeItemEffects(item.Effects),
+			itemPosition(item), item.CharacterId)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range changes.UpdatedItems {
+		query := "UPDATE retro.characters_items" +
+			" SET template_id = $2, quantity = $3, effects = $4, position = $5, character_id = $6" +
+			" WHERE id = $1;"
+
+		tag, err := tx.Exec(ctx, query, item.Id, item.TemplateId, item.Quantity, encodeItemEffects(item.Effects),
+			itemPosition(item), item.CharacterId)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("character item %d: %w", item.Id, retro.ErrNotFound)
+		}
+	}
+
+	if len(changes.DeletedItemIds) > 0 {
+		// Items that were never written don't exist, which is fine.
+		query := "DELETE FROM retro.characters_items" +
+			" WHERE id = ANY($1);"
+
+		_, err := tx.Exec(ctx, query, changes.DeletedItemIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(changes.DeletedMountIds) > 0 {
+		// Mounts that were never written don't exist, which is fine.
+		query := "DELETE FROM retro.mounts" +
+			" WHERE id = ANY($1);"
+
+		_, err := tx.Exec(ctx, query, changes.DeletedMountIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func updateCharacter(ctx context.Context, tx pgx.Tx, char retro.Character) error {
+	query := "UPDATE retro.characters" +
+		" SET account_id = $2, gameserver_id = $3, name = $4, sex = $5, class_id = $6, color_1 = $7, color_2 = $8," +
+		" color_3 = $9, alignment = $10, alignment_enabled = $11, xp = $12, kamas = $13, bonus_points = $14," +
+		" bonus_points_spell = $15, honor = $16, disgrace = $17, stats = $18, map_id = $19, cell = $20," +
+		" direction = $21, spells = $22, mount_id = $23, mounting = $24" +
+		" WHERE id = $1;"
+
+	var color1, color2, color3 *retrotyp.Color
+	if char.Color1 != "" {
+		color1, color2, color3 = &char.Color1, &char.Color2, &char.Color3
+	}
+
+	stats := fmt.Sprintf("%d,%d,%d,%d,%d,%d",
+		char.Stats.Vitality,
+		char.Stats.Wisdom,
+		char.Stats.Strength,
+		char.Stats.Intelligence,
+		char.Stats.Chance,
+		char.Stats.Agility,
+	)
+
+	spells := make([]string, len(char.Spells))
+	for i, v := range char.Spells {
+		spells[i] = fmt.Sprintf("%d~%d~%d", v.Id, v.Level, v.Position)
+	}
+
+	var mountId *int
+	if char.MountId != 0 {
+		mountId = &char.MountId
+	}
+
+	tag, err := tx.Exec(ctx, query, char.Id,
+		char.AccountId, char.GameServerId, char.Name, char.Sex, char.ClassId,
+		color1, color2, color3, char.Alignment, char.AlignmentEnabled, char.XP, char.Kamas,
+		char.BonusPoints, char.BonusPointsSpell, char.Honor, char.Disgrace, stats,
+		char.GameMapId, char.Cell, char.Direction, spells, mountId, char.Mounting)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("character %d: %w", char.Id, retro.ErrNotFound)
+	}
+
+	return nil
+}
+
+func mountColumns(mount retro.Mount) (characterId *int, capacities []int, validity *time.Time) {
+	if mount.CharacterId != 0 {
+		characterId = &mount.CharacterId
+	}
+
+	capacities = make([]int, len(mount.Capacities))
+	for i, v := range mount.Capacities {
+		capacities[i] = int(v)
+	}
+
+	if !mount.Validity.IsZero() {
+		validity = &mount.Validity
+	}
+
+	return characterId, capacities, validity
+}
+
+func encodeItemEffects(effects []retrotyp.Effect) string {
+	return strings.Join(retro.EncodeItemEffects(effects), ",")
+}
+
+func itemPosition(item retro.CharacterItem) *retrotyp.CharacterItemPosition {
+	if item.Position == -1 {
+		return nil
+	}
+	return &item.Position
+}
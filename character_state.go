@@ -0,0 +1,523 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+// CharacterStorer writes the changes of a character, which are kept in memory between checkpoints, all at once, so
+// an operation that changes several things is never half written.
+type CharacterStorer interface {
+	// CharacterIdByName returns the id of the character of the game server with the name, ignoring case, or
+	// retro.ErrNotFound.
+	CharacterIdByName(ctx context.Context, gameServerId int, name string) (int, error)
+	// NextCharacterItemId reserves the id of a character item that's created by a later SaveCharacter.
+	NextCharacterItemId(ctx context.Context) (int, error)
+	// NextMountId reserves the id of a mount that's created by a later SaveCharacter.
+	NextMountId(ctx context.Context) (int, error)
+	// SaveCharacter writes every change in a single transaction.
+	SaveCharacter(ctx context.Context, changes CharacterChanges) error
+}
+
+// CharacterChanges are the changes of a character since it was last saved, including the ones of the mounts it
+// created, updated or deleted.
+type CharacterChanges struct {
+	Character       *retro.Character
+	CreatedItems    []retro.CharacterItem
+	UpdatedItems    []retro.CharacterItem
+	DeletedItemIds  []int
+	CreatedMounts   []retro.Mount
+	UpdatedMounts   []retro.Mount
+	DeletedMountIds []int
+}
+
+func (c CharacterChanges) empty() bool {
+	return c.Character == nil && len(c.CreatedItems) == 0 && len(c.UpdatedItems) == 0 && len(c.DeletedItemIds) == 0 &&
+		len(c.CreatedMounts) == 0 && len(c.UpdatedMounts) == 0 && len(c.DeletedMountIds) == 0
+}
+
+// characterState is the authoritative state of the character that a session plays. Every change, including the
+// creation and deletion of items, is kept in memory and flushed to the storage at every checkpoint and when the
+// session ends.
+//
+// The mounts that the session changed are kept too, nil once deleted, so they're written along with the character
+// that points to them, and read from there rather than from the storage that may not have them yet.
+type characterState struct {
+	flushMu sync.Mutex
+
+	mu            sync.Mutex
+	char          retro.Character
+	charDirty     bool
+	items         map[int]retro.CharacterItem
+	createdItems  map[int]struct{}
+	dirtyItems    map[int]struct{}
+	deletedItems  map[int]struct{}
+	mounts        map[int]*retro.Mount
+	createdMounts map[int]struct{}
+	dirtyMounts   map[int]struct{}
+	deletedMounts map[int]struct{}
+}
+
+func newCharacterState(char retro.Character, items map[int]retro.CharacterItem) *characterState {
+	st := &characterState{
+		char:         cloneCharacter(char),
+		items:        make(map[int]retro.CharacterItem, len(items)),
+		createdItems: make(map[int]struct{}),
+		dirtyItems:   make(map[int]struct{}),
+		deletedItems: make(map[int]struct{}),
+
+		mounts:        make(map[int]*retro.Mount),
+		createdMounts: make(map[int]struct{}),
+		dirtyMounts:   make(map[int]struct{}),
+		deletedMounts: make(map[int]struct{}),
+	}
+	for k, v := range items {
+		st.items[k] = cloneCharacterItem(v)
+	}
+	return st
+}
+
+func (st *characterState) character() retro.Character {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return cloneCharacter(st.char)
+}
+
+func (st *characterState) characterItems() map[int]retro.CharacterItem {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	items := make(map[int]retro.CharacterItem, len(st.items))
+	for k, v := range st.items {
+		items[k] = cloneCharacterItem(v)
+	}
+	return items
+}
+
+// move sets the position of the character.
+func (st *characterState) move(gameMapId, cellId int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.char.GameMapId = gameMapId
+	st.char.Cell = cellId
+	st.charDirty = true
+}
+
+// flush writes the changes made since the last flush to the storage. If they couldn't be written, none of them is,
+// and they're kept so they can be written by the next flush.
+func (st *characterState) flush(ctx context.Context, store CharacterStorer) error {
+	st.flushMu.Lock()
+	defer st.flushMu.Unlock()
+
+	st.mu.Lock()
+	var changes CharacterChanges
+	if st.charDirty {
+		tmp := cloneCharacter(st.char)
+		changes.Character = &tmp
+	}
+	for id := range st.createdItems {
+		changes.CreatedItems = append(changes.CreatedItems, cloneCharacterItem(st.items[id]))
+	}
+	for id := range st.dirtyItems {
+		changes.UpdatedItems = append(changes.UpdatedItems, cloneCharacterItem(st.items[id]))
+	}
+	for id := range st.deletedItems {
+		changes.DeletedItemIds = append(changes.DeletedItemIds, id)
+	}
+	for id := range st.createdMounts {
+		changes.CreatedMounts = append(changes.CreatedMounts, cloneMount(*st.mounts[id]))
+	}
+	for id := range st.dirtyMounts {
+		changes.UpdatedMounts = append(changes.UpdatedMounts, cloneMount(*st.mounts[id]))
+	}
+	for id := range st.deletedMounts {
+		changes.DeletedMountIds = append(changes.DeletedMountIds, id)
+	}
+	st.charDirty = false
+	st.createdItems = make(map[int]struct{})
+	st.dirtyItems = make(map[int]struct{})
+	st.deletedItems = make(map[int]struct{})
+	st.createdMounts = make(map[int]struct{})
+	st.dirtyMounts = make(map[int]struct{})
+	st.deletedMounts = make(map[int]struct{})
+	st.mu.Unlock()
+
+	if changes.empty() {
+		return nil
+	}
+
+	err := store.SaveCharacter(ctx, changes)
+	if err != nil {
+		st.restore(changes)
+		return err
+	}
+
+	return nil
+}
+
+// restore marks the changes that couldn't be written as pending again, merging them with the ones made since.
+func (st *characterState) restore(changes CharacterChanges) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if changes.Character != nil {
+		st.charDirty = true
+	}
+	for _, item := range changes.CreatedItems {
+		delete(st.dirtyItems, item.Id)
+		if _, ok := st.items[item.Id]; ok {
+			st.createdItems[item.Id] = struct{}{}
+		} else {
+			// Deleted since, so it must not be created nor deleted.
+			delete(st.deletedItems, item.Id)
+		}
+	}
+	for _, item := range changes.UpdatedItems {
+		if _, ok := st.items[item.Id]; ok {
+			if _, created := st.createdItems[item.Id]; !created {
+				st.dirtyItems[item.Id] = struct{}{}
+			}
+		}
+	}
+	for _, id := range changes.DeletedItemIds {
+		st.deletedItems[id] = struct{}{}
+	}
+
+	for _, mount := range changes.CreatedMounts {
+		delete(st.dirtyMounts, mount.Id)
+		if st.mounts[mount.Id] != nil {
+			st.createdMounts[mount.Id] = struct{}{}
+		} else {
+			delete(st.deletedMounts, mount.Id)
+		}
+	}
+	for _, mount := range changes.UpdatedMounts {
+		if st.mounts[mount.Id] != nil {
+			if _, created := st.createdMounts[mount.Id]; !created {
+				st.dirtyMounts[mount.Id] = struct{}{}
+			}
+		}
+	}
+	for _, id := range changes.DeletedMountIds {
+		st.deletedMounts[id] = struct{}{}
+	}
+}
+
+func (s *session) character(ctx context.Context) (retro.Character, error) {
+	if s.state == nil {
+		return retro.Character{}, errors.New("character not loaded")
+	}
+	return s.state.character(), nil
+}
+
+func (s *session) updateCharacter(ctx context.Context, char retro.Character) error {
+	if s.state == nil {
+		return errors.New("character not loaded")
+	}
+	if char.Id != s.characterId {
+		return errors.New("character id mismatch")
+	}
+
+	err := validation.Validate(char)
+	if err != nil {
+		return err
+	}
+
+	s.state.mu.Lock()
+	s.state.char = cloneCharacter(char)
+	s.state.charDirty = true
+	s.state.mu.Unlock()
+
+	s.svr.syncGameMap(s)
+
+	return nil
+}
+
+func (s *session) characterItems(ctx context.Context) (map[int]retro.CharacterItem, error) {
+	if s.state == nil {
+		return nil, errors.New("character not loaded")
+	}
+	return s.state.characterItems(), nil
+}
+
+func (s *session) characterItem(ctx context.Context, id int) (retro.CharacterItem, error) {
+	if s.state == nil {
+		return retro.CharacterItem{}, errors.New("character not loaded")
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	item, ok := s.state.items[id]
+	if !ok {
+		return retro.CharacterItem{}, retro.ErrNotFound
+	}
+	return cloneCharacterItem(item), nil
+}
+
+func (s *session) createCharacterItem(ctx context.Context, item retro.CharacterItem) (int, error) {
+	if s.state == nil {
+		return 0, errors.New("character not loaded")
+	}
+	if item.CharacterId != s.characterId {
+		return 0, errors.New("character id mismatch")
+	}
+
+	id, err := s.svr.characters.NextCharacterItemId(ctx)
+	if err != nil {
+		return 0, err
+	}
+	item.Id = id
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.items[id] = cloneCharacterItem(item)
+	s.state.createdItems[id] = struct{}{}
+
+	return id, nil
+}
+
+func (s *session) updateCharacterItem(ctx context.Context, item retro.CharacterItem) error {
+	if s.state == nil {
+		return errors.New("character not loaded")
+	}
+
+	err := validation.Validate(item)
+	if err != nil {
+		return err
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	_, ok := s.state.items[item.Id]
+	if !ok || item.CharacterId != s.characterId {
+		return retro.ErrNotFound
+	}
+
+	s.state.items[item.Id] = cloneCharacterItem(item)
+	if _, ok := s.state.createdItems[item.Id]; !ok {
+		s.state.dirtyItems[item.Id] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s *session) deleteCharacterItem(ctx context.Context, id int) error {
+	if s.state == nil {
+		return errors.New("character not loaded")
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if _, ok := s.state.items[id]; !ok {
+		return retro.ErrNotFound
+	}
+
+	delete(s.state.items, id)
+	delete(s.state.dirtyItems, id)
+	if _, ok := s.state.createdItems[id]; ok {
+		delete(s.state.createdItems, id)
+	} else {
+		s.state.deletedItems[id] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s *session) flushCharacter(ctx context.Context) error {
+	if s.state == nil {
+		return nil
+	}
+	return s.state.flush(ctx, s.svr.characters)
+}
+
+func (s *session) mount(ctx context.Context, id int) (retro.Mount, error) {
+	return s.svr.mount(ctx, s.characterId, id)
+}
+
+// characterMounts returns the mounts of the character, in the shed or equipped.
+func (s *session) characterMounts(ctx context.Context) (map[int]retro.Mount, error) {
+	if s.state == nil {
+		return nil, errors.New("character not loaded")
+	}
+
+	mounts, err := s.svr.retro.MountsByCharacterId(ctx, s.characterId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for id, mount := range s.state.mounts {
+		if mount == nil || mount.CharacterId != s.characterId {
+			delete(mounts, id)
+			continue
+		}
+		mounts[id] = cloneMount(*mount)
+	}
+	return mounts, nil
+}
+
+func (s *session) createMount(ctx context.Context, mount retro.Mount) (int, error) {
+	if s.state == nil {
+		return 0, errors.New("character not loaded")
+	}
+
+	id, err := s.svr.characters.NextMountId(ctx)
+	if err != nil {
+		return 0, err
+	}
+	mount.Id = id
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	tmp := cloneMount(mount)
+	s.state.mounts[id] = &tmp
+	s.state.createdMounts[id] = struct{}{}
+
+	return id, nil
+}
+
+func (s *session) updateMount(ctx context.Context, mount retro.Mount) error {
+	if s.state == nil {
+		return errors.New("character not loaded")
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if current, ok := s.state.mounts[mount.Id]; ok && current == nil {
+		return retro.ErrNotFound
+	}
+
+	tmp := cloneMount(mount)
+	s.state.mounts[mount.Id] = &tmp
+	if _, ok := s.state.createdMounts[mount.Id]; !ok {
+		s.state.dirtyMounts[mount.Id] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s *session) deleteMount(ctx context.Context, id int) error {
+	if s.state == nil {
+		return errors.New("character not loaded")
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if current, ok := s.state.mounts[id]; ok && current == nil {
+		return retro.ErrNotFound
+	}
+
+	s.state.mounts[id] = nil
+	delete(s.state.dirtyMounts, id)
+	if _, ok := s.state.createdMounts[id]; ok {
+		delete(s.state.createdMounts, id)
+	} else {
+		s.state.deletedMounts[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// mount returns the mount with the given id, from the state of the session playing the character if it's online and
+// changed it.
+func (s *Server) mount(ctx context.Context, characterId, id int) (retro.Mount, error) {
+	s.mu.Lock()
+	sess, ok := s.sessionByCharacterId[characterId]
+	s.mu.Unlock()
+	if ok && sess.state != nil {
+		sess.state.mu.Lock()
+		mount, changed := sess.state.mounts[id]
+		var tmp retro.Mount
+		if mount != nil {
+			tmp = cloneMount(*mount)
+		}
+		sess.state.mu.Unlock()
+
+		if changed {
+			if mount == nil {
+				return retro.Mount{}, retro.ErrNotFound
+			}
+			return tmp, nil
+		}
+	}
+
+	return s.retro.Mount(ctx, id)
+}
+
+// character returns the character with the given id, from the state of the session playing it if it's online.
+func (s *Server) character(ctx context.Context, id int) (retro.Character, error) {
+	s.mu.Lock()
+	sess, ok := s.sessionByCharacterId[id]
+	s.mu.Unlock()
+	if ok && sess.state != nil {
+		return sess.state.character(), nil
+	}
+
+	return s.retro.Character(ctx, id)
+}
+
+// characterItems returns the items of the character with the given id, from the state of the session playing it if
+// it's online.
+func (s *Server) characterItems(ctx context.Context, characterId int) (map[int]retro.CharacterItem, error) {
+	s.mu.Lock()
+	sess, ok := s.sessionByCharacterId[characterId]
+	s.mu.Unlock()
+	if ok && sess.state != nil {
+		return sess.state.characterItems(), nil
+	}
+
+	return s.retro.CharacterItemsByCharacterId(ctx, characterId)
+}
+
+// flushCharacters flushes the state of every online character.
+func (s *Server) flushCharacters(ctx context.Context) error {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessionByCharacterId))
+	for _, sess := range s.sessionByCharacterId {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		err := sess.flushCharacter(ctx)
+		if err != nil {
+			s.logger.Errorw("could not flush character",
+				"error", err,
+				"character_id", sess.characterId,
+			)
+		}
+	}
+
+	return nil
+}
+
+func cloneCharacter(char retro.Character) retro.Character {
+	char.Spells = append([]retro.CharacterSpell(nil), char.Spells...)
+	return char
+}
+
+func cloneMount(mount retro.Mount) retro.Mount {
+	mount.Capacities = append([]retrotyp.MountCapacityId(nil), mount.Capacities...)
+	return mount
+}
+
+func cloneCharacterItem(item retro.CharacterItem) retro.CharacterItem {
+	item.Effects = append([]retrotyp.Effect(nil), item.Effects...)
+	return item
+}
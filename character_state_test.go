@@ -0,0 +1,420 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/happybydefault/logging"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+// testCharacterStore is a CharacterStorer that keeps the changes it's asked to save, or fails while fail is set. It
+// can't reserve item ids while failIds is set.
+type testCharacterStore struct {
+	mu      sync.Mutex
+	nextId  int
+	fail    bool
+	failIds bool
+	saved   []CharacterChanges
+}
+
+func (r *testCharacterStore) CharacterIdByName(ctx context.Context, gameServerId int, name string) (int, error) {
+	return 0, retro.ErrNotFound
+}
+
+func (r *testCharacterStore) NextCharacterItemId(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failIds {
+		return 0, errors.New("unavailable")
+	}
+	r.nextId++
+	return 100 + r.nextId, nil
+}
+
+func (r *testCharacterStore) NextMountId(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failIds {
+		return 0, errors.New("unavailable")
+	}
+	r.nextId++
+	return 100 + r.nextId, nil
+}
+
+func (r *testCharacterStore) SaveCharacter(ctx context.Context, changes CharacterChanges) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail {
+		return errors.New("unavailable")
+	}
+	r.saved = append(r.saved, changes)
+	return nil
+}
+
+func (r *testCharacterStore) setFail(fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fail = fail
+}
+
+func testCharacterSession(store *testCharacterStore) *session {
+	char := retro.Character{Id: 1, Name: "Bob", ClassId: 1, Kamas: 100}
+	items := map[int]retro.CharacterItem{
+		1: {Item: retro.Item{Id: 1, TemplateId: 10, Quantity: 5}, CharacterId: 1, Position: -1},
+	}
+
+	svr := &Server{
+		logger:               logging.Noop{},
+		characters:           store,
+		metrics:              newMetrics(),
+		sessions:             make(map[*session]struct{}),
+		sessionByAccountId:   make(map[string]*session),
+		sessionByCharacterId: make(map[int]*session),
+		gameMapInstances:     make(map[int]*gameMapInstance),
+	}
+	svr.currentCache.Store(&cache{})
+	return testSession(svr, char, items)
+}
+
+// testSession is a session of the server playing the character, whose packets are queued but never written.
+func testSession(svr *Server, char retro.Character, items map[int]retro.CharacterItem) *session {
+	sess := &session{
+		svr:         svr,
+		conn:        &testTransport{},
+		remoteAddr:  &net.TCPAddr{},
+		out:         make(chan string, sendQueueSize),
+		tasks:       make(chan func(ctx context.Context)),
+		done:        make(chan struct{}),
+		characterId: char.Id,
+		state:       newCharacterState(char, items),
+		gameActions: make(map[int]msgsvr.GameActions),
+	}
+
+	svr.mu.Lock()
+	svr.sessions[sess] = struct{}{}
+	svr.sessionByCharacterId[char.Id] = sess
+	svr.mu.Unlock()
+
+	return sess
+}
+
+func sortedChanges(c CharacterChanges) CharacterChanges {
+	for _, sli := range [][]retro.CharacterItem{c.CreatedItems, c.UpdatedItems} {
+		sort.Slice(sli, func(i, j int) bool { return sli[i].Id < sli[j].Id })
+	}
+	sort.Ints(c.DeletedItemIds)
+	return c
+}
+
+func Test_characterState_flush(t *testing.T) {
+	ctx := context.Background()
+
+	newItem := func(id, quantity int) retro.CharacterItem {
+		return retro.CharacterItem{Item: retro.Item{Id: id, TemplateId: 20, Quantity: quantity}, CharacterId: 1, Position: -1}
+	}
+	buy := func(s *session) {
+		char, _ := s.character(ctx)
+		char.Kamas -= 40
+		if err := s.updateCharacter(ctx, char); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.createCharacterItem(ctx, newItem(0, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	split := func(s *session) {
+		item, _ := s.characterItem(ctx, 1)
+		item.Quantity -= 2
+		if err := s.updateCharacterItem(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.createCharacterItem(ctx, newItem(0, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testCase struct {
+		name       string
+		before     func(s *session)
+		failFirst  bool
+		afterFail  func(s *session)
+		want       CharacterChanges
+		wantNoSave bool
+	}
+
+	bob := retro.Character{Id: 1, Name: "Bob", ClassId: 1, Kamas: 60}
+	unmounted := retro.Character{Id: 1, Name: "Bob", ClassId: 1, Kamas: 100}
+	split1 := retro.CharacterItem{Item: retro.Item{Id: 1, TemplateId: 10, Quantity: 3}, CharacterId: 1, Position: -1}
+
+	testCases := []testCase{
+		{
+			name:   "buy",
+			before: buy,
+			want:   CharacterChanges{Character: &bob, CreatedItems: []retro.CharacterItem{newItem(101, 1)}},
+		},
+		{
+			name:      "buy retried after failure",
+			before:    buy,
+			failFirst: true,
+			want:      CharacterChanges{Character: &bob, CreatedItems: []retro.CharacterItem{newItem(101, 1)}},
+		},
+		{
+			name:      "split retried after failure",
+			before:    split,
+			failFirst: true,
+			want: CharacterChanges{
+				CreatedItems: []retro.CharacterItem{newItem(101, 2)},
+				UpdatedItems: []retro.CharacterItem{split1},
+			},
+		},
+		{
+			name: "created then updated",
+			before: func(s *session) {
+				id, _ := s.createCharacterItem(ctx, newItem(0, 1))
+				if err := s.updateCharacterItem(ctx, newItem(id, 7)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CharacterChanges{CreatedItems: []retro.CharacterItem{newItem(101, 7)}},
+		},
+		{
+			name: "created then deleted",
+			before: func(s *session) {
+				id, _ := s.createCharacterItem(ctx, newItem(0, 1))
+				if err := s.deleteCharacterItem(ctx, id); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantNoSave: true,
+		},
+		{
+			name: "created, failed, then deleted",
+			before: func(s *session) {
+				s.createCharacterItem(ctx, newItem(0, 1))
+			},
+			failFirst: true,
+			afterFail: func(s *session) {
+				if err := s.deleteCharacterItem(ctx, 101); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantNoSave: true,
+		},
+		{
+			name: "updated, failed, then deleted",
+			before: func(s *session) {
+				item, _ := s.characterItem(ctx, 1)
+				item.Quantity = 1
+				s.updateCharacterItem(ctx, item)
+			},
+			failFirst: true,
+			afterFail: func(s *session) {
+				if err := s.deleteCharacterItem(ctx, 1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CharacterChanges{DeletedItemIds: []int{1}},
+		},
+		{
+			name: "mount freed",
+			before: func(s *session) {
+				s.state.char.MountId = 5
+
+				char, _ := s.character(ctx)
+				char.MountId = 0
+				if err := s.updateCharacter(ctx, char); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.deleteMount(ctx, 5); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CharacterChanges{Character: &unmounted, DeletedMountIds: []int{5}},
+		},
+		{
+			name: "mount created, failed, then renamed",
+			before: func(s *session) {
+				s.createMount(ctx, retro.Mount{TemplateId: 1})
+			},
+			failFirst: true,
+			afterFail: func(s *session) {
+				if err := s.updateMount(ctx, retro.Mount{Id: 101, TemplateId: 1, Name: "Rex"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CharacterChanges{CreatedMounts: []retro.Mount{{Id: 101, TemplateId: 1, Name: "Rex"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		store := &testCharacterStore{}
+		s := testCharacterSession(store)
+
+		tc.before(s)
+
+		if tc.failFirst {
+			store.setFail(true)
+			if err := s.flushCharacter(ctx); err == nil {
+				t.Errorf("%s: want error", tc.name)
+			}
+			store.setFail(false)
+			if tc.afterFail != nil {
+				tc.afterFail(s)
+			}
+		}
+
+		if err := s.flushCharacter(ctx); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		if tc.wantNoSave {
+			if len(store.saved) != 0 {
+				t.Errorf("%s: want no save, got %+v", tc.name, store.saved)
+			}
+			continue
+		}
+		if len(store.saved) != 1 {
+			t.Errorf("%s: saves: want 1, got %d", tc.name, len(store.saved))
+			continue
+		}
+		got := sortedChanges(store.saved[0])
+		if !reflect.DeepEqual(got, sortedChanges(tc.want)) {
+			t.Errorf("%s: want %+v, got %+v", tc.name, tc.want, got)
+		}
+
+		// Everything was written, so there's nothing left.
+		if err := s.flushCharacter(ctx); err != nil || len(store.saved) != 1 {
+			t.Errorf("%s: second flush: want nothing written, got %d saves, error %v", tc.name, len(store.saved), err)
+		}
+	}
+}
+
+func Test_session_mount(t *testing.T) {
+	ctx := context.Background()
+	s := testCharacterSession(&testCharacterStore{})
+
+	id, err := s.createMount(ctx, retro.Mount{TemplateId: 1, CharacterId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name          string
+		change        func() error
+		wantChangeErr error
+		want          retro.Mount
+		wantErr       error
+	}
+
+	// Mounts that aren't written yet are read from the state.
+	testCases := []testCase{
+		{name: "created", want: retro.Mount{Id: id, TemplateId: 1, CharacterId: 1}},
+		{
+			name:   "updated",
+			change: func() error { return s.updateMount(ctx, retro.Mount{Id: id, TemplateId: 1, Name: "Rex"}) },
+			want:   retro.Mount{Id: id, TemplateId: 1, Name: "Rex"},
+		},
+		{name: "deleted", change: func() error { return s.deleteMount(ctx, id) }, wantErr: retro.ErrNotFound},
+		{
+			name:          "updated once deleted",
+			change:        func() error { return s.updateMount(ctx, retro.Mount{Id: id}) },
+			wantChangeErr: retro.ErrNotFound,
+			wantErr:       retro.ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		if tc.change != nil {
+			err := tc.change()
+			if !errors.Is(err, tc.wantChangeErr) {
+				t.Errorf("%s: change: want %v, got %v", tc.name, tc.wantChangeErr, err)
+			}
+		}
+
+		got, err := s.mount(ctx, id)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: want error %v, got %v", tc.name, tc.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %+v, got %+v", tc.name, tc.want, got)
+		}
+	}
+}
+
+// Test_characterState_flush_concurrent changes the state while it's flushed, with some flushes failing, and checks that
+// every change is eventually written.
+func Test_characterState_flush_concurrent(t *testing.T) {
+	ctx := context.Background()
+	store := &testCharacterStore{}
+	s := testCharacterSession(store)
+
+	const n = 200
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			char, _ := s.character(ctx)
+			char.Kamas++
+			s.updateCharacter(ctx, char)
+
+			id, _ := s.createCharacterItem(ctx, retro.CharacterItem{Item: retro.Item{TemplateId: 20, Quantity: 1}, CharacterId: 1, Position: -1})
+			if i%2 == 0 {
+				s.deleteCharacterItem(ctx, id)
+			}
+		}
+	}()
+
+	for i := 0; ; i++ {
+		store.setFail(i%3 == 0)
+		s.flushCharacter(ctx)
+
+		select {
+		case <-done:
+		default:
+			continue
+		}
+		break
+	}
+	store.setFail(false)
+	if err := s.flushCharacter(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var kamas int
+	items := make(map[int]bool)
+	for _, c := range store.saved {
+		if c.Character != nil {
+			kamas = c.Character.Kamas
+		}
+		for _, v := range c.CreatedItems {
+			if items[v.Id] {
+				t.Errorf("item %d: created twice", v.Id)
+			}
+			items[v.Id] = true
+		}
+		for _, id := range c.DeletedItemIds {
+			delete(items, id)
+		}
+	}
+
+	if kamas != 100+n {
+		t.Errorf("kamas: want %d, got %d", 100+n, kamas)
+	}
+	if len(items) != n/2 {
+		t.Errorf("items: want %d, got %d", n/2, len(items))
+	}
+}
@@ -0,0 +1,461 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/retro"
+	protoenum "github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgsvr"
+	prototyp "github.com/kralamoure/retroproto/typ"
+)
+
+// PermissionLevel is what the staff member playing an account is allowed to do. Each level is allowed to do what the
+// lower ones are.
+type PermissionLevel int
+
+const (
+	PermissionPlayer PermissionLevel = iota
+	PermissionModerator
+	PermissionGameMaster
+	PermissionAdmin
+)
+
+var permissionLevelNames = map[PermissionLevel]string{
+	PermissionPlayer:     "player",
+	PermissionModerator:  "moderator",
+	PermissionGameMaster: "gamemaster",
+	PermissionAdmin:      "admin",
+}
+
+func (l PermissionLevel) String() string {
+	name, ok := permissionLevelNames[l]
+	if !ok {
+		return strconv.Itoa(int(l))
+	}
+	return name
+}
+
+// ParsePermissionLevel parses the name of a permission level.
+func ParsePermissionLevel(s string) (PermissionLevel, error) {
+	for l, name := range permissionLevelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid permission level: %q", s)
+}
+
+// StaffMember grants a permission level to an account.
+type StaffMember struct {
+	AccountId string
+	Level     PermissionLevel
+}
+
+// ParseStaffMember parses a staff member from a string formatted as accountId:level.
+func ParseStaffMember(s string) (StaffMember, error) {
+	accountId, name, ok := strings.Cut(s, ":")
+	if !ok || accountId == "" {
+		return StaffMember{}, fmt.Errorf("malformed staff member: %q", s)
+	}
+
+	level, err := ParsePermissionLevel(name)
+	if err != nil {
+		return StaffMember{}, err
+	}
+
+	return StaffMember{AccountId: accountId, Level: level}, nil
+}
+
+// permissionLevel returns the permission level of the account. Admin accounts are always admins.
+func (s *Server) permissionLevel(account dofus.Account) PermissionLevel {
+	if account.Admin {
+		return PermissionAdmin
+	}
+	return s.staff[account.Id]
+}
+
+// commandError is an error of a command that's shown to the staff member who ran it.
+type commandError string
+
+func (e commandError) Error() string {
+	return string(e)
+}
+
+func commandErrorf(format string, a ...any) error {
+	return commandError(fmt.Sprintf(format, a...))
+}
+
+// command is a chat command of the staff. Its parameters are parsed, in order, from the words that follow its name.
+type command struct {
+	name    string
+	aliases []string
+	level   PermissionLevel
+	params  []commandParam
+	help    string
+	run     func(ctx context.Context, s *session, args commandArgs) error
+}
+
+func (c command) usage() string {
+	var sb strings.Builder
+	sb.WriteString("." + c.name)
+	for _, p := range c.params {
+		if p.optional {
+			fmt.Fprintf(&sb, " [%s]", p.name)
+		} else {
+			fmt.Fprintf(&sb, " &lt;%s&gt;", p.name)
+		}
+	}
+	return sb.String()
+}
+
+// commandParam is a parameter of a command. An optional parameter is skipped when the next word isn't meant for it,
+// so it can be followed by another parameter: that is when match returns false or, without match, when the word isn't
+// a valid value for it. A rest parameter takes every remaining word. parse returns a commandError when the word isn't
+// valid.
+type commandParam struct {
+	name     string
+	optional bool
+	rest     bool
+	match    func(s string) bool
+	parse    func(ctx context.Context, svr *Server, s string) (any, error)
+}
+
+func (p commandParam) orSkip() commandParam {
+	p.optional = true
+	return p
+}
+
+func intParam(name string) commandParam {
+	return commandParam{name: name, parse: func(ctx context.Context, svr *Server, s string) (any, error) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, commandErrorf("Invalid %s %q.", name, s)
+		}
+		return n, nil
+	}}
+}
+
+// durationParam is a duration such as 90m or 1d12h. Any word starting with a digit is meant for it.
+func durationParam(name string) commandParam {
+	return commandParam{
+		name: name,
+		match: func(s string) bool {
+			return s[0] >= '0' && s[0] <= '9'
+		},
+		parse: func(ctx context.Context, svr *Server, s string) (any, error) {
+			d, err := parseDuration(s)
+			if err != nil || d < 0 {
+				return nil, commandErrorf("Invalid %s %q.", name, s)
+			}
+			return d, nil
+		},
+	}
+}
+
+// parseDuration parses a duration like time.ParseDuration does, which may start with a number of days.
+func parseDuration(s string) (time.Duration, error) {
+	days, rest, ok := strings.Cut(s, "d")
+	if !ok {
+		return time.ParseDuration(s)
+	}
+
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	d := time.Duration(n) * 24 * time.Hour
+	if rest != "" {
+		v, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, err
+		}
+		d += v
+	}
+	return d, nil
+}
+
+func wordParam(name string) commandParam {
+	return commandParam{name: name, parse: func(ctx context.Context, svr *Server, s string) (any, error) {
+		return s, nil
+	}}
+}
+
+func textParam(name string) commandParam {
+	return commandParam{name: name, rest: true, parse: func(ctx context.Context, svr *Server, s string) (any, error) {
+		return s, nil
+	}}
+}
+
+func banKindParam(name string) commandParam {
+	return commandParam{name: name, parse: func(ctx context.Context, svr *Server, s string) (any, error) {
+		kind, err := ParseBanKind(strings.ToLower(s))
+		if err != nil {
+			return nil, commandErrorf("Invalid %s %q.", name, s)
+		}
+		return kind, nil
+	}}
+}
+
+// characterParam is a character given by id or by name, where the name of an online character may be shortened to
+// any prefix that's unique.
+func characterParam(name string) commandParam {
+	return commandParam{name: name, parse: func(ctx context.Context, svr *Server, s string) (any, error) {
+		return svr.findCharacter(ctx, s)
+	}}
+}
+
+// commandArgs are the parsed arguments of a command, by parameter name.
+type commandArgs map[string]any
+
+func (a commandArgs) has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+func (a commandArgs) int(name string) int {
+	v, _ := a[name].(int)
+	return v
+}
+
+func (a commandArgs) duration(name string) time.Duration {
+	v, _ := a[name].(time.Duration)
+	return v
+}
+
+func (a commandArgs) string(name string) string {
+	v, _ := a[name].(string)
+	return v
+}
+
+func (a commandArgs) banKind(name string) BanKind {
+	v, _ := a[name].(BanKind)
+	return v
+}
+
+func (a commandArgs) character(name string) retro.Character {
+	v, _ := a[name].(retro.Character)
+	return v
+}
+
+// nextWord splits the first word off s.
+func nextWord(s string) (word, rest string) {
+	s = strings.TrimLeft(s, " ")
+	word, rest, _ = strings.Cut(s, " ")
+	return word, strings.TrimLeft(rest, " ")
+}
+
+func (c command) parseArgs(ctx context.Context, svr *Server, s string) (commandArgs, error) {
+	args := make(commandArgs, len(c.params))
+	rest := strings.TrimSpace(s)
+	for _, p := range c.params {
+		if rest == "" {
+			if p.optional {
+				continue
+			}
+			return nil, commandErrorf("Missing %s.", p.name)
+		}
+
+		word, remaining := nextWord(rest)
+		if p.rest {
+			word, remaining = rest, ""
+		}
+		if p.optional && p.match != nil && !p.match(word) {
+			continue
+		}
+
+		v, err := p.parse(ctx, svr, word)
+		if err != nil {
+			var cmdErr commandError
+			if p.optional && p.match == nil && errors.As(err, &cmdErr) {
+				continue
+			}
+			return nil, err
+		}
+		args[p.name] = v
+		rest = remaining
+	}
+	if rest != "" {
+		return nil, commandErrorf("Unexpected %q.", rest)
+	}
+	return args, nil
+}
+
+// commandRegistry finds commands by name, alias or unique prefix.
+type commandRegistry struct {
+	commands []command
+	byName   map[string]command
+}
+
+func newCommandRegistry(commands []command) *commandRegistry {
+	r := &commandRegistry{byName: make(map[string]command)}
+	for _, c := range commands {
+		for _, name := range append([]string{c.name}, c.aliases...) {
+			if _, ok := r.byName[name]; ok {
+				panic(fmt.Sprintf("repeated command name: %q", name))
+			}
+			r.byName[name] = c
+		}
+		r.commands = append(r.commands, c)
+	}
+	sort.Slice(r.commands, func(i, j int) bool {
+		return r.commands[i].name < r.commands[j].name
+	})
+	return r
+}
+
+// complete returns the commands allowed at the level whose name or an alias starts with prefix.
+func (r *commandRegistry) complete(prefix string, level PermissionLevel) []command {
+	var sli []command
+	for _, c := range r.commands {
+		if c.level > level {
+			continue
+		}
+		for _, name := range append([]string{c.name}, c.aliases...) {
+			if strings.HasPrefix(name, prefix) {
+				sli = append(sli, c)
+				break
+			}
+		}
+	}
+	return sli
+}
+
+// lookup returns the command with the name or alias, or else the only command allowed at the level that the name is a
+// prefix of.
+func (r *commandRegistry) lookup(name string, level PermissionLevel) (command, error) {
+	c, ok := r.byName[name]
+	if ok {
+		if c.level > level {
+			return command{}, commandErrorf("Command %q requires the %s level.", c.name, c.level)
+		}
+		return c, nil
+	}
+
+	matches := r.complete(name, level)
+	switch len(matches) {
+	case 0:
+		return command{}, commandErrorf("Command %q does not exist.", name)
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for i, v := range matches {
+		names[i] = v.name
+	}
+	return command{}, commandErrorf("Command %q is ambiguous: %s.", name, strings.Join(names, ", "))
+}
+
+// findCharacter finds a character by id or by name.
+func (s *Server) findCharacter(ctx context.Context, query string) (retro.Character, error) {
+	id, err := strconv.Atoi(query)
+	if err == nil {
+		char, err := s.character(ctx, id)
+		if err != nil {
+			if errors.Is(err, retro.ErrNotFound) {
+				return retro.Character{}, commandErrorf("Character %d does not exist.", id)
+			}
+			return retro.Character{}, err
+		}
+		return char, nil
+	}
+
+	s.mu.Lock()
+	var matches []retro.Character
+	for _, sess := range s.sessionByCharacterId {
+		if sess.state == nil {
+			continue
+		}
+		char := sess.state.character()
+		if strings.EqualFold(string(char.Name), query) {
+			matches = []retro.Character{char}
+			break
+		}
+		if len(query) <= len(char.Name) && strings.EqualFold(string(char.Name[:len(query)]), query) {
+			matches = append(matches, char)
+		}
+	}
+	s.mu.Unlock()
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+	default:
+		names := make([]string, len(matches))
+		for i, v := range matches {
+			names[i] = string(v.Name)
+		}
+		sort.Strings(names)
+		return retro.Character{}, commandErrorf("Character %q is ambiguous: %s.", query, strings.Join(names, ", "))
+	}
+
+	id, err = s.characters.CharacterIdByName(ctx, s.id, query)
+	if err != nil {
+		if errors.Is(err, retro.ErrNotFound) {
+			return retro.Character{}, commandErrorf("Character %q does not exist.", query)
+		}
+		return retro.Character{}, err
+	}
+	return s.character(ctx, id)
+}
+
+// runCommand runs the command line of a staff member and audits it.
+func (s *session) runCommand(ctx context.Context, line string) error {
+	level := s.permission
+	name, rest := nextWord(line)
+
+	c, err := s.svr.commands.lookup(strings.ToLower(name), level)
+	if err == nil {
+		var args commandArgs
+		args, err = c.parseArgs(ctx, s.svr, rest)
+		var cmdErr commandError
+		if err == nil {
+			err = c.run(ctx, s, args)
+		} else if errors.As(err, &cmdErr) {
+			err = commandErrorf("%s Usage: %s", cmdErr, c.usage())
+		}
+	}
+
+	logger := s.svr.logger.Infow
+	if err != nil {
+		logger = s.svr.logger.Warnw
+	}
+	logger("command",
+		"command", c.name,
+		"line", line,
+		"level", level.String(),
+		"error", err,
+		"account_id", s.accountId,
+		"character_id", s.characterId,
+		"client_address", s.remoteAddr.String(),
+	)
+
+	if err != nil {
+		msg := err.Error()
+		var cmdErr commandError
+		switch {
+		case errors.As(err, &cmdErr):
+		case errors.Is(err, errInvalidRequest), errors.Is(err, retro.ErrNotFound):
+		default:
+			return err
+		}
+		s.sendMessage(msgsvr.InfosMessage{
+			ChatId: protoenum.InfosMessageChatId.Error,
+			Messages: []prototyp.InfosMessageMessage{
+				{
+					Id:   16,
+					Args: []string{"<b>Error</b>", msg},
+				},
+			},
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package retropvp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	protoenum "github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgsvr"
+	prototyp "github.com/kralamoure/retroproto/typ"
+)
+
+// staffCommands are the commands the staff can run in the public chat, prefixed by a dot.
+var staffCommands = []command{
+	{
+		name:   "help",
+		level:  PermissionModerator,
+		params: []commandParam{wordParam("command").orSkip()},
+		help:   "Lists the commands, or describes the ones starting with the given name.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			level := s.permission
+			prefix := strings.ToLower(args.string("command"))
+
+			matches := s.svr.commands.complete(prefix, level)
+			if c, ok := s.svr.commands.byName[prefix]; ok && c.level <= level {
+				matches = []command{c}
+			}
+			if len(matches) == 0 {
+				return commandErrorf("Command %q does not exist.", prefix)
+			}
+
+			var sb strings.Builder
+			if len(matches) == 1 && prefix != "" {
+				c := matches[0]
+				fmt.Fprintf(&sb, "<b>%s</b> (%s)<br/>%s", c.usage(), c.level, c.help)
+				if len(c.aliases) > 0 {
+					fmt.Fprintf(&sb, "<br/>Aliases: %s", strings.Join(c.aliases, ", "))
+				}
+			} else {
+				sb.WriteString("<b>Commands</b>:")
+				for _, c := range matches {
+					fmt.Fprintf(&sb, "<br/>%s: %s", c.usage(), c.help)
+				}
+			}
+			s.sendMessage(msgsvr.ChatServerMessage{Message: sb.String()})
+			return nil
+		},
+	},
+	{
+		name:  "online",
+		level: PermissionModerator,
+		help:  "Lists the online characters.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			sessions, err := s.svr.onlineSessions(ctx)
+			if err != nil {
+				return err
+			}
+
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "<b>Online</b>: %d sessions", len(sessions))
+			for _, v := range sessions {
+				if v.CharacterId == 0 {
+					continue
+				}
+				fmt.Fprintf(&sb, "<br/>%s (%d) on map %d", v.CharacterName, v.CharacterId, v.GameMapId)
+			}
+			s.sendMessage(msgsvr.ChatServerMessage{Message: sb.String()})
+			return nil
+		},
+	},
+	{
+		name:   "kick",
+		level:  PermissionModerator,
+		params: []commandParam{characterParam("character")},
+		help:   "Disconnects a character.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			char := args.character("character")
+			if !s.svr.kick("", char.Id) {
+				return commandErrorf("%s is not online.", char.Name)
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: %s kicked.", char.Name)})
+			return nil
+		},
+	},
+	{
+		name:  "ban",
+		level: PermissionModerator,
+		params: []commandParam{
+			banKindParam("account|user|ip").orSkip(),
+			characterParam("character"),
+			durationParam("duration").orSkip(),
+			textParam("reason").orSkip(),
+		},
+		help: "Bans the account, the user or the last IP address of a character, for the duration or forever.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			kind := BanAccount
+			if args.has("account|user|ip") {
+				kind = args.banKind("account|user|ip")
+			}
+
+			b, err := s.svr.banCharacter(ctx, kind, args.character("character").Id, args.duration("duration"), args.string("reason"))
+			if err != nil {
+				return err
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: %s %s banned.", b.Kind, b.Value)})
+			return nil
+		},
+	},
+	{
+		name:   "unban",
+		level:  PermissionModerator,
+		params: []commandParam{banKindParam("account|user|ip").orSkip(), wordParam("value")},
+		help:   "Lifts a ban.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			kind := BanAccount
+			if args.has("account|user|ip") {
+				kind = args.banKind("account|user|ip")
+			}
+			value := args.string("value")
+
+			ok, err := s.svr.unban(ctx, kind, value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return commandErrorf("%s %q is not banned.", kind, value)
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: %s %s unbanned.", kind, value)})
+			return nil
+		},
+	},
+	{
+		name:    "teleport",
+		aliases: []string{"tp"},
+		level:   PermissionGameMaster,
+		params:  []commandParam{characterParam("character"), intParam("game map id"), intParam("cell id")},
+		help:    "Moves a character to a cell of a game map, whether it's online or not.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			char := args.character("character")
+
+			err := s.svr.teleportCharacter(ctx, s, char.Id, args.int("game map id"), args.int("cell id"))
+			if err != nil {
+				return err
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: %s teleported.", char.Name)})
+			return nil
+		},
+	},
+	{
+		name:   "broadcast",
+		level:  PermissionGameMaster,
+		params: []commandParam{textParam("message")},
+		help:   "Sends a message to every player.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			s.svr.broadcast(args.string("message"))
+			return nil
+		},
+	},
+	{
+		name:   "announce",
+		level:  PermissionGameMaster,
+		params: []commandParam{durationParam("delay"), textParam("message")},
+		help:   "Sends a message to every player after the delay.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			msg := args.string("message")
+			name := fmt.Sprintf("announce-%d", time.Now().UnixNano())
+			return s.schedule(name, args.duration("delay"), func(ctx context.Context) error {
+				s.svr.broadcast(msg)
+				return nil
+			})
+		},
+	},
+	{
+		name:  "reset",
+		level: PermissionGameMaster,
+		help:  "Resets your characteristics.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			err := s.resetCharacteristics(ctx)
+			if err != nil {
+				return err
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: "<b>Success</b>: Characteristics were reset."})
+			return nil
+		},
+	},
+	{
+		name:    "level",
+		aliases: []string{"lvl"},
+		level:   PermissionGameMaster,
+		params:  []commandParam{intParam("level")},
+		help:    "Sets your level.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			level := args.int("level")
+
+			err := s.setLevel(ctx, level)
+			if err != nil {
+				return err
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: Level set to %d.", level)})
+			return nil
+		},
+	},
+	{
+		name:  "forget",
+		level: PermissionGameMaster,
+		help:  "Opens the spell forgetting dialog.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			s.sendMessage(msgsvr.SpellsSpellForgetShow{})
+			return nil
+		},
+	},
+	{
+		name:  "jobs",
+		level: PermissionAdmin,
+		help:  "Lists the scheduled jobs.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			var sb strings.Builder
+			sb.WriteString("<b>Jobs</b>:")
+			for _, v := range s.svr.scheduler.status() {
+				fmt.Fprintf(&sb, "<br/>%s: next %s", v.name, v.next.In(s.svr.location).Format(time.DateTime))
+				if v.running {
+					sb.WriteString(", running")
+				}
+				if !v.last.IsZero() {
+					fmt.Fprintf(&sb, ", last %s (%d runs)", v.last.In(s.svr.location).Format(time.DateTime), v.runs)
+				}
+				if v.lastErr != nil {
+					fmt.Fprintf(&sb, ", error: %s", v.lastErr)
+				}
+			}
+			s.sendMessage(msgsvr.ChatServerMessage{Message: sb.String()})
+			return nil
+		},
+	},
+	{
+		name:   "unschedule",
+		level:  PermissionAdmin,
+		params: []commandParam{wordParam("job")},
+		help:   "Removes a scheduled job.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			name := args.string("job")
+			if !s.svr.scheduler.remove(name) {
+				return commandErrorf("Job %q does not exist.", name)
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: Job %q removed.", name)})
+			return nil
+		},
+	},
+	{
+		name:  "throttled",
+		level: PermissionAdmin,
+		help:  "Shows the packets over the rate limits.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			throttledByClass, droppedByClass, kicked := s.svr.rateLimitStats.snapshot()
+
+			var sb strings.Builder
+			sb.WriteString("<b>Throttled packets</b>:")
+			for _, v := range packetClasses {
+				fmt.Fprintf(&sb, "<br/>%s: %d throttled, %d dropped", v, throttledByClass[v], droppedByClass[v])
+			}
+			fmt.Fprintf(&sb, "<br/>Kicked clients: %d", kicked)
+			s.sendMessage(msgsvr.ChatServerMessage{Message: sb.String()})
+			return nil
+		},
+	},
+	{
+		name:   "restart",
+		level:  PermissionAdmin,
+		params: []commandParam{durationParam("delay")},
+		help:   "Restarts the server after the delay.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			return s.schedule("restart-once", args.duration("delay"), s.svr.requestRestart)
+		},
+	},
+	{
+		name:  "reload",
+		level: PermissionAdmin,
+		help:  "Reloads the game data from the database.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+				defer cancel()
+
+				err := s.svr.Reload(ctx)
+				if err != nil {
+					s.svr.logger.Error(err)
+					s.sendMessage(msgsvr.InfosMessage{
+						ChatId: protoenum.InfosMessageChatId.Error,
+						Messages: []prototyp.InfosMessageMessage{
+							{
+								Id:   16,
+								Args: []string{"<b>Error</b>", "Could not reload the cache."},
+							},
+						},
+					})
+					return
+				}
+
+				s.sendMessage(msgsvr.ChatServerMessage{Message: "<b>Success</b>: Cache reloaded."})
+			}()
+			return nil
+		},
+	},
+	{
+		name:  "save",
+		level: PermissionAdmin,
+		help:  "Saves every online character.",
+		run: func(ctx context.Context, s *session, args commandArgs) error {
+			err := s.svr.flushCharacters(ctx)
+			if err != nil {
+				return err
+			}
+
+			s.sendMessage(msgsvr.ChatServerMessage{Message: "<b>Success</b>: Characters saved."})
+			return nil
+		},
+	},
+}
+
+// schedule schedules a job of a command to run once after the delay.
+func (s *session) schedule(name string, delay time.Duration, run func(ctx context.Context) error) error {
+	err := s.svr.scheduler.add(name, onceSchedule(time.Now().Add(delay)), run)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: Job %q scheduled.", name)})
+	return nil
+}
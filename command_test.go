@@ -0,0 +1,132 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/retro"
+)
+
+func Test_command_parseArgs(t *testing.T) {
+	c := command{
+		name: "ban",
+		params: []commandParam{
+			banKindParam("kind").orSkip(),
+			intParam("id"),
+			durationParam("duration").orSkip(),
+			textParam("reason").orSkip(),
+		},
+	}
+
+	type testCase struct {
+		s       string
+		want    commandArgs
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{s: "12", want: commandArgs{"id": 12}},
+		{s: "ip 12 1h spamming  a lot", want: commandArgs{"kind": BanIP, "id": 12, "duration": 1 * time.Hour, "reason": "spamming  a lot"}},
+		{s: "12 1d cheating", want: commandArgs{"id": 12, "duration": 24 * time.Hour, "reason": "cheating"}},
+		{s: "12 1d12h", want: commandArgs{"id": 12, "duration": 36 * time.Hour}},
+		{s: "12 spamming", want: commandArgs{"id": 12, "reason": "spamming"}},
+		{s: "  user   12  ", want: commandArgs{"kind": BanUser, "id": 12}},
+		{s: "12 1x cheating", wantErr: true},
+		{s: "12 1dh", wantErr: true},
+		{s: "ip", wantErr: true},
+		{s: "bob", wantErr: true},
+		{s: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := c.parseArgs(context.Background(), nil, tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: want error %t, got %v", tc.s, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: want %v, got %v", tc.s, tc.want, got)
+		}
+	}
+}
+
+func Test_commandRegistry_lookup(t *testing.T) {
+	r := newCommandRegistry([]command{
+		{name: "teleport", aliases: []string{"tp"}, level: PermissionGameMaster},
+		{name: "throttled", level: PermissionAdmin},
+		{name: "kick", level: PermissionModerator},
+	})
+
+	type testCase struct {
+		name    string
+		level   PermissionLevel
+		want    string
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{name: "kick", level: PermissionModerator, want: "kick"},
+		{name: "tp", level: PermissionGameMaster, want: "teleport"},
+		{name: "tele", level: PermissionGameMaster, want: "teleport"},
+		{name: "t", level: PermissionGameMaster, want: "teleport"},
+		{name: "t", level: PermissionAdmin, wantErr: true},
+		{name: "th", level: PermissionAdmin, want: "throttled"},
+		{name: "teleport", level: PermissionModerator, wantErr: true},
+		{name: "t", level: PermissionModerator, wantErr: true},
+		{name: "unknown", level: PermissionAdmin, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := r.lookup(tc.name, tc.level)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s at %s: want error %t, got %v", tc.name, tc.level, tc.wantErr, err)
+			continue
+		}
+		if got.name != tc.want {
+			t.Errorf("%s at %s: want %q, got %q", tc.name, tc.level, tc.want, got.name)
+		}
+	}
+}
+
+func Test_Server_findCharacter(t *testing.T) {
+	s := testCharacterSession(&testCharacterStore{})
+	testSession(s.svr, retro.Character{Id: 2, Name: "Alice", ClassId: 1}, nil)
+	testSession(s.svr, retro.Character{Id: 3, Name: "Albert", ClassId: 1}, nil)
+
+	type testCase struct {
+		query   string
+		want    int
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{query: "1", want: 1},
+		{query: "Bob", want: 1},
+		{query: "bo", want: 1},
+		{query: "ALICE", want: 2},
+		{query: "alb", want: 3},
+		{query: "al", wantErr: true},
+		{query: "Carol", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := s.svr.findCharacter(context.Background(), tc.query)
+		if tc.wantErr {
+			var cmdErr commandError
+			if !errors.As(err, &cmdErr) {
+				t.Errorf("%s: want command error, got %v", tc.query, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.query, err)
+			continue
+		}
+		if got.Id != tc.want {
+			t.Errorf("%s: want character %d, got %d", tc.query, tc.want, got.Id)
+		}
+	}
+}
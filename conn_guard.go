@@ -0,0 +1,145 @@
+package retropvp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// connGuard limits the connections that the server accepts, by IP address and by authentication state, and bans the
+// IP addresses that keep sending invalid tickets.
+type connGuard struct {
+	maxConnsPerIP      int
+	maxUnauthenticated int
+	ticketFailureLimit int
+	ticketBanDur       time.Duration
+
+	mu              sync.Mutex
+	connsByIP       map[string]int
+	unauthenticated int
+	failuresByIP    map[string]ticketFailures
+	bannedUntilByIP map[string]time.Time
+}
+
+type ticketFailures struct {
+	count int
+	first time.Time
+}
+
+func newConnGuard(maxConnsPerIP, maxUnauthenticated, ticketFailureLimit int, ticketBanDur time.Duration) *connGuard {
+	return &connGuard{
+		maxConnsPerIP:      maxConnsPerIP,
+		maxUnauthenticated: maxUnauthenticated,
+		ticketFailureLimit: ticketFailureLimit,
+		ticketBanDur:       ticketBanDur,
+		connsByIP:          make(map[string]int),
+		failuresByIP:       make(map[string]ticketFailures),
+		bannedUntilByIP:    make(map[string]time.Time),
+	}
+}
+
+// admit reserves an unauthenticated connection for the IP address, and returns the reason if it can't.
+func (g *connGuard) admit(ip string) (reason string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if until, ok := g.bannedUntilByIP[ip]; ok {
+		if time.Now().Before(until) {
+			return "banned", false
+		}
+		delete(g.bannedUntilByIP, ip)
+	}
+
+	if g.maxConnsPerIP > 0 && g.connsByIP[ip] >= g.maxConnsPerIP {
+		return "too many connections from ip", false
+	}
+
+	if g.maxUnauthenticated > 0 && g.unauthenticated >= g.maxUnauthenticated {
+		return "too many unauthenticated connections", false
+	}
+
+	g.connsByIP[ip]++
+	g.unauthenticated++
+
+	return "", true
+}
+
+// authenticate releases the unauthenticated reservation of an admitted connection.
+func (g *connGuard) authenticate() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.unauthenticated--
+}
+
+// release releases an admitted connection.
+func (g *connGuard) release(ip string, authenticated bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.connsByIP[ip]--
+	if g.connsByIP[ip] <= 0 {
+		delete(g.connsByIP, ip)
+	}
+
+	if !authenticated {
+		g.unauthenticated--
+	}
+}
+
+// ticketFailed records an invalid ticket sent from the IP address, and bans it once it reaches the limit of failures
+// within the ban duration. It returns true if the IP address got banned.
+func (g *connGuard) ticketFailed(ip string) bool {
+	if g.ticketFailureLimit <= 0 || g.ticketBanDur <= 0 {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+
+	f := g.failuresByIP[ip]
+	if f.count == 0 || now.Sub(f.first) > g.ticketBanDur {
+		f = ticketFailures{first: now}
+	}
+	f.count++
+
+	if f.count >= g.ticketFailureLimit {
+		delete(g.failuresByIP, ip)
+		g.bannedUntilByIP[ip] = now.Add(g.ticketBanDur)
+		return true
+	}
+
+	g.failuresByIP[ip] = f
+	return false
+}
+
+// prune forgets the expired bans and failures.
+func (g *connGuard) prune(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for ip, until := range g.bannedUntilByIP {
+		if !now.Before(until) {
+			delete(g.bannedUntilByIP, ip)
+		}
+	}
+	for ip, f := range g.failuresByIP {
+		if now.Sub(f.first) > g.ticketBanDur {
+			delete(g.failuresByIP, ip)
+		}
+	}
+
+	return nil
+}
+
+func addrIP(addr net.Addr) string {
+	ip, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return ip
+}
@@ -0,0 +1,50 @@
+package retropvp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_connGuard(t *testing.T) {
+	g := newConnGuard(2, 3, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := g.admit("1.1.1.1"); !ok {
+			t.Fatalf("admit %d: want ok", i)
+		}
+	}
+	if _, ok := g.admit("1.1.1.1"); ok {
+		t.Error("admit over the per ip limit: want refusal")
+	}
+
+	if _, ok := g.admit("2.2.2.2"); !ok {
+		t.Fatal("admit other ip: want ok")
+	}
+	if _, ok := g.admit("3.3.3.3"); ok {
+		t.Error("admit over the unauthenticated limit: want refusal")
+	}
+
+	g.authenticate()
+	if _, ok := g.admit("3.3.3.3"); !ok {
+		t.Error("admit after authentication: want ok")
+	}
+
+	g.release("1.1.1.1", true)
+	if _, ok := g.admit("1.1.1.1"); ok {
+		t.Error("admit over the unauthenticated limit after release: want refusal")
+	}
+	g.release("2.2.2.2", false)
+	if _, ok := g.admit("1.1.1.1"); !ok {
+		t.Error("admit after release: want ok")
+	}
+
+	if g.ticketFailed("4.4.4.4") {
+		t.Error("first ticket failure: want no ban")
+	}
+	if !g.ticketFailed("4.4.4.4") {
+		t.Error("second ticket failure: want ban")
+	}
+	if reason, ok := g.admit("4.4.4.4"); ok || reason != "banned" {
+		t.Errorf("admit banned ip: want refusal, got %q", reason)
+	}
+}
@@ -0,0 +1,117 @@
+package retropvp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kralamoure/retro"
+)
+
+// gameMapInstance is what's currently in a game map: the sessions whose character is in it and the items lying on the
+// ground.
+type gameMapInstance struct {
+	sessions    map[*session]struct{}
+	groundItems map[int]groundItem
+}
+
+// gameMapInstance returns the instance of the game map, creating it if it doesn't exist yet. s.gameMapsMu must be held.
+func (s *Server) gameMapInstance(gameMapId int) *gameMapInstance {
+	instance, ok := s.gameMapInstances[gameMapId]
+	if !ok {
+		instance = &gameMapInstance{
+			sessions:    make(map[*session]struct{}),
+			groundItems: make(map[int]groundItem),
+		}
+		s.gameMapInstances[gameMapId] = instance
+	}
+	return instance
+}
+
+// releaseGameMapInstance deletes the instance of the game map if it's empty. s.gameMapsMu must be held.
+func (s *Server) releaseGameMapInstance(gameMapId int) {
+	instance, ok := s.gameMapInstances[gameMapId]
+	if ok && len(instance.sessions) == 0 && len(instance.groundItems) == 0 {
+		delete(s.gameMapInstances, gameMapId)
+	}
+}
+
+// syncGameMap moves the session to the instance of the game map where its character is.
+func (s *Server) syncGameMap(sess *session) {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	gameMapId := 0
+	if sess.state != nil {
+		gameMapId = sess.state.character().GameMapId
+	}
+	s.moveToGameMap(sess, gameMapId)
+}
+
+// leaveGameMap removes the session from the instance of the game map where it is.
+func (s *Server) leaveGameMap(sess *session) {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	s.moveToGameMap(sess, 0)
+}
+
+// moveToGameMap moves the session to the instance of the game map, or to none if the id is 0. s.gameMapsMu must be held.
+func (s *Server) moveToGameMap(sess *session, gameMapId int) {
+	if sess.gameMapId == gameMapId {
+		return
+	}
+
+	if sess.gameMapId != 0 {
+		delete(s.gameMapInstance(sess.gameMapId).sessions, sess)
+		s.releaseGameMapInstance(sess.gameMapId)
+	}
+
+	if gameMapId != 0 {
+		s.gameMapInstance(gameMapId).sessions[sess] = struct{}{}
+	}
+
+	sess.gameMapId = gameMapId
+}
+
+func (s *Server) gameMapSessions(gameMapId int) []*session {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	instance, ok := s.gameMapInstances[gameMapId]
+	if !ok {
+		return nil
+	}
+
+	sessions := make([]*session, 0, len(instance.sessions))
+	for sess := range instance.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// gameMapCharacters returns the characters of the sessions that are in the game map.
+func (s *Server) gameMapCharacters(gameMapId int) map[int]retro.Character {
+	chars := make(map[int]retro.Character)
+	for _, sess := range s.gameMapSessions(gameMapId) {
+		if sess.state == nil {
+			continue
+		}
+		char := sess.state.character()
+		chars[char.Id] = char
+	}
+	return chars
+}
+
+func (s *Server) sendMsgToMap(ctx context.Context, gameMapId int, msg msgOut) error {
+	extra, err := msg.Serialized()
+	if err != nil {
+		return fmt.Errorf("could not serialize message: %w", err)
+	}
+	pkt := fmt.Sprint(msg.MessageId(), extra)
+
+	for _, sess := range s.gameMapSessions(gameMapId) {
+		sess.sendPacket(pkt)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package retropvp
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+func Test_Server_syncGameMap(t *testing.T) {
+	ctx := context.Background()
+	svr := testCharacterSession(&testCharacterStore{}).svr
+
+	sessions := make([]*session, 20)
+	for i := range sessions {
+		sessions[i] = testSession(svr, retro.Character{Id: 100 + i, Name: "Char", ClassId: 1}, nil)
+	}
+
+	// The sessions move between the game maps while their game maps are read and broadcast to.
+	var wg sync.WaitGroup
+	for i, sess := range sessions {
+		wg.Add(1)
+		go func(i int, sess *session) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sess.state.move(1+(i+j)%3, 0)
+				svr.syncGameMap(sess)
+			}
+		}(i, sess)
+	}
+	for gameMapId := 1; gameMapId <= 3; gameMapId++ {
+		wg.Add(1)
+		go func(gameMapId int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := svr.sendMsgToMap(ctx, gameMapId, msgsvr.BasicsNothing{})
+				if err != nil {
+					t.Error(err)
+				}
+				svr.gameMapCharacters(gameMapId)
+			}
+		}(gameMapId)
+	}
+	wg.Wait()
+
+	type testCase struct {
+		name      string
+		leave     func(i int) bool
+		wantCount int
+	}
+
+	testCases := []testCase{
+		{name: "moved", leave: func(i int) bool { return false }, wantCount: len(sessions)},
+		{name: "half left", leave: func(i int) bool { return i%2 == 0 }, wantCount: len(sessions) / 2},
+		{name: "all left", leave: func(i int) bool { return true }},
+	}
+
+	for _, tc := range testCases {
+		for i, sess := range sessions {
+			if tc.leave(i) {
+				svr.leaveGameMap(sess)
+			}
+		}
+
+		var count int
+		for gameMapId := 1; gameMapId <= 3; gameMapId++ {
+			for _, sess := range svr.gameMapSessions(gameMapId) {
+				if got := sess.state.character().GameMapId; got != gameMapId {
+					t.Errorf("%s: session of game map %d in the instance of %d", tc.name, got, gameMapId)
+				}
+				count++
+			}
+		}
+		if count != tc.wantCount {
+			t.Errorf("%s: sessions in instances: want %d, got %d", tc.name, tc.wantCount, count)
+		}
+	}
+
+	// Instances left empty are released.
+	if got := len(svr.gameMapInstances); got != 0 {
+		t.Errorf("instances: want 0, got %d", got)
+	}
+}
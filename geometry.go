@@ -0,0 +1,235 @@
+package retropvp
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retroutil"
+)
+
+// Distances between cells, in pixels, as used by the client.
+const (
+	cellWidth  = 53.0 // between two cells of the same row
+	cellHeight = 27.0 // between two cells of the same column
+)
+
+// gameMapGeometry is what's derived from the cells of a game map. It's built once per game map and never modified,
+// so it can be shared by every session.
+type gameMapGeometry struct {
+	gameMapId int
+	width     int
+	cells     []retroutil.Cell
+	walkable  []bool
+	neighbors [][8]int // by direction, -1 when there's no cell in that direction
+}
+
+func newGameMapGeometry(gameMap retro.GameMap) (*gameMapGeometry, error) {
+	cells, err := gameMap.Cells()
+	if err != nil {
+		return nil, err
+	}
+	return buildGameMapGeometry(gameMap.Id, gameMap.Width, cells), nil
+}
+
+func buildGameMapGeometry(gameMapId, width int, cells []retroutil.Cell) *gameMapGeometry {
+	g := &gameMapGeometry{
+		gameMapId: gameMapId,
+		width:     width,
+		cells:     cells,
+		walkable:  make([]bool, len(cells)),
+		neighbors: make([][8]int, len(cells)),
+	}
+
+	w := width
+	offsets := [8]int{1, w, w*2 - 1, w - 1, -1, -w, -w*2 + 1, -(w - 1)}
+	for id, cell := range cells {
+		g.walkable[id] = cell.Active && cell.Movement > 1
+
+		for dir, offset := range offsets {
+			g.neighbors[id][dir] = -1
+
+			next := id + offset
+			if next < 0 || next >= len(cells) {
+				continue
+			}
+			if cells[next].Active && math.Abs(cells[next].X-cell.X) <= cellWidth {
+				g.neighbors[id][dir] = next
+			}
+		}
+	}
+
+	return g
+}
+
+func (g *gameMapGeometry) valid(cellId int) bool {
+	return cellId >= 0 && cellId < len(g.cells)
+}
+
+// neighbor returns the cell next to the cell in the direction.
+func (g *gameMapGeometry) neighbor(cellId, dir int) (int, bool) {
+	if !g.valid(cellId) || dir < 0 || dir > 7 {
+		return 0, false
+	}
+	next := g.neighbors[cellId][dir]
+	return next, next != -1
+}
+
+func (g *gameMapGeometry) isWalkable(cellId int) bool {
+	return g.valid(cellId) && g.walkable[cellId]
+}
+
+// canStep tells if a character can walk from a cell to the next one, which must be walkable, not block the line of
+// sight and not be more than one level higher or lower.
+func (g *gameMapGeometry) canStep(from, to int) bool {
+	if !g.valid(from) || !g.isWalkable(to) || !g.cells[to].LineOfSight {
+		return false
+	}
+	return math.Abs(float64(g.cells[from].GroundLevel-g.cells[to].GroundLevel)) <= 1
+}
+
+// coordinates returns the coordinates of the cell in the grid the client uses for distances, where the cells in the
+// directions 1, 3, 5 and 7 differ by one on a single axis.
+func (g *gameMapGeometry) coordinates(cellId int) (x, y int) {
+	w := g.width
+	row := cellId / (w*2 - 1)
+	col := cellId - row*(w*2-1)
+	y = row - col%w
+	x = (cellId - (w-1)*y) / w
+	return x, y
+}
+
+func (g *gameMapGeometry) cellAt(x, y int) (int, bool) {
+	id := x*g.width + y*(g.width-1)
+	if !g.valid(id) {
+		return 0, false
+	}
+	if cx, cy := g.coordinates(id); cx != x || cy != y {
+		return 0, false
+	}
+	return id, true
+}
+
+// distance returns the number of orthogonal steps between two cells.
+func (g *gameMapGeometry) distance(a, b int) int {
+	ax, ay := g.coordinates(a)
+	bx, by := g.coordinates(b)
+	return abs(ax-bx) + abs(ay-by)
+}
+
+// lineOfSight tells if nothing between two cells blocks the line of sight.
+func (g *gameMapGeometry) lineOfSight(from, to int) bool {
+	if !g.valid(from) || !g.valid(to) {
+		return false
+	}
+
+	fx, fy := g.coordinates(from)
+	tx, ty := g.coordinates(to)
+	steps := max(abs(tx-fx), abs(ty-fy))
+	for i := 1; i < steps; i++ {
+		x := fx + int(math.Round(float64((tx-fx)*i)/float64(steps)))
+		y := fy + int(math.Round(float64((ty-fy)*i)/float64(steps)))
+
+		id, ok := g.cellAt(x, y)
+		if !ok {
+			return false
+		}
+		if id == from || id == to {
+			continue
+		}
+		if !g.cells[id].Active || !g.cells[id].LineOfSight {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// gameMapGeometries builds the geometry of each game map once, the first time it's needed, even if several sessions
+// need it at the same time.
+type gameMapGeometries struct {
+	mu          sync.Mutex
+	byGameMapId map[int]*geometryCall
+}
+
+type geometryCall struct {
+	done     chan struct{}
+	geometry *gameMapGeometry
+	err      error
+}
+
+func newGameMapGeometries() *gameMapGeometries {
+	return &gameMapGeometries{byGameMapId: make(map[int]*geometryCall)}
+}
+
+func (gs *gameMapGeometries) get(gameMap retro.GameMap) (*gameMapGeometry, error) {
+	gs.mu.Lock()
+	call, ok := gs.byGameMapId[gameMap.Id]
+	if ok {
+		gs.mu.Unlock()
+		<-call.done
+		return call.geometry, call.err
+	}
+	call = &geometryCall{done: make(chan struct{})}
+	gs.byGameMapId[gameMap.Id] = call
+	gs.mu.Unlock()
+
+	call.geometry, call.err = newGameMapGeometry(gameMap)
+	close(call.done)
+
+	if call.err != nil {
+		gs.mu.Lock()
+		if gs.byGameMapId[gameMap.Id] == call {
+			delete(gs.byGameMapId, gameMap.Id)
+		}
+		gs.mu.Unlock()
+	}
+
+	return call.geometry, call.err
+}
+
+// gameMapGeometry returns the geometry of a game map of the cache.
+func (c *cache) gameMapGeometry(gameMap retro.GameMap) (*gameMapGeometry, error) {
+	return c.geometries.get(gameMap)
+}
+
+// warmGameMapGeometries builds the geometry of every game map of the cache ahead of time.
+func (s *Server) warmGameMapGeometries(ctx context.Context, c *cache) {
+	ids := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				_, err := c.gameMapGeometry(c.static.gameMaps[id])
+				if err != nil {
+					s.logger.Debugw("could not build game map geometry",
+						"error", err,
+						"game_map_id", id,
+					)
+				}
+			}
+		}()
+	}
+
+	for id := range c.static.gameMaps {
+		select {
+		case ids <- id:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(ids)
+	wg.Wait()
+}
@@ -0,0 +1,106 @@
+package retropvp
+
+import (
+	"testing"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retroutil"
+)
+
+func Test_gameMapGeometry(t *testing.T) {
+	g := testGameMapGeometry(5, 5, func(cells []retroutil.Cell) {
+		cells[12].LineOfSight = false
+		cells[12].Movement = 0
+		cells[6].GroundLevel = 2
+		cells[20].Active = false
+	})
+
+	type testCase struct {
+		name string
+		got  any
+		want any
+	}
+
+	testCases := []testCase{
+		{name: "neighbor on the right", got: neighborOf(g, 0, 0), want: 1},
+		{name: "neighbor below", got: neighborOf(g, 0, 1), want: 5},
+		{name: "no neighbor off the map", got: neighborOf(g, 0, 4), want: -1},
+		{name: "no neighbor across the edge", got: neighborOf(g, 4, 0), want: -1},
+		{name: "no inactive neighbor", got: neighborOf(g, 15, 1), want: -1},
+		{name: "obstacle is not walkable", got: g.isWalkable(12), want: false},
+		{name: "cell is walkable", got: g.isWalkable(7), want: true},
+		{name: "cannot step up two levels", got: g.canStep(1, 6), want: false},
+		{name: "can step", got: g.canStep(1, 5), want: true},
+		{name: "distance to itself", got: g.distance(7, 7), want: 0},
+		{name: "distance to a neighbor", got: g.distance(7, 11), want: 1},
+		{name: "distance to a diagonal neighbor", got: g.distance(7, 8), want: 2},
+		{name: "line of sight blocked", got: g.lineOfSight(7, 17), want: false},
+		{name: "line of sight clear", got: g.lineOfSight(7, 16), want: true},
+	}
+
+	for _, tc := range testCases {
+		if tc.got != tc.want {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, tc.got)
+		}
+	}
+
+	for id := range g.cells {
+		x, y := g.coordinates(id)
+		got, ok := g.cellAt(x, y)
+		if !ok || got != id {
+			t.Errorf("cell at the coordinates of %d: want %d, got %d, %t", id, id, got, ok)
+		}
+	}
+}
+
+func neighborOf(g *gameMapGeometry, cellId, dir int) int {
+	next, ok := g.neighbor(cellId, dir)
+	if !ok {
+		return -1
+	}
+	return next
+}
+
+// testGameMapGeometry builds the geometry of a game map of the width with the rows of walkable cells, which edit can
+// change.
+func testGameMapGeometry(width, rows int, edit func(cells []retroutil.Cell)) *gameMapGeometry {
+	cells := make([]retroutil.Cell, (width*2-1)*rows+width)
+	for i := range cells {
+		cells[i] = retroutil.Cell{Id: i, Active: true, LineOfSight: true, Movement: 4}
+	}
+	if edit != nil {
+		edit(cells)
+	}
+	return buildGameMapGeometry(1, width, retroutil.BuiltCells(nil, false, width, cells))
+}
+
+func Test_cache_gameMapGeometry(t *testing.T) {
+	old := &cache{geometries: newGameMapGeometries()}
+	reloaded := &cache{geometries: newGameMapGeometries()}
+
+	type testCase struct {
+		name    string
+		cache   *cache
+		gameMap retro.GameMap
+		want    int
+	}
+
+	// A reader still holding the old snapshot builds the old game map after the reload, which mustn't be what the
+	// readers of the new snapshot see.
+	testCases := []testCase{
+		{name: "reloaded", cache: reloaded, gameMap: retro.GameMap{Id: 1, Width: 7}, want: 7},
+		{name: "stale", cache: old, gameMap: retro.GameMap{Id: 1, Width: 5}, want: 5},
+		{name: "reloaded after stale", cache: reloaded, gameMap: retro.GameMap{Id: 1, Width: 7}, want: 7},
+	}
+
+	for _, tc := range testCases {
+		got, err := tc.cache.gameMapGeometry(tc.gameMap)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if got.width != tc.want {
+			t.Errorf("%s: width: want %d, got %d", tc.name, tc.want, got.width)
+		}
+	}
+}
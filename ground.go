@@ -0,0 +1,211 @@
+package retropvp
+
+import (
+	"context"
+	"time"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+type groundItem struct {
+	retro.Item
+	ownerId int
+	dropped time.Time
+}
+
+// placeGroundItem puts the item on the given cell or, if it's not free, on the first free cell around it.
+func (s *Server) placeGroundItem(gameMapId, cellId int, geometry *gameMapGeometry, item groundItem) (int, bool) {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	instance := s.gameMapInstance(gameMapId)
+	defer s.releaseGameMapInstance(gameMapId)
+
+	items := instance.groundItems
+
+	free := func(id int) bool {
+		if !geometry.isWalkable(id) {
+			return false
+		}
+		_, ok := items[id]
+		return !ok
+	}
+
+	candidates := []int{cellId}
+	for dir := 0; dir < 8; dir++ {
+		id, ok := geometry.neighbor(cellId, dir)
+		if ok {
+			candidates = append(candidates, id)
+		}
+	}
+
+	for _, id := range candidates {
+		if free(id) {
+			items[id] = item
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+func (s *Server) removeGroundItem(gameMapId, cellId int) {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	instance, ok := s.gameMapInstances[gameMapId]
+	if !ok {
+		return
+	}
+	delete(instance.groundItems, cellId)
+	s.releaseGameMapInstance(gameMapId)
+}
+
+// takeGroundItem removes and returns the item lying on the cell, unless it's still protected for its owner.
+func (s *Server) takeGroundItem(gameMapId, cellId, characterId int) (item groundItem, ok bool, protected bool) {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	instance, ok := s.gameMapInstances[gameMapId]
+	if !ok {
+		return
+	}
+
+	item, ok = instance.groundItems[cellId]
+	if !ok {
+		return
+	}
+
+	if item.ownerId != characterId && time.Since(item.dropped) < s.groundItemProtection {
+		return groundItem{}, false, true
+	}
+
+	delete(instance.groundItems, cellId)
+	s.releaseGameMapInstance(gameMapId)
+
+	return
+}
+
+// returnGroundItem puts the item back on the cell it was taken from, unless another one was dropped on it since.
+func (s *Server) returnGroundItem(gameMapId, cellId int, item groundItem) bool {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	instance := s.gameMapInstance(gameMapId)
+	defer s.releaseGameMapInstance(gameMapId)
+
+	if _, ok := instance.groundItems[cellId]; ok {
+		return false
+	}
+	instance.groundItems[cellId] = item
+	return true
+}
+
+func (s *Server) groundCellObjects(gameMapId int) gameCellObject {
+	s.gameMapsMu.Lock()
+	defer s.gameMapsMu.Unlock()
+
+	var m gameCellObject
+	instance, ok := s.gameMapInstances[gameMapId]
+	if !ok {
+		return m
+	}
+	for cellId, v := range instance.groundItems {
+		m.Objects = append(m.Objects, gameCellObjectObject{
+			CellId:     cellId,
+			TemplateId: v.TemplateId,
+		})
+	}
+
+	return m
+}
+
+func (s *Server) despawnGroundItems(ctx context.Context) error {
+	if s.groundItemLifetime <= 0 {
+		return nil
+	}
+
+	removed := make(map[int]gameCellObject)
+
+	s.gameMapsMu.Lock()
+	for gameMapId, instance := range s.gameMapInstances {
+		for cellId, v := range instance.groundItems {
+			if time.Since(v.dropped) < s.groundItemLifetime {
+				continue
+			}
+			delete(instance.groundItems, cellId)
+
+			m := removed[gameMapId]
+			m.Objects = append(m.Objects, gameCellObjectObject{
+				Remove: true,
+				CellId: cellId,
+			})
+			removed[gameMapId] = m
+		}
+		s.releaseGameMapInstance(gameMapId)
+	}
+	s.gameMapsMu.Unlock()
+
+	for gameMapId, m := range removed {
+		err := s.sendMsgToMap(ctx, gameMapId, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returnGroundItems takes every item off the ground and gives it back to the character who dropped it, as the items
+// on the ground only live in memory. It's done when shutting down, so the items are created in the storage directly
+// rather than through the state of the sessions, whose flushes never touch the items they don't know about.
+func (s *Server) returnGroundItems(ctx context.Context) error {
+	itemsByOwnerId := make(map[int][]retro.Item)
+
+	s.gameMapsMu.Lock()
+	for gameMapId, instance := range s.gameMapInstances {
+		for cellId, v := range instance.groundItems {
+			delete(instance.groundItems, cellId)
+			if v.ownerId == 0 {
+				continue
+			}
+			itemsByOwnerId[v.ownerId] = append(itemsByOwnerId[v.ownerId], v.Item)
+		}
+		s.releaseGameMapInstance(gameMapId)
+	}
+	s.gameMapsMu.Unlock()
+
+	for ownerId, items := range itemsByOwnerId {
+		err := s.giveItems(ctx, ownerId, items)
+		if err != nil {
+			s.logger.Errorw("could not return ground items",
+				"error", err,
+				"character_id", ownerId,
+				"items", len(items),
+			)
+		}
+	}
+
+	return nil
+}
+
+// giveItems creates the items in the inventory of the character, in a single save.
+func (s *Server) giveItems(ctx context.Context, characterId int, items []retro.Item) error {
+	var changes CharacterChanges
+	for _, item := range items {
+		id, err := s.characters.NextCharacterItemId(ctx)
+		if err != nil {
+			return err
+		}
+		item.Id = id
+
+		changes.CreatedItems = append(changes.CreatedItems, retro.CharacterItem{
+			Item:        item,
+			Position:    retrotyp.CharacterItemPositionInventory,
+			CharacterId: characterId,
+		})
+	}
+
+	return s.characters.SaveCharacter(ctx, changes)
+}
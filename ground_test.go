@@ -0,0 +1,255 @@
+package retropvp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroutil"
+)
+
+func Test_Server_placeGroundItem(t *testing.T) {
+	g := testGameMapGeometry(5, 5, func(cells []retroutil.Cell) {
+		cells[12].Movement = 0
+	})
+
+	type testCase struct {
+		name     string
+		cellId   int
+		occupied []int
+
+		want   int
+		wantOk bool
+	}
+
+	testCases := []testCase{
+		{name: "free", cellId: 16, want: 16, wantOk: true},
+		{name: "occupied", cellId: 16, occupied: []int{16}, want: neighborOf(g, 16, 0), wantOk: true},
+		{name: "not walkable", cellId: 12, want: neighborOf(g, 12, 0), wantOk: true},
+		{name: "all occupied", cellId: 0, occupied: []int{0, neighborOf(g, 0, 0), neighborOf(g, 0, 1), neighborOf(g, 0, 2)}},
+	}
+
+	for _, tc := range testCases {
+		s := testCharacterSession(&testCharacterStore{})
+		for _, id := range tc.occupied {
+			s.svr.placeGroundItem(1, id, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 1}})
+		}
+
+		got, ok := s.svr.placeGroundItem(1, tc.cellId, g, groundItem{Item: retro.Item{TemplateId: 30, Quantity: 1}})
+		if ok != tc.wantOk {
+			t.Errorf("%s: placed: want %t, got %t", tc.name, tc.wantOk, ok)
+			continue
+		}
+		if ok && got != tc.want {
+			t.Errorf("%s: cell: want %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func Test_Server_takeGroundItem(t *testing.T) {
+	g := testGameMapGeometry(5, 5, nil)
+
+	type testCase struct {
+		name        string
+		characterId int
+		age         time.Duration
+		cellId      int
+
+		wantOk        bool
+		wantProtected bool
+	}
+
+	testCases := []testCase{
+		{name: "owner", characterId: 1, wantOk: true},
+		{name: "other, protected", characterId: 2, wantProtected: true},
+		{name: "other, no longer protected", characterId: 2, age: 2 * time.Minute, wantOk: true},
+		{name: "nothing", characterId: 1, cellId: 17},
+	}
+
+	for _, tc := range testCases {
+		s := testCharacterSession(&testCharacterStore{})
+		s.svr.groundItemProtection = 1 * time.Minute
+		s.svr.placeGroundItem(1, 16, g, groundItem{
+			Item:    retro.Item{TemplateId: 20, Quantity: 1},
+			ownerId: 1,
+			dropped: time.Now().Add(-tc.age),
+		})
+
+		cellId := 16
+		if tc.cellId != 0 {
+			cellId = tc.cellId
+		}
+		_, ok, protected := s.svr.takeGroundItem(1, cellId, tc.characterId)
+		if ok != tc.wantOk || protected != tc.wantProtected {
+			t.Errorf("%s: want %t, protected %t, got %t, protected %t", tc.name, tc.wantOk, tc.wantProtected, ok, protected)
+		}
+
+		// Taking the only item left releases the instance of the game map.
+		_, exists := s.svr.gameMapInstances[1]
+		if exists == ok {
+			t.Errorf("%s: instance exists: want %t, got %t", tc.name, !ok, exists)
+		}
+	}
+}
+
+func Test_session_pickUpGroundItem(t *testing.T) {
+	ctx := context.Background()
+	g := testGameMapGeometry(5, 5, nil)
+
+	type testCase struct {
+		name    string
+		failIds bool
+
+		wantErr      bool
+		wantOnGround bool
+		wantItems    int
+	}
+
+	testCases := []testCase{
+		{name: "picked up", wantItems: 2},
+		{name: "inventory failed", failIds: true, wantErr: true, wantOnGround: true, wantItems: 1},
+	}
+
+	for _, tc := range testCases {
+		store := &testCharacterStore{failIds: tc.failIds}
+		s := testCharacterSession(store)
+		s.svr.currentCache.Store(&cache{static: cacheStatic{
+			items: map[int]retro.ItemTemplate{
+				10: {Id: 10, Type: retrotyp.ItemTypeUsableItem, Weight: 1},
+				20: {Id: 20, Type: retrotyp.ItemTypeAmulet, Weight: 1},
+			},
+		}})
+		s.svr.placeGroundItem(1, 16, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 1}, ownerId: 1})
+
+		err := s.pickUpGroundItem(ctx, 1, 16)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+		}
+
+		onGround := len(s.svr.groundCellObjects(1).Objects) == 1
+		if onGround != tc.wantOnGround {
+			t.Errorf("%s: on the ground: want %t, got %t", tc.name, tc.wantOnGround, onGround)
+		}
+
+		items, _ := s.characterItems(ctx)
+		if len(items) != tc.wantItems {
+			t.Errorf("%s: items: want %d, got %d", tc.name, tc.wantItems, len(items))
+		}
+	}
+}
+
+func Test_Server_despawnGroundItems(t *testing.T) {
+	ctx := context.Background()
+	g := testGameMapGeometry(5, 5, nil)
+
+	type testCase struct {
+		name     string
+		lifetime time.Duration
+
+		wantCells int
+	}
+
+	testCases := []testCase{
+		{name: "despawned", lifetime: 1 * time.Hour, wantCells: 1},
+		{name: "no lifetime", wantCells: 2},
+	}
+
+	for _, tc := range testCases {
+		s := testCharacterSession(&testCharacterStore{})
+		s.svr.groundItemLifetime = tc.lifetime
+		s.svr.placeGroundItem(1, 16, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 1}, dropped: time.Now()})
+		s.svr.placeGroundItem(1, 17, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 1}, dropped: time.Now().Add(-2 * time.Hour)})
+
+		err := s.svr.despawnGroundItems(ctx)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		got := s.svr.groundCellObjects(1).Objects
+		if len(got) != tc.wantCells {
+			t.Errorf("%s: items: want %d, got %d", tc.name, tc.wantCells, len(got))
+		}
+	}
+}
+
+func Test_gameActionsUseObject_Deserialize(t *testing.T) {
+	type testCase struct {
+		extra string
+
+		want    gameActionsUseObject
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{extra: "243", want: gameActionsUseObject{CellId: 243}},
+		{extra: "243;102", want: gameActionsUseObject{CellId: 243, SkillId: 102}},
+		{extra: "", wantErr: true},
+		{extra: "243;", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		var got gameActionsUseObject
+		err := got.Deserialize(tc.extra)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: want error %t, got %v", tc.extra, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("%q: want %+v, got %+v", tc.extra, tc.want, got)
+		}
+	}
+}
+
+func Test_Server_returnGroundItems(t *testing.T) {
+	ctx := context.Background()
+	g := testGameMapGeometry(5, 5, nil)
+
+	type testCase struct {
+		name string
+		fail bool
+
+		wantQuantities map[int]int
+	}
+
+	testCases := []testCase{
+		{name: "returned", wantQuantities: map[int]int{1: 5, 2: 4}},
+		{name: "save failed", fail: true, wantQuantities: map[int]int{}},
+	}
+
+	for _, tc := range testCases {
+		store := &testCharacterStore{fail: tc.fail}
+		s := testCharacterSession(store)
+		s.svr.placeGroundItem(1, 16, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 2}, ownerId: 1})
+		s.svr.placeGroundItem(2, 16, g, groundItem{Item: retro.Item{TemplateId: 30, Quantity: 3}, ownerId: 1})
+		s.svr.placeGroundItem(1, 17, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 4}, ownerId: 2})
+		s.svr.placeGroundItem(1, 18, g, groundItem{Item: retro.Item{TemplateId: 20, Quantity: 1}})
+
+		err := s.svr.returnGroundItems(ctx)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		got := make(map[int]int)
+		for _, c := range store.saved {
+			for _, v := range c.CreatedItems {
+				if v.Position != retrotyp.CharacterItemPositionInventory {
+					t.Errorf("%s: position: want inventory, got %d", tc.name, v.Position)
+				}
+				got[v.CharacterId] += v.Quantity
+			}
+		}
+		if !reflect.DeepEqual(got, tc.wantQuantities) {
+			t.Errorf("%s: returned quantities: want %v, got %v", tc.name, tc.wantQuantities, got)
+		}
+
+		// Nothing is left on the ground, even when it couldn't be returned.
+		if n := len(s.svr.gameMapInstances); n != 0 {
+			t.Errorf("%s: instances: want 0, got %d", tc.name, n)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroproto/msgsvr"
+	prototyp "github.com/kralamoure/retroproto/typ"
+)
+
+const (
+	effectIdTeleport          = 4
+	effectIdRecall            = 600
+	effectIdTeleportToGameMap = 601
+	effectIdAddXP             = 605
+	effectIdAddStrength       = 607
+	effectIdAddWisdom         = 608
+	effectIdAddChance         = 609
+	effectIdAddAgility        = 610
+	effectIdAddVitality       = 611
+	effectIdAddIntelligence   = 612
+	effectIdMountId           = 995
+
+	// Custom effects, which are not part of the official game data. sql/retropvp.sql gives them to the item templates
+	// that need them.
+	effectIdToggleMount = 10001
+	effectIdOpenShed    = 10002
+	effectIdLoot        = 10003
+)
+
+type itemUseTarget uint8
+
+const (
+	itemUseTargetSelf itemUseTarget = 1 << iota
+	itemUseTargetCharacter
+	itemUseTargetCell
+)
+
+type itemUse struct {
+	item    retro.CharacterItem
+	effects []retrotyp.Effect
+	target  *session
+	cellId  int
+}
+
+// itemUseEffect is what an effect does when its item is used. apply runs on the goroutine of the target session, after
+// every effect of the item was checked.
+type itemUseEffect struct {
+	targets itemUseTarget
+	consume bool
+	check   func(s *session, ctx context.Context, use itemUse) error
+	apply   func(s *session, ctx context.Context, use itemUse) error
+}
+
+// itemUseTypes are the types of the items that can be used.
+var itemUseTypes = map[retrotyp.ItemType]bool{
+	retrotyp.ItemTypeUsableItem:       true,
+	retrotyp.ItemTypeMountCertificate: true,
+}
+
+// itemUseEffects are the effects that using an item can have, by effect id. An item is consumed, once its effects
+// were applied, if any of them is consumable.
+var itemUseEffects = map[int]itemUseEffect{
+	effectIdRecall: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		check:   (*session).checkRecall,
+		apply:   (*session).useRecall,
+	},
+	effectIdTeleport: {
+		targets: itemUseTargetCell,
+		consume: true,
+		apply:   (*session).useTeleport,
+	},
+	effectIdTeleportToGameMap: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		check:   (*session).checkTeleportToGameMap,
+		apply:   (*session).useTeleportToGameMap,
+	},
+	effectIdAddXP: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddXP,
+	},
+	effectIdAddStrength: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddStat,
+	},
+	effectIdAddWisdom: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddStat,
+	},
+	effectIdAddChance: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddStat,
+	},
+	effectIdAddAgility: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddStat,
+	},
+	effectIdAddVitality: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddStat,
+	},
+	effectIdAddIntelligence: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply:   (*session).useAddStat,
+	},
+	effectIdMountId: {
+		targets: itemUseTargetSelf,
+		apply:   (*session).useMountCertificate,
+	},
+	effectIdToggleMount: {
+		targets: itemUseTargetSelf,
+		apply:   (*session).useToggleMount,
+	},
+	effectIdOpenShed: {
+		targets: itemUseTargetSelf,
+		apply:   (*session).useOpenShed,
+	},
+	effectIdLoot: {
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		check:   (*session).checkLoot,
+		apply:   (*session).useLoot,
+	},
+}
+
+var itemUseStatByEffectId = map[int]retrotyp.CharacteristicId{
+	effectIdAddStrength:     retrotyp.CharacteristicIdStrength,
+	effectIdAddWisdom:       retrotyp.CharacteristicIdWisdom,
+	effectIdAddChance:       retrotyp.CharacteristicIdChance,
+	effectIdAddAgility:      retrotyp.CharacteristicIdAgility,
+	effectIdAddVitality:     retrotyp.CharacteristicIdVitality,
+	effectIdAddIntelligence: retrotyp.CharacteristicIdIntelligence,
+}
+
+func (s *session) useItem(ctx context.Context, item retro.CharacterItem, t retro.ItemTemplate, spriteId, cellId int) error {
+	if !itemUseTypes[t.Type] {
+		return errNotImplemented
+	}
+
+	target := itemUseTargetSelf
+	if spriteId != 0 {
+		target = itemUseTargetCharacter
+	} else if cellId != 0 {
+		target = itemUseTargetCell
+	}
+
+	if target != itemUseTargetSelf && !t.CanTarget {
+		return errInvalidRequest
+	}
+
+	ids, effectsById := groupedItemUseEffects(t.Effects)
+	if len(ids) == 0 {
+		return errNotImplemented
+	}
+
+	consume := false
+	for _, id := range ids {
+		e := itemUseEffects[id]
+		if e.targets&target == 0 {
+			return errNotAllowed
+		}
+		if e.consume {
+			consume = true
+		}
+	}
+
+	use := itemUse{
+		item:   item,
+		target: s,
+		cellId: cellId,
+	}
+
+	switch target {
+	case itemUseTargetCharacter:
+		char, err := s.character(ctx)
+		if err != nil {
+			return err
+		}
+
+		otherChar, err := s.svr.character(ctx, spriteId)
+		if err != nil {
+			return err
+		}
+
+		if otherChar.GameMapId != char.GameMapId {
+			return errInvalidRequest
+		}
+
+		s.svr.mu.Lock()
+		otherSess, ok := s.svr.sessionByCharacterId[otherChar.Id]
+		s.svr.mu.Unlock()
+		if !ok {
+			return errInvalidRequest
+		}
+		use.target = otherSess
+	case itemUseTargetCell:
+		char, err := s.character(ctx)
+		if err != nil {
+			return err
+		}
+
+		c := s.svr.cache()
+		gameMap, ok := c.static.gameMaps[char.GameMapId]
+		if !ok {
+			return errors.New("game map not found")
+		}
+
+		geometry, err := c.gameMapGeometry(gameMap)
+		if err != nil {
+			return err
+		}
+
+		if !geometry.isWalkable(cellId) {
+			return errInvalidRequest
+		}
+	}
+
+	for _, id := range ids {
+		check := itemUseEffects[id].check
+		if check == nil {
+			continue
+		}
+
+		use.effects = effectsById[id]
+		err := check(s, ctx, use)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := use.target.do(ctx, s, func(ctx context.Context) error {
+		if use.target != s {
+			// The target may have moved while the effects were checked.
+			char, err := s.character(ctx)
+			if err != nil {
+				return err
+			}
+			otherChar, err := use.target.character(ctx)
+			if err != nil {
+				return err
+			}
+			if otherChar.GameMapId != char.GameMapId {
+				return errNotAllowed
+			}
+		}
+
+		for _, id := range ids {
+			use.effects = effectsById[id]
+			err := itemUseEffects[id].apply(s, ctx, use)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errSessionClosed) {
+			return errNotAllowed
+		}
+		return err
+	}
+
+	if consume {
+		err := s.removeItem(ctx, item.Id, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.sendWeight(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// groupedItemUseEffects returns the ids of the registered use effects in the order they first appear, and the effects
+// grouped by id.
+func groupedItemUseEffects(effects []retrotyp.Effect) ([]int, map[int][]retrotyp.Effect) {
+	var ids []int
+	effectsById := make(map[int][]retrotyp.Effect)
+	for _, v := range effects {
+		_, ok := itemUseEffects[v.Id]
+		if !ok {
+			continue
+		}
+
+		if _, ok := effectsById[v.Id]; !ok {
+			ids = append(ids, v.Id)
+		}
+		effectsById[v.Id] = append(effectsById[v.Id], v)
+	}
+	return ids, effectsById
+}
+
+func effectRoll(effect retrotyp.Effect) int {
+	if effect.DiceSide > effect.DiceNum {
+		return effect.DiceNum + rand.Intn(effect.DiceSide-effect.DiceNum+1)
+	}
+	return effect.DiceNum
+}
+
+func (s *session) useTeleport(ctx context.Context, use itemUse) error {
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.teleport(ctx, char.GameMapId, use.cellId)
+}
+
+// checkTeleportToGameMap checks the destination, which is DiceNum the game map id and DiceSide the cell id.
+func (s *session) checkTeleportToGameMap(ctx context.Context, use itemUse) error {
+	effect := use.effects[0]
+	_, err := s.svr.teleportDestination(effect.DiceNum, effect.DiceSide)
+	if err != nil {
+		s.svr.logger.Warnw("invalid item use effect",
+			"error", err,
+			"item_template_id", use.item.TemplateId,
+			"effect_id", effect.Id,
+		)
+		return errNotAllowed
+	}
+	return nil
+}
+
+func (s *session) useTeleportToGameMap(ctx context.Context, use itemUse) error {
+	effect := use.effects[0]
+	return use.target.teleport(ctx, effect.DiceNum, effect.DiceSide)
+}
+
+func (s *session) useAddXP(ctx context.Context, use itemUse) error {
+	for _, effect := range use.effects {
+		err := use.target.addXP(ctx, effectRoll(effect))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) useAddStat(ctx context.Context, use itemUse) error {
+	for _, effect := range use.effects {
+		err := use.target.addStat(ctx, itemUseStatByEffectId[effect.Id], effectRoll(effect))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) useMountCertificate(ctx context.Context, use itemUse) error {
+	err := s.equip(ctx, use.item.Id, retrotyp.CharacterItemPositionDragoturkey)
+	if err != nil {
+		return err
+	}
+
+	return s.checkConditions(ctx)
+}
+
+func (s *session) useToggleMount(ctx context.Context, use itemUse) error {
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.mountOrDismount(ctx, !char.Mounting)
+}
+
+func (s *session) useOpenShed(ctx context.Context, use itemUse) error {
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	mounts, err := s.characterMounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var shed []prototyp.CommonMountData
+	for _, mount := range mounts {
+		if mount.Id == char.MountId {
+			continue
+		}
+
+		data, err := s.svr.commonMountData(mount)
+		if err != nil {
+			return err
+		}
+		shed = append(shed, data)
+	}
+
+	s.sendMessage(msgsvr.ExchangeCreateSuccess{
+		Type: retrotyp.ExchangePaddock,
+		Paddock: msgsvr.ExchangeCreateSuccessPaddock{
+			Shed:    shed,
+			Paddock: nil,
+		},
+	})
+
+	return nil
+}
+
+func (s *session) checkLoot(ctx context.Context, use itemUse) error {
+	for _, effect := range use.effects {
+		if _, ok := s.svr.cache().static.items[effect.DiceNum]; !ok {
+			s.svr.logger.Warnw("invalid item use effect",
+				"error", "item template not found",
+				"item_template_id", use.item.TemplateId,
+				"effect_id", effect.Id,
+			)
+			return errNotAllowed
+		}
+	}
+	return nil
+}
+
+// useLoot gives one of the loot effects, picked at random. Each loot effect has the item template id as DiceNum, the
+// quantity as DiceSide and the weight of the pick as Value.
+func (s *session) useLoot(ctx context.Context, use itemUse) error {
+	total := 0
+	for _, effect := range use.effects {
+		total += max(effect.Value, 1)
+	}
+
+	n := rand.Intn(total)
+	for _, effect := range use.effects {
+		n -= max(effect.Value, 1)
+		if n >= 0 {
+			continue
+		}
+
+		t, ok := s.svr.cache().static.items[effect.DiceNum]
+		if !ok {
+			return errors.New("item template not found")
+		}
+
+		effects := make([]retrotyp.Effect, len(t.Effects))
+		for i, v := range t.Effects {
+			v.DiceNum = effectRoll(v)
+			v.DiceSide = 0
+			effects[i] = v
+		}
+
+		_, err := use.target.addItemToInventory(ctx, retro.Item{
+			TemplateId: t.Id,
+			Quantity:   max(effect.DiceSide, 1),
+			Effects:    effects,
+		})
+		if err != nil {
+			return err
+		}
+
+		if use.target != s {
+			return use.target.sendWeight(ctx)
+		}
+		break
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+// serveTasks runs the tasks sent to the session until it's done, as its packet handling goroutine would.
+func serveTasks(ctx context.Context, s *session) {
+	for {
+		select {
+		case task := <-s.tasks:
+			task(ctx)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func Test_session_useItem(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		effectIdTestApply = 90001 + iota
+		effectIdTestCheckFails
+		effectIdTestApplyFails
+		effectIdTestKeep
+	)
+
+	var appliedTo []*session
+	itemUseEffects[effectIdTestApply] = itemUseEffect{
+		targets: itemUseTargetSelf | itemUseTargetCharacter,
+		consume: true,
+		apply: func(s *session, ctx context.Context, use itemUse) error {
+			appliedTo = append(appliedTo, use.target)
+			return nil
+		},
+	}
+	itemUseEffects[effectIdTestCheckFails] = itemUseEffect{
+		targets: itemUseTargetSelf,
+		consume: true,
+		check: func(s *session, ctx context.Context, use itemUse) error {
+			return errNotAllowed
+		},
+		apply: func(s *session, ctx context.Context, use itemUse) error {
+			appliedTo = append(appliedTo, use.target)
+			return nil
+		},
+	}
+	itemUseEffects[effectIdTestApplyFails] = itemUseEffect{
+		targets: itemUseTargetSelf,
+		consume: true,
+		apply: func(s *session, ctx context.Context, use itemUse) error {
+			return errNotAllowed
+		},
+	}
+	itemUseEffects[effectIdTestKeep] = itemUseEffect{
+		targets: itemUseTargetSelf,
+		apply: func(s *session, ctx context.Context, use itemUse) error {
+			appliedTo = append(appliedTo, use.target)
+			return nil
+		},
+	}
+	defer func() {
+		for _, id := range []int{effectIdTestApply, effectIdTestCheckFails, effectIdTestApplyFails, effectIdTestKeep} {
+			delete(itemUseEffects, id)
+		}
+	}()
+
+	type testCase struct {
+		name        string
+		itemType    retrotyp.ItemType
+		effects     []retrotyp.Effect
+		itemEffects []retrotyp.Effect
+		target      int // 0 for self, 2 for the other character, 3 for a character that logged out
+		canTarget   bool
+
+		wantErr      error
+		wantApplied  int
+		wantQuantity int
+	}
+
+	testCases := []testCase{
+		{
+			name:         "consumed once applied",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}},
+			wantApplied:  1,
+			wantQuantity: 4,
+		},
+		{
+			name:         "effects grouped",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}, {Id: 1}, {Id: effectIdTestApply}, {Id: effectIdTestKeep}},
+			wantApplied:  2,
+			wantQuantity: 4,
+		},
+		{
+			name:         "not consumable",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestKeep}},
+			wantApplied:  1,
+			wantQuantity: 5,
+		},
+		{
+			name:         "check fails",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}, {Id: effectIdTestCheckFails}},
+			wantErr:      errNotAllowed,
+			wantQuantity: 5,
+		},
+		{
+			name:         "apply fails",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApplyFails}},
+			wantErr:      errNotAllowed,
+			wantQuantity: 5,
+		},
+		{
+			name:         "not usable type",
+			itemType:     retrotyp.ItemTypeAmulet,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}},
+			wantErr:      errNotImplemented,
+			wantQuantity: 5,
+		},
+		{
+			name:         "no use effect",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: 1}},
+			wantErr:      errNotImplemented,
+			wantQuantity: 5,
+		},
+		{
+			name:         "effects of the item only",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			itemEffects:  []retrotyp.Effect{{Id: effectIdTestApply}},
+			wantErr:      errNotImplemented,
+			wantQuantity: 5,
+		},
+		{
+			name:         "other character",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}},
+			target:       2,
+			canTarget:    true,
+			wantApplied:  1,
+			wantQuantity: 4,
+		},
+		{
+			name:         "other character not allowed",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestKeep}},
+			target:       2,
+			canTarget:    true,
+			wantErr:      errNotAllowed,
+			wantQuantity: 5,
+		},
+		{
+			name:         "item can't target",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}},
+			target:       2,
+			wantErr:      errInvalidRequest,
+			wantQuantity: 5,
+		},
+		{
+			name:         "other character logged out",
+			itemType:     retrotyp.ItemTypeUsableItem,
+			effects:      []retrotyp.Effect{{Id: effectIdTestApply}},
+			target:       3,
+			canTarget:    true,
+			wantErr:      errNotAllowed,
+			wantQuantity: 5,
+		},
+	}
+
+	for _, tc := range testCases {
+		appliedTo = nil
+
+		s := testCharacterSession(&testCharacterStore{})
+		s.svr.currentCache.Store(&cache{static: cacheStatic{
+			items: map[int]retro.ItemTemplate{
+				10: {Id: 10, Type: tc.itemType, CanTarget: tc.canTarget, Weight: 1, Effects: tc.effects},
+			},
+		}})
+
+		other := testSession(s.svr, retro.Character{Id: 2, Name: "Alice", ClassId: 1}, nil)
+		go serveTasks(ctx, other)
+		gone := testSession(s.svr, retro.Character{Id: 3, Name: "Carol", ClassId: 1}, nil)
+		close(gone.done)
+
+		item, _ := s.characterItem(ctx, 1)
+		item.Effects = tc.itemEffects
+		s.updateCharacterItem(ctx, item)
+
+		err := s.useItem(ctx, item, s.svr.cache().static.items[10], tc.target, 0)
+		close(other.done)
+
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: error: want %v, got %v", tc.name, tc.wantErr, err)
+		}
+
+		if len(appliedTo) != tc.wantApplied {
+			t.Errorf("%s: applied: want %d, got %d", tc.name, tc.wantApplied, len(appliedTo))
+		}
+		for _, v := range appliedTo {
+			want := s
+			if tc.target != 0 {
+				want = other
+			}
+			if v != want {
+				t.Errorf("%s: target: want character %d, got %d", tc.name, want.characterId, v.characterId)
+			}
+		}
+
+		item, _ = s.characterItem(ctx, 1)
+		if item.Quantity != tc.wantQuantity {
+			t.Errorf("%s: quantity: want %d, got %d", tc.name, tc.wantQuantity, item.Quantity)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package retropvp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/kralamoure/retro"
 	"github.com/kralamoure/retro/retrotyp"
@@ -22,11 +26,13 @@ func (s *Server) marketTemplateIdsByItemType(ctx context.Context, market retro.M
 		return nil, errInvalidRequest
 	}
 
-	marketItems := s.cache.marketItemsByMarketId[market.Id]
+	c := s.cache()
+	marketItems := c.listings.items(market.Id)
+	itemTemplates := c.static.items
 
 	templateIds := make(map[int]struct{})
 	for _, v := range marketItems {
-		itemTemplate, ok := s.cache.static.items[v.TemplateId]
+		itemTemplate, ok := itemTemplates[v.TemplateId]
 		if !ok {
 			return nil, fmt.Errorf("invalid item template: %d", v.TemplateId)
 		}
@@ -47,39 +53,286 @@ func (s *Server) marketTemplateIdsByItemType(ctx context.Context, market retro.M
 	return sli, nil
 }
 
+// MarketPriceStorer stores the price of each market item in each quantity its market sells, so the larger quantities
+// don't have to cost their number of units.
+type MarketPriceStorer interface {
+	// MarketItemPrices returns the prices of the items of the market, by item id, in the order of the quantities of the
+	// market. A price is 0 when the item isn't sold in that quantity.
+	MarketItemPrices(ctx context.Context, marketId string) (map[int][3]int, error)
+}
+
+type marketLot struct {
+	retro.Item
+	Prices [3]int
+}
+
 func (s *Server) marketItemsByTemplateId(ctx context.Context, market retro.Market, templateId int) ([]prototyp.ExchangeBigStoreItemsListItem, error) {
-	_, ok := s.cache.static.items[templateId]
+	c := s.cache()
+	_, ok := c.static.items[templateId]
 	if !ok {
 		return nil, errInvalidRequest
 	}
 
-	marketItems := s.cache.marketItemsByMarketId[market.Id]
-	items := make(map[int]prototyp.ExchangeBigStoreItemsListItem)
-	for k, v := range marketItems {
-		if v.TemplateId != templateId {
-			continue
+	lots := marketLots(market, c.listings.items(market.Id), c.listings.prices(market.Id), templateId)
+
+	sli := make([]prototyp.ExchangeBigStoreItemsListItem, len(lots))
+	for i, v := range lots {
+		sli[i] = prototyp.ExchangeBigStoreItemsListItem{
+			Id:        v.Id,
+			Effects:   retro.EncodeItemEffects(v.Effects),
+			PriceSet1: v.Prices[0],
+			PriceSet2: v.Prices[1],
+			PriceSet3: v.Prices[2],
 		}
+	}
+
+	return sli, nil
+}
 
-		effects := retro.EncodeItemEffects(v.Effects)
+func (s *Server) marketLot(market retro.Market, id int) (marketLot, bool) {
+	listings := s.cache().listings
+	marketItems := listings.items(market.Id)
 
-		items[k] = prototyp.ExchangeBigStoreItemsListItem{
-			Id:        v.Id,
-			Effects:   effects,
-			PriceSet1: v.Price,
-			PriceSet2: 0,
-			PriceSet3: 0,
+	item, ok := marketItems[id]
+	if !ok {
+		return marketLot{}, false
+	}
+
+	for _, v := range marketLots(market, marketItems, listings.prices(market.Id), item.TemplateId) {
+		if v.Id == id {
+			return v, true
 		}
 	}
 
-	sli := make([]prototyp.ExchangeBigStoreItemsListItem, len(items))
-	i := 0
-	for _, v := range items {
-		sli[i] = v
-		i++
+	return marketLot{}, false
+}
+
+// marketLots returns the lots of the market items of a template, sorted by id, each with the prices of its item in
+// the quantities of the market. Identical items at identical prices are listed once.
+func marketLots(market retro.Market, marketItems map[int]retro.MarketItem, prices map[int][3]int, templateId int) []marketLot {
+	quantities := marketQuantities(market)
+
+	var ids []int
+	for id, v := range marketItems {
+		if v.TemplateId == templateId {
+			ids = append(ids, id)
+		}
 	}
-	sort.Slice(sli, func(i, j int) bool {
-		return sli[i].Id < sli[j].Id
+	sort.Ints(ids)
+
+	var lots []marketLot
+	for _, id := range ids {
+		item := marketItems[id].Item
+		item.Quantity = 1
+
+		lot := marketLot{Item: item, Prices: prices[id]}
+		for i, quantity := range quantities {
+			if quantity < 1 {
+				lot.Prices[i] = 0
+			}
+		}
+
+		same := false
+		for _, v := range lots {
+			if v.Prices == lot.Prices && sameItems(v.Item, lot.Item) {
+				same = true
+				break
+			}
+		}
+		if !same {
+			lots = append(lots, lot)
+		}
+	}
+
+	return lots
+}
+
+func marketQuantities(market retro.Market) [3]int {
+	return [3]int{market.Quantity1, market.Quantity2, market.Quantity3}
+}
+
+type marketIndex struct {
+	entries []marketIndexEntry
+}
+
+type marketIndexEntry struct {
+	templateId      int
+	itemType        retrotyp.ItemType
+	name            string
+	characteristics map[retrotyp.CharacteristicId]int
+}
+
+type marketQuery struct {
+	name            string
+	characteristics map[retrotyp.CharacteristicId]int
+}
+
+type marketSearchResult struct {
+	templateId int
+	itemType   retrotyp.ItemType
+	rank       int
+	score      int
+}
+
+var marketQueryConstraintRx = regexp.MustCompile(`^([a-z]+)>=(-?\d+)$`)
+
+func newMarketIndex(marketItems map[int]retro.MarketItem, items map[int]retro.ItemTemplate, effects map[int]retro.EffectTemplate) (marketIndex, error) {
+	entries := make(map[int]*marketIndexEntry)
+	for _, v := range marketItems {
+		itemTemplate, ok := items[v.TemplateId]
+		if !ok {
+			return marketIndex{}, fmt.Errorf("invalid item template: %d", v.TemplateId)
+		}
+
+		entry, ok := entries[v.TemplateId]
+		if !ok {
+			entry = &marketIndexEntry{
+				templateId:      itemTemplate.Id,
+				itemType:        itemTemplate.Type,
+				name:            strings.ToLower(itemTemplate.Name),
+				characteristics: make(map[retrotyp.CharacteristicId]int),
+			}
+			entries[v.TemplateId] = entry
+		}
+
+		values := make(map[retrotyp.CharacteristicId]int)
+		for _, effect := range v.Effects {
+			t, ok := effects[effect.Id]
+			if !ok || t.CharacteristicId <= 0 {
+				continue
+			}
+
+			switch t.Operator {
+			case retrotyp.EffectOperatorAdd:
+				values[t.CharacteristicId] += effect.DiceNum
+			case retrotyp.EffectOperatorSub:
+				values[t.CharacteristicId] -= effect.DiceNum
+			}
+		}
+
+		for id, value := range values {
+			current, ok := entry.characteristics[id]
+			if !ok || value > current {
+				entry.characteristics[id] = value
+			}
+		}
+	}
+
+	idx := marketIndex{entries: make([]marketIndexEntry, 0, len(entries))}
+	for _, v := range entries {
+		idx.entries = append(idx.entries, *v)
+	}
+	sort.Slice(idx.entries, func(i, j int) bool {
+		return idx.entries[i].templateId < idx.entries[j].templateId
 	})
 
-	return sli, nil
+	return idx, nil
+}
+
+// search returns the entries matching the query, best matches first. Entries whose name equals the query rank before
+// those starting with it, which rank before those only containing it.
+func (idx marketIndex) search(q marketQuery) []marketSearchResult {
+	var results []marketSearchResult
+	for _, v := range idx.entries {
+		rank := 0
+		if q.name != "" {
+			switch {
+			case v.name == q.name:
+				rank = 0
+			case strings.HasPrefix(v.name, q.name):
+				rank = 1
+			case strings.Contains(v.name, q.name):
+				rank = 2
+			default:
+				continue
+			}
+		}
+
+		score := 0
+		pass := true
+		for id, min := range q.characteristics {
+			value, ok := v.characteristics[id]
+			if !ok || value < min {
+				pass = false
+				break
+			}
+			score += value
+		}
+		if !pass {
+			continue
+		}
+
+		results = append(results, marketSearchResult{
+			templateId: v.templateId,
+			itemType:   v.itemType,
+			rank:       rank,
+			score:      score,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].rank != results[j].rank {
+			return results[i].rank < results[j].rank
+		}
+		return results[i].score > results[j].score
+	})
+
+	return results
+}
+
+// rankedTemplateIds returns the template ids of the results of the item type, best matches first, and the item type.
+// Without item type, it's the one of the best match, as the market lists the items of a single type at a time.
+func rankedTemplateIds(results []marketSearchResult, itemType retrotyp.ItemType) (retrotyp.ItemType, []int) {
+	var templateIds []int
+	for _, v := range results {
+		if itemType == 0 {
+			itemType = v.itemType
+		}
+		if v.itemType == itemType {
+			templateIds = append(templateIds, v.templateId)
+		}
+	}
+	return itemType, templateIds
+}
+
+// parseMarketQuery parses a query made of words to look for in item names and of characteristic constraints such as
+// "strength>=50", in which the characteristic name is written without spaces.
+func parseMarketQuery(s string) (marketQuery, error) {
+	q := marketQuery{characteristics: make(map[retrotyp.CharacteristicId]int)}
+
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(s)) {
+		sub := marketQueryConstraintRx.FindStringSubmatch(field)
+		if sub == nil {
+			words = append(words, field)
+			continue
+		}
+
+		id, ok := characteristicIdByName(sub[1])
+		if !ok {
+			return marketQuery{}, fmt.Errorf("unknown characteristic: %q", sub[1])
+		}
+
+		value, err := strconv.Atoi(sub[2])
+		if err != nil {
+			return marketQuery{}, err
+		}
+		q.characteristics[id] = value
+	}
+	q.name = strings.Join(words, " ")
+
+	if q.name == "" && len(q.characteristics) == 0 {
+		return marketQuery{}, errors.New("empty query")
+	}
+
+	return q, nil
+}
+
+func characteristicIdByName(name string) (retrotyp.CharacteristicId, bool) {
+	for id, v := range retrotyp.CharacteristicIds {
+		if strings.ToLower(strings.ReplaceAll(v, " ", "")) == name {
+			return id, true
+		}
+	}
+	return 0, false
 }
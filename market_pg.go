@@ -0,0 +1,51 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// MarketDb is a PostgreSQL MarketPriceStorer, which reads the prices from the price, price_set_2 and price_set_3
+// columns of the retro.markets_items table, the last two of which are added by sql/retropvp.sql.
+type MarketDb struct {
+	pool *pgxpool.Pool
+}
+
+func NewMarketDb(pool *pgxpool.Pool) (*MarketDb, error) {
+	if pool == nil {
+		return nil, errors.New("pool is nil")
+	}
+
+	return &MarketDb{pool: pool}, nil
+}
+
+func (r *MarketDb) MarketItemPrices(ctx context.Context, marketId string) (map[int][3]int, error) {
+	query := "SELECT id, price, price_set_2, price_set_3" +
+		" FROM retro.markets_items" +
+		" WHERE market_id = $1;"
+
+	rows, err := r.pool.Query(ctx, query, marketId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := make(map[int][3]int)
+	for rows.Next() {
+		var id int
+		var v [3]int
+		err := rows.Scan(&id, &v[0], &v[1], &v[2])
+		if err != nil {
+			return nil, err
+		}
+		prices[id] = v
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,259 @@
+package retropvp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+)
+
+func Test_marketLots(t *testing.T) {
+	market := retro.Market{Quantity1: 1, Quantity2: 10, Quantity3: 100}
+
+	strength := []retrotyp.Effect{{Id: 118, DiceNum: 10}}
+	wisdom := []retrotyp.Effect{{Id: 124, DiceNum: 10}}
+
+	marketItems := map[int]retro.MarketItem{
+		1: {Item: retro.Item{Id: 1, TemplateId: 100, Quantity: 1, Effects: strength}, Price: 5},
+		2: {Item: retro.Item{Id: 2, TemplateId: 100, Quantity: 1, Effects: strength}, Price: 5},
+		3: {Item: retro.Item{Id: 3, TemplateId: 100, Quantity: 1, Effects: strength}, Price: 4},
+		4: {Item: retro.Item{Id: 4, TemplateId: 100, Quantity: 1, Effects: wisdom}, Price: 7},
+		5: {Item: retro.Item{Id: 5, TemplateId: 200, Quantity: 1}, Price: 1},
+		6: {Item: retro.Item{Id: 6, TemplateId: 300, Quantity: 1}},
+	}
+	prices := map[int][3]int{
+		1: {5, 40, 300},
+		2: {5, 40, 300},
+		3: {4, 0, 0},
+		4: {7, 65, 600},
+		5: {1, 0, 0},
+		6: {0, 0, 900},
+	}
+
+	type testCase struct {
+		name       string
+		templateId int
+		quantity3  int
+
+		wantIds    []int
+		wantPrices [][3]int
+	}
+
+	testCases := []testCase{
+		{
+			name:       "grouped by item and prices",
+			templateId: 100,
+			quantity3:  100,
+			wantIds:    []int{1, 3, 4},
+			wantPrices: [][3]int{{5, 40, 300}, {4, 0, 0}, {7, 65, 600}},
+		},
+		{name: "single quantity", templateId: 200, quantity3: 100, wantIds: []int{5}, wantPrices: [][3]int{{1, 0, 0}}},
+		{name: "largest quantity only", templateId: 300, quantity3: 100, wantIds: []int{6}, wantPrices: [][3]int{{0, 0, 900}}},
+		{name: "quantity not sold", templateId: 300, wantIds: []int{6}, wantPrices: [][3]int{{0, 0, 0}}},
+		{name: "no items", templateId: 400, quantity3: 100},
+	}
+
+	for _, tc := range testCases {
+		market.Quantity3 = tc.quantity3
+		lots := marketLots(market, marketItems, prices, tc.templateId)
+
+		if len(lots) != len(tc.wantIds) {
+			t.Errorf("%s: lots: want %d, got %d", tc.name, len(tc.wantIds), len(lots))
+			continue
+		}
+
+		for i, lot := range lots {
+			if lot.Id != tc.wantIds[i] {
+				t.Errorf("%s: id: want %d, got %d", tc.name, tc.wantIds[i], lot.Id)
+			}
+
+			if lot.Quantity != 1 {
+				t.Errorf("%s: quantity: want %d, got %d", tc.name, 1, lot.Quantity)
+			}
+
+			if lot.Prices != tc.wantPrices[i] {
+				t.Errorf("%s: prices: want %v, got %v", tc.name, tc.wantPrices[i], lot.Prices)
+			}
+		}
+	}
+}
+
+func Test_newMarketListings_prices(t *testing.T) {
+	c := &cache{static: cacheStatic{items: map[int]retro.ItemTemplate{100: {Id: 100}}}}
+
+	itemsByMarketId := map[string]map[int]retro.MarketItem{
+		"m": {
+			1: {Item: retro.Item{Id: 1, TemplateId: 100, Quantity: 1}, Price: 5},
+			2: {Item: retro.Item{Id: 2, TemplateId: 100, Quantity: 1}, Price: 6},
+		},
+	}
+
+	type testCase struct {
+		name             string
+		pricesByMarketId map[string]map[int][3]int
+
+		want map[int][3]int
+	}
+
+	testCases := []testCase{
+		{
+			name:             "stored",
+			pricesByMarketId: map[string]map[int][3]int{"m": {1: {5, 45, 400}, 2: {6, 0, 500}}},
+			want:             map[int][3]int{1: {5, 45, 400}, 2: {6, 0, 500}},
+		},
+		{
+			name:             "partly stored",
+			pricesByMarketId: map[string]map[int][3]int{"m": {1: {5, 45, 400}}},
+			want:             map[int][3]int{1: {5, 45, 400}, 2: {6, 0, 0}},
+		},
+		{name: "no store", want: map[int][3]int{1: {5, 0, 0}, 2: {6, 0, 0}}},
+	}
+
+	for _, tc := range testCases {
+		l, err := newMarketListings(itemsByMarketId, tc.pricesByMarketId, c)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		got := l.prices("m")
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func Test_marketIndex_search(t *testing.T) {
+	items := map[int]retro.ItemTemplate{
+		1: {Id: 1, Name: "Gelano", Type: retrotyp.ItemTypeRing},
+		2: {Id: 2, Name: "Gelano Ring", Type: retrotyp.ItemTypeRing},
+		3: {Id: 3, Name: "Royal Gelano", Type: retrotyp.ItemTypeRing},
+		4: {Id: 4, Name: "Kanniboots", Type: retrotyp.ItemTypeBoots},
+	}
+
+	effects := map[int]retro.EffectTemplate{
+		118: {Id: 118, Operator: retrotyp.EffectOperatorAdd, CharacteristicId: retrotyp.CharacteristicIdStrength},
+		157: {Id: 157, Operator: retrotyp.EffectOperatorSub, CharacteristicId: retrotyp.CharacteristicIdStrength},
+	}
+
+	marketItems := map[int]retro.MarketItem{
+		1: {Item: retro.Item{Id: 1, TemplateId: 1, Effects: []retrotyp.Effect{{Id: 118, DiceNum: 20}}}},
+		2: {Item: retro.Item{Id: 2, TemplateId: 2, Effects: []retrotyp.Effect{{Id: 118, DiceNum: 60}}}},
+		3: {Item: retro.Item{Id: 3, TemplateId: 3, Effects: []retrotyp.Effect{{Id: 118, DiceNum: 50}}}},
+		4: {Item: retro.Item{Id: 4, TemplateId: 3, Effects: []retrotyp.Effect{{Id: 157, DiceNum: 10}}}},
+		5: {Item: retro.Item{Id: 5, TemplateId: 4, Effects: []retrotyp.Effect{{Id: 118, DiceNum: 80}}}},
+	}
+
+	idx, err := newMarketIndex(marketItems, items, effects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		query string
+
+		wantTemplateIds []int
+	}
+
+	testCases := []testCase{
+		{query: "gelano", wantTemplateIds: []int{1, 2, 3}},
+		{query: "GELANO ring", wantTemplateIds: []int{2}},
+		{query: "gelano strength>=50", wantTemplateIds: []int{2, 3}},
+		{query: "strength>=50", wantTemplateIds: []int{4, 2, 3}},
+		{query: "strength>=100"},
+		{query: "boufcoul"},
+	}
+
+	for _, tc := range testCases {
+		q, err := parseMarketQuery(tc.query)
+		if err != nil {
+			t.Errorf("query %q: %s", tc.query, err)
+			continue
+		}
+
+		results := idx.search(q)
+
+		if len(results) != len(tc.wantTemplateIds) {
+			t.Errorf("query %q: want %d results, got %d", tc.query, len(tc.wantTemplateIds), len(results))
+			continue
+		}
+
+		for i, v := range results {
+			if v.templateId != tc.wantTemplateIds[i] {
+				t.Errorf("query %q: want template id %d, got %d", tc.query, tc.wantTemplateIds[i], v.templateId)
+			}
+		}
+	}
+}
+
+func Test_parseMarketQuery(t *testing.T) {
+	for _, query := range []string{"", "   ", "luck>=10"} {
+		_, err := parseMarketQuery(query)
+		if err == nil {
+			t.Errorf("query %q: want error, got nil", query)
+		}
+	}
+}
+
+func Test_rankedTemplateIds(t *testing.T) {
+	results := []marketSearchResult{
+		{templateId: 4, itemType: retrotyp.ItemTypeBoots},
+		{templateId: 2, itemType: retrotyp.ItemTypeRing},
+		{templateId: 5, itemType: retrotyp.ItemTypeBoots},
+		{templateId: 3, itemType: retrotyp.ItemTypeRing},
+	}
+
+	type testCase struct {
+		itemType retrotyp.ItemType
+
+		wantItemType    retrotyp.ItemType
+		wantTemplateIds []int
+	}
+
+	testCases := []testCase{
+		{itemType: 0, wantItemType: retrotyp.ItemTypeBoots, wantTemplateIds: []int{4, 5}},
+		{itemType: retrotyp.ItemTypeRing, wantItemType: retrotyp.ItemTypeRing, wantTemplateIds: []int{2, 3}},
+		{itemType: retrotyp.ItemTypeAmulet, wantItemType: retrotyp.ItemTypeAmulet},
+	}
+
+	for _, tc := range testCases {
+		itemType, templateIds := rankedTemplateIds(results, tc.itemType)
+		if itemType != tc.wantItemType {
+			t.Errorf("item type %d: want item type %d, got %d", tc.itemType, tc.wantItemType, itemType)
+		}
+		if !reflect.DeepEqual(templateIds, tc.wantTemplateIds) {
+			t.Errorf("item type %d: want template ids %v, got %v", tc.itemType, tc.wantTemplateIds, templateIds)
+		}
+	}
+}
+
+func Test_exchangeBigStoreSearch_Deserialize(t *testing.T) {
+	type testCase struct {
+		extra string
+
+		want    exchangeBigStoreSearch
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{extra: "9|1234", want: exchangeBigStoreSearch{ItemType: 9, TemplateId: 1234}},
+		{extra: "9|gelano strength>=50", want: exchangeBigStoreSearch{ItemType: 9, Query: "gelano strength>=50"}},
+		{extra: "0|a|b", want: exchangeBigStoreSearch{Query: "a|b"}},
+		{extra: "9|", wantErr: true},
+		{extra: "9", wantErr: true},
+		{extra: "ring|1234", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		var got exchangeBigStoreSearch
+		err := got.Deserialize(tc.extra)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: want error %t, got %v", tc.extra, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("%q: want %+v, got %+v", tc.extra, tc.want, got)
+		}
+	}
+}
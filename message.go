@@ -0,0 +1,198 @@
+package retropvp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroproto"
+)
+
+// gameCellObject implements the GameCellObject message, which package msgsvr can't serialize.
+type gameCellObject struct {
+	Objects []gameCellObjectObject
+}
+
+type gameCellObjectObject struct {
+	Remove     bool
+	CellId     int
+	TemplateId int
+}
+
+func (m gameCellObject) MessageId() retroproto.MsgSvrId {
+	return retroproto.GameCellObject
+}
+
+func (m gameCellObject) Serialized() (string, error) {
+	sli := make([]string, len(m.Objects))
+	for i, v := range m.Objects {
+		if v.Remove {
+			sli[i] = fmt.Sprintf("-%d", v.CellId)
+		} else {
+			sli[i] = fmt.Sprintf("+%d;%d;0", v.CellId, v.TemplateId)
+		}
+	}
+	return strings.Join(sli, "|"), nil
+}
+
+// waypointsCreate implements the WaypointsCreate message, which package msgsvr can't serialize.
+type waypointsCreate struct {
+	CurrentGameMapId int
+	Waypoints        []waypointsCreateWaypoint
+}
+
+type waypointsCreateWaypoint struct {
+	GameMapId int
+	Cost      int
+}
+
+func (m waypointsCreate) MessageId() retroproto.MsgSvrId {
+	return retroproto.WaypointsCreate
+}
+
+func (m waypointsCreate) Serialized() (string, error) {
+	sli := make([]string, len(m.Waypoints)+1)
+	sli[0] = fmt.Sprint(m.CurrentGameMapId)
+	for i, v := range m.Waypoints {
+		sli[i+1] = fmt.Sprintf("%d;%d", v.GameMapId, v.Cost)
+	}
+	return strings.Join(sli, "|"), nil
+}
+
+// waypointsLeave implements the WaypointsLeave message, which package msgsvr can't serialize.
+type waypointsLeave struct{}
+
+func (m waypointsLeave) MessageId() retroproto.MsgSvrId {
+	return retroproto.WaypointsLeave
+}
+
+func (m waypointsLeave) Serialized() (string, error) {
+	return "", nil
+}
+
+// waypointsUseError implements the WaypointsUseError message, which package msgsvr can't serialize.
+type waypointsUseError struct{}
+
+func (m waypointsUseError) MessageId() retroproto.MsgSvrId {
+	return retroproto.WaypointsUseError
+}
+
+func (m waypointsUseError) Serialized() (string, error) {
+	return "E", nil
+}
+
+// waypointsUse implements the WaypointsUse message, which package msgcli doesn't have.
+type waypointsUse struct {
+	GameMapId int
+}
+
+func (m waypointsUse) MessageId() retroproto.MsgCliId {
+	return retroproto.WaypointsUse
+}
+
+func (m waypointsUse) MessageName() string {
+	return "WaypointsUse"
+}
+
+func (m waypointsUse) Serialized() (string, error) {
+	return fmt.Sprint(m.GameMapId), nil
+}
+
+func (m *waypointsUse) Deserialize(extra string) error {
+	gameMapId, err := strconv.Atoi(extra)
+	if err != nil {
+		return err
+	}
+	m.GameMapId = gameMapId
+
+	return nil
+}
+
+// exchangeBigStoreSearch implements the ExchangeBigStoreSearch message, which package msgcli can't deserialize with a
+// search query. It's either itemType|templateId, to look up a template, or itemType|query, to search the market by
+// name and characteristics, as parseMarketQuery does. The item type is 0 to search every type.
+type exchangeBigStoreSearch struct {
+	ItemType   retrotyp.ItemType
+	TemplateId int
+	Query      string
+}
+
+func (m exchangeBigStoreSearch) MessageId() retroproto.MsgCliId {
+	return retroproto.ExchangeBigStoreSearch
+}
+
+func (m exchangeBigStoreSearch) MessageName() string {
+	return "ExchangeBigStoreSearch"
+}
+
+func (m exchangeBigStoreSearch) Serialized() (string, error) {
+	if m.Query != "" {
+		return fmt.Sprintf("%d|%s", m.ItemType, m.Query), nil
+	}
+	return fmt.Sprintf("%d|%d", m.ItemType, m.TemplateId), nil
+}
+
+func (m *exchangeBigStoreSearch) Deserialize(extra string) error {
+	sli := strings.SplitN(extra, "|", 2)
+	if len(sli) < 2 || sli[1] == "" {
+		return retroproto.ErrInvalidMsg
+	}
+
+	itemType, err := strconv.ParseInt(sli[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	m.ItemType = retrotyp.ItemType(itemType)
+
+	templateId, err := strconv.ParseInt(sli[1], 10, 32)
+	if err != nil {
+		m.Query = sli[1]
+		return nil
+	}
+	m.TemplateId = int(templateId)
+
+	return nil
+}
+
+// gameActionTypeUseObject is the game action type of gameActionsUseObject.
+const gameActionTypeUseObject = "500"
+
+// gameActionsUseObject implements the GameActionsSendActions message of the action that uses the object on a cell,
+// which package msgcli can't deserialize. Its format is cellId, optionally followed by ;skillId.
+type gameActionsUseObject struct {
+	CellId  int
+	SkillId int
+}
+
+func (m gameActionsUseObject) MessageId() retroproto.MsgCliId {
+	return retroproto.GameActionsSendActions
+}
+
+func (m gameActionsUseObject) MessageName() string {
+	return "GameActionsSendActions"
+}
+
+func (m gameActionsUseObject) Serialized() (string, error) {
+	return fmt.Sprintf("%s%d;%d", gameActionTypeUseObject, m.CellId, m.SkillId), nil
+}
+
+func (m *gameActionsUseObject) Deserialize(extra string) error {
+	cell, skill, hasSkill := strings.Cut(extra, ";")
+
+	cellId, err := strconv.Atoi(cell)
+	if err != nil {
+		return err
+	}
+	m.CellId = cellId
+
+	if hasSkill {
+		skillId, err := strconv.Atoi(skill)
+		if err != nil {
+			return err
+		}
+		m.SkillId = skillId
+	}
+
+	return nil
+}
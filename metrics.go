@@ -0,0 +1,258 @@
+package retropvp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var handlerDurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// metrics collects the counters and histograms of the server. The gauges are read from the server state when they're
+// scraped.
+type metrics struct {
+	mu                    sync.Mutex
+	receivedByMessage     map[string]uint64
+	sentByMessage         map[string]uint64
+	errorsByKind          map[string]uint64
+	handlerDurationByName map[string]*histogram
+	marketTransactions    uint64
+	marketKamas           uint64
+	violationsByKind      map[string]uint64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		receivedByMessage:     make(map[string]uint64),
+		sentByMessage:         make(map[string]uint64),
+		errorsByKind:          make(map[string]uint64),
+		handlerDurationByName: make(map[string]*histogram),
+		violationsByKind:      make(map[string]uint64),
+	}
+}
+
+func (m *metrics) received(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.receivedByMessage[name]++
+}
+
+func (m *metrics) sent(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sentByMessage[name]++
+}
+
+// handled records the duration of the handler of a message and the kind of error it returned, if any.
+func (m *metrics) handled(name string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.handlerDurationByName[name]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(handlerDurationBuckets))}
+		m.handlerDurationByName[name] = h
+	}
+	seconds := d.Seconds()
+	for i, v := range handlerDurationBuckets {
+		if seconds <= v {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if err != nil {
+		m.errorsByKind[errorKind(err)]++
+	}
+}
+
+func (m *metrics) marketTransaction(price int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.marketTransactions++
+	m.marketKamas += uint64(price)
+}
+
+func (m *metrics) violation(kind ViolationKind) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.violationsByKind[string(kind)]++
+}
+
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, errNoop):
+		return "noop"
+	case errors.Is(err, errNotImplemented):
+		return "not_implemented"
+	case errors.Is(err, errNotAllowed):
+		return "not_allowed"
+	case errors.Is(err, errInvalidRequest):
+		return "invalid_request"
+	default:
+		return "other"
+	}
+}
+
+// serveMetrics serves the metrics in the Prometheus text format until ctx is done.
+func (s *Server) serveMetrics(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.metricsAddr)
+	if err != nil {
+		return err
+	}
+	s.logger.Infow("serving metrics",
+		"address", ln.Addr().String(),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		srv.Close()
+		return nil
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	s.writeMetrics(buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	sessions := len(s.sessions)
+	characters := len(s.sessionByCharacterId)
+	s.mu.Unlock()
+
+	s.gameMapsMu.Lock()
+	populationByGameMapId := make(map[string]uint64, len(s.gameMapInstances))
+	for id, instance := range s.gameMapInstances {
+		if len(instance.sessions) > 0 {
+			populationByGameMapId[strconv.Itoa(id)] = uint64(len(instance.sessions))
+		}
+	}
+	s.gameMapsMu.Unlock()
+
+	writeMetric(w, "retropvp_sessions", "gauge", "Connected sessions.", "", map[string]uint64{"": uint64(sessions)})
+	writeMetric(w, "retropvp_characters", "gauge", "Characters in game.", "", map[string]uint64{"": uint64(characters)})
+	writeMetric(w, "retropvp_game_map_characters", "gauge", "Characters in game by game map.", "game_map_id",
+		populationByGameMapId)
+
+	m := s.metrics
+	m.mu.Lock()
+	writeMetric(w, "retropvp_received_messages_total", "counter", "Messages received from clients.", "message",
+		m.receivedByMessage)
+	writeMetric(w, "retropvp_sent_messages_total", "counter", "Messages sent to clients.", "message",
+		m.sentByMessage)
+	writeMetric(w, "retropvp_handler_errors_total", "counter", "Errors returned by message handlers by kind.", "kind",
+		m.errorsByKind)
+	writeHistograms(w, "retropvp_handler_duration_seconds", "Duration of message handlers.", "message",
+		m.handlerDurationByName)
+	writeMetric(w, "retropvp_market_transactions_total", "counter", "Items bought in marketplaces.", "",
+		map[string]uint64{"": m.marketTransactions})
+	writeMetric(w, "retropvp_market_kamas_total", "counter", "Kamas spent in marketplaces.", "",
+		map[string]uint64{"": m.marketKamas})
+	writeMetric(w, "retropvp_violations_total", "counter", "Violations of clients by kind.", "kind",
+		m.violationsByKind)
+	m.mu.Unlock()
+
+	throttledByClass, droppedByClass, kicked := s.rateLimitStats.snapshot()
+	throttled := make(map[string]uint64, len(throttledByClass))
+	for k, v := range throttledByClass {
+		throttled[string(k)] = uint64(v)
+	}
+	dropped := make(map[string]uint64, len(droppedByClass))
+	for k, v := range droppedByClass {
+		dropped[string(k)] = uint64(v)
+	}
+	writeMetric(w, "retropvp_throttled_packets_total", "counter", "Packets over the rate limits by class.", "class",
+		throttled)
+	writeMetric(w, "retropvp_dropped_packets_total", "counter", "Packets dropped for going over the rate limits by class.",
+		"class", dropped)
+	writeMetric(w, "retropvp_rate_limit_kicks_total", "counter", "Clients kicked for going over the rate limits.", "",
+		map[string]uint64{"": uint64(kicked)})
+}
+
+// writeMetric writes a metric with a label, or without one if label is empty, in which case values has a single value
+// with an empty key.
+func writeMetric(w io.Writer, name, typ, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, k := range sortedKeys(values) {
+		if label == "" {
+			fmt.Fprintf(w, "%s %d\n", name, values[k])
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabelValue(k), values[k])
+	}
+}
+
+func writeHistograms(w io.Writer, name, help, label string, histograms map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	keys := make([]string, 0, len(histograms))
+	for k := range histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := histograms[k]
+		value := escapeLabelValue(k)
+		for i, v := range handlerDurationBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"%s\"} %d\n", name, label, value,
+				strconv.FormatFloat(v, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"+Inf\"} %d\n", name, label, value, h.count)
+		fmt.Fprintf(w, "%s_sum{%s=\"%s\"} %s\n", name, label, value, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s=\"%s\"} %d\n", name, label, value, h.count)
+	}
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
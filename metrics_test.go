@@ -0,0 +1,37 @@
+package retropvp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_writeMetric(t *testing.T) {
+	type testCase struct {
+		name   string
+		label  string
+		values map[string]uint64
+		want   string
+	}
+
+	testCases := []testCase{
+		{
+			name:   "without label",
+			values: map[string]uint64{"": 3},
+			want:   "# HELP m Help.\n# TYPE m gauge\nm 3\n",
+		},
+		{
+			name:   "with label",
+			label:  "message",
+			values: map[string]uint64{"b": 2, `a"\`: 1},
+			want:   "# HELP m Help.\n# TYPE m gauge\nm{message=\"a\\\"\\\\\"} 1\nm{message=\"b\"} 2\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		buf := &bytes.Buffer{}
+		writeMetric(buf, "m", "gauge", "Help.", tc.label, tc.values)
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package retropvp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Speeds at which the client moves the characters, in pixels per millisecond.
+const (
+	walkSpeed  = 0.07
+	runSpeed   = 0.15
+	mountSpeed = 0.23
+)
+
+// runMinSteps is the length of the shortest path the client runs instead of walking.
+const runMinSteps = 3
+
+// speedViolationWindow is how long a movement faster than possible counts towards a kick.
+const speedViolationWindow = 10 * time.Minute
+
+// movement is a movement the client was told to do, until it acks or cancels it.
+type movement struct {
+	from    int
+	path    []int
+	dirs    []int
+	mounted bool
+	started time.Time
+}
+
+func newMovement(from int, path []int, geometry *gameMapGeometry, mounted bool, started time.Time) *movement {
+	dirs := make([]int, len(path))
+	current := from
+	for i, next := range path {
+		dirs[i], _ = geometry.direction(current, next)
+		current = next
+	}
+	return &movement{
+		from:    from,
+		path:    path,
+		dirs:    dirs,
+		mounted: mounted,
+		started: started,
+	}
+}
+
+func stepLength(dir int) float64 {
+	switch dir {
+	case 0, 4:
+		return cellWidth
+	case 2, 6:
+		return cellHeight
+	default:
+		return math.Hypot(cellWidth/2, cellHeight/2)
+	}
+}
+
+// duration returns how long the client takes to walk the first steps of the movement.
+func (m *movement) duration(steps int) time.Duration {
+	speed := walkSpeed
+	switch {
+	case m.mounted:
+		speed = mountSpeed
+	case len(m.path) >= runMinSteps:
+		speed = runSpeed
+	}
+
+	var length float64
+	for _, dir := range m.dirs[:steps] {
+		length += stepLength(dir)
+	}
+	return time.Duration(length / speed * float64(time.Millisecond))
+}
+
+// checkMovementSpeed tells if the client took long enough to walk the first steps of its movement. If it didn't, it
+// counts a violation and fails once there are too many of them.
+func (s *session) checkMovementSpeed(ctx context.Context, steps int, now time.Time) (bool, error) {
+	if s.svr.speedTolerance == 0 || s.movement == nil {
+		return true, nil
+	}
+
+	elapsed := now.Sub(s.movement.started)
+	expected := s.movement.duration(steps)
+	if elapsed >= time.Duration(float64(expected)*s.svr.speedTolerance) {
+		return true, nil
+	}
+
+	if now.Sub(s.lastSpeedViolation) > speedViolationWindow {
+		s.speedViolations = 0
+	}
+	s.speedViolations++
+	s.lastSpeedViolation = now
+
+	err := s.recordViolation(ctx, ViolationTooFast, fmt.Sprintf("took %s instead of %s", elapsed, expected))
+	if err != nil {
+		return false, err
+	}
+
+	if s.svr.speedKickAfter > 0 && s.speedViolations >= s.svr.speedKickAfter {
+		return false, fmt.Errorf("%w: moving faster than possible", errTooManyViolations)
+	}
+	return false, nil
+}
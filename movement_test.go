@@ -0,0 +1,52 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/happybydefault/logging"
+)
+
+func Test_session_checkMovementSpeed(t *testing.T) {
+	g := testGameMapGeometry(5, 5, nil)
+	start := time.Now()
+
+	s := &session{
+		svr:        &Server{logger: logging.Noop{}, metrics: newMetrics(), speedTolerance: 0.8, speedKickAfter: 2},
+		remoteAddr: &net.TCPAddr{},
+		movement:   newMovement(0, []int{5, 10, 15, 20}, g, false, start),
+	}
+
+	type testCase struct {
+		name    string
+		elapsed time.Duration
+		steps   int
+		want    bool
+		wantErr bool
+	}
+
+	// The cases run in order against the same movement.
+	testCases := []testCase{
+		{name: "slow enough", elapsed: 1 * time.Second, steps: 4, want: true},
+		{name: "first steps slow enough", elapsed: 300 * time.Millisecond, steps: 1, want: true},
+		{name: "too fast", elapsed: 100 * time.Millisecond, steps: 4, want: false},
+		{name: "too fast again", elapsed: 100 * time.Millisecond, steps: 4, want: false, wantErr: true},
+		{name: "after the window", elapsed: speedViolationWindow + time.Second, steps: 4, want: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := s.checkMovementSpeed(context.Background(), tc.steps, start.Add(tc.elapsed))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+		}
+		if err != nil && !errors.Is(err, errTooManyViolations) {
+			t.Errorf("%s: error: want %v, got %v", tc.name, errTooManyViolations, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: want %t, got %t", tc.name, tc.want, got)
+		}
+	}
+}
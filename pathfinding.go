@@ -0,0 +1,272 @@
+package retropvp
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+
+	prototyp "github.com/kralamoure/retroproto/typ"
+)
+
+// pathMode is the set of directions a path can take.
+type pathMode int
+
+const (
+	// pathRoleplay allows the eight directions, like characters walking on a map.
+	pathRoleplay pathMode = iota
+	// pathFight allows only the four directions that don't cut across cells, like fighters.
+	pathFight
+)
+
+func (m pathMode) allows(dir int) bool {
+	if m == pathFight {
+		return dir%2 == 1
+	}
+	return dir >= 0 && dir <= 7
+}
+
+type pathOptions struct {
+	mode pathMode
+	// occupied tells if a cell can't be walked on because something stands on it. Nil if nothing blocks the way.
+	occupied func(cellId int) bool
+	// maxSteps is the maximum number of cells of a path, 0 for no limit.
+	maxSteps int
+	// closest makes findPath go as close as possible to an unreachable goal instead of failing.
+	closest bool
+}
+
+// stepCost is the cost of a step in the direction: its length, in tenths of pixels, so the cheapest path is the one
+// the client walks the fastest, which is what checkMovementSpeed expects.
+func stepCost(dir int) int {
+	return int(math.Round(stepLength(dir) * 10))
+}
+
+// canWalk tells if a path can go from a cell to its neighbor in the direction.
+func (g *gameMapGeometry) canWalk(from, dir int, opts pathOptions) (int, bool) {
+	if !opts.mode.allows(dir) {
+		return 0, false
+	}
+	to, ok := g.neighbor(from, dir)
+	if !ok || !g.canStep(from, to) {
+		return 0, false
+	}
+	if opts.occupied != nil && opts.occupied(to) {
+		return 0, false
+	}
+	return to, true
+}
+
+// estimatedCost never overestimates the cost of the cheapest path between two cells.
+func (g *gameMapGeometry) estimatedCost(from, to int, mode pathMode) int {
+	fx, fy := g.coordinates(from)
+	tx, ty := g.coordinates(to)
+	dx, dy := tx-fx, ty-fy
+	if mode == pathFight {
+		return (abs(dx) + abs(dy)) * stepCost(1)
+	}
+
+	// The steps in the directions 2 and 6 only go along x+y, the ones in 0 and 4 only along x-y, two cells at a time,
+	// and the others go along both, one cell at a time, for less than half of the two.
+	a, b := abs(dx+dy), abs(dx-dy)
+	if a > b {
+		return b*stepCost(1) + (a-b)/2*stepCost(2)
+	}
+	return a*stepCost(1) + (b-a)/2*stepCost(0)
+}
+
+// findPath returns the cheapest path between two cells, without the starting cell. The path is empty if both cells
+// are the same. If the goal can't be reached, it's not found, unless the closest option is set, in which case the
+// path goes to the reachable cell closest to the goal.
+func (g *gameMapGeometry) findPath(from, to int, opts pathOptions) ([]int, bool) {
+	if !g.valid(from) || !g.valid(to) {
+		return nil, false
+	}
+
+	costs := make([]int, len(g.cells))
+	steps := make([]int, len(g.cells))
+	parents := make([]int, len(g.cells))
+	for i := range costs {
+		costs[i] = -1
+	}
+	costs[from] = 0
+	parents[from] = -1
+
+	best := from
+	bestEstimate := g.estimatedCost(from, to, opts.mode)
+
+	open := &pathQueue{{cellId: from, estimate: bestEstimate}}
+	for open.Len() > 0 {
+		n := heap.Pop(open).(pathNode)
+		if n.cost != costs[n.cellId] {
+			continue
+		}
+		if n.cellId == to {
+			best = to
+			break
+		}
+		if opts.maxSteps > 0 && steps[n.cellId] >= opts.maxSteps {
+			continue
+		}
+
+		for dir := 0; dir < 8; dir++ {
+			next, ok := g.canWalk(n.cellId, dir, opts)
+			if !ok {
+				continue
+			}
+			cost := n.cost + stepCost(dir)
+			if costs[next] != -1 && costs[next] <= cost {
+				continue
+			}
+			costs[next] = cost
+			steps[next] = steps[n.cellId] + 1
+			parents[next] = n.cellId
+
+			estimate := g.estimatedCost(next, to, opts.mode)
+			if estimate < bestEstimate || (estimate == bestEstimate && cost < costs[best]) {
+				best = next
+				bestEstimate = estimate
+			}
+			heap.Push(open, pathNode{cellId: next, cost: cost, estimate: cost + estimate})
+		}
+	}
+
+	if best != to && !opts.closest {
+		return nil, false
+	}
+
+	path := make([]int, steps[best])
+	for id, i := best, len(path)-1; i >= 0; id, i = parents[id], i-1 {
+		path[i] = id
+	}
+	return path, true
+}
+
+type pathNode struct {
+	cellId   int
+	cost     int
+	estimate int
+}
+
+type pathQueue []pathNode
+
+func (q pathQueue) Len() int { return len(q) }
+
+func (q pathQueue) Less(i, j int) bool {
+	if q[i].estimate != q[j].estimate {
+		return q[i].estimate < q[j].estimate
+	}
+	return q[i].cost > q[j].cost
+}
+
+func (q pathQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *pathQueue) Push(x any) { *q = append(*q, x.(pathNode)) }
+
+func (q *pathQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// pathOf expands the directions and cells sent by the client, where each entry is the last cell walked in its
+// direction, into the cells the path goes through.
+func (g *gameMapGeometry) pathOf(dirAndCells []prototyp.CommonDirAndCell, from int) ([]int, error) {
+	if !g.valid(from) {
+		return nil, errors.New("starting cell not found")
+	}
+
+	var path []int
+	visited := map[int]struct{}{from: {}}
+	current := from
+	for _, v := range dirAndCells {
+		if v.DirId < 0 || v.DirId > 7 {
+			return nil, errors.New("invalid direction")
+		}
+
+		for current != v.CellId {
+			next, ok := g.neighbor(current, v.DirId)
+			if !ok {
+				return nil, errors.New("invalid next cell")
+			}
+
+			_, ok = visited[next]
+			if ok {
+				return nil, errors.New("repeated cell")
+			}
+			visited[next] = struct{}{}
+
+			path = append(path, next)
+			current = next
+		}
+	}
+
+	return path, nil
+}
+
+// canWalkPath tells if every step of the path can be walked.
+func (g *gameMapGeometry) canWalkPath(from int, path []int, opts pathOptions) bool {
+	if opts.maxSteps > 0 && len(path) > opts.maxSteps {
+		return false
+	}
+
+	current := from
+	for _, next := range path {
+		dir, ok := g.direction(current, next)
+		if !ok {
+			return false
+		}
+		_, ok = g.canWalk(current, dir, opts)
+		if !ok {
+			return false
+		}
+		current = next
+	}
+	return true
+}
+
+// direction returns the direction from a cell to its neighbor.
+func (g *gameMapGeometry) direction(from, to int) (int, bool) {
+	if !g.valid(from) {
+		return 0, false
+	}
+	for dir, id := range g.neighbors[from] {
+		if id == to {
+			return dir, true
+		}
+	}
+	return 0, false
+}
+
+// dirAndCellsOf compresses a path into the directions and cells the client expects, with an entry each time the
+// direction changes.
+func (g *gameMapGeometry) dirAndCellsOf(from int, path []int) []prototyp.CommonDirAndCell {
+	var dirAndCells []prototyp.CommonDirAndCell
+	current := from
+	for _, next := range path {
+		dir, _ := g.direction(current, next)
+		if len(dirAndCells) > 0 && dirAndCells[len(dirAndCells)-1].DirId == dir {
+			dirAndCells[len(dirAndCells)-1].CellId = next
+		} else {
+			dirAndCells = append(dirAndCells, prototyp.CommonDirAndCell{DirId: dir, CellId: next})
+		}
+		current = next
+	}
+	return dirAndCells
+}
+
+// validatedPath returns the path sent by the client if it can be walked. Otherwise, it returns the shortest path to
+// its destination or, if there's none, to the closest reachable cell.
+func validatedPath(original []prototyp.CommonDirAndCell, from int, geometry *gameMapGeometry, opts pathOptions) ([]int, error) {
+	path, err := geometry.pathOf(original, from)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 || geometry.canWalkPath(from, path, opts) {
+		return path, nil
+	}
+
+	opts.closest = true
+	path, _ = geometry.findPath(from, path[len(path)-1], opts)
+	return path, nil
+}
@@ -0,0 +1,200 @@
+package retropvp
+
+import (
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+
+	prototyp "github.com/kralamoure/retroproto/typ"
+	"github.com/kralamoure/retroutil"
+)
+
+func Test_gameMapGeometry_findPath(t *testing.T) {
+	g := testGameMapGeometry(5, 5, func(cells []retroutil.Cell) {
+		cells[12].Movement = 0
+		cells[22].Movement = 0
+	})
+
+	type testCase struct {
+		name      string
+		from, to  int
+		opts      pathOptions
+		wantFound bool
+		check     func(path []int) bool
+	}
+
+	testCases := []testCase{
+		{
+			name: "same cell", from: 7, to: 7, wantFound: true,
+			check: func(path []int) bool { return len(path) == 0 },
+		},
+		{
+			name: "straight line", from: 0, to: 4, wantFound: true,
+			check: func(path []int) bool { return reflect.DeepEqual(path, []int{1, 2, 3, 4}) },
+		},
+		{
+			name: "fight without diagonals", from: 0, to: 4, opts: pathOptions{mode: pathFight}, wantFound: true,
+			check: func(path []int) bool {
+				current := 0
+				for _, next := range path {
+					dir, ok := g.direction(current, next)
+					if !ok || dir%2 == 0 {
+						return false
+					}
+					current = next
+				}
+				return len(path) == 8 && current == 4
+			},
+		},
+		{
+			name: "around an occupied cell", from: 0, to: 4, wantFound: true,
+			opts:  pathOptions{occupied: func(cellId int) bool { return cellId == 2 }},
+			check: func(path []int) bool { return !slices.Contains(path, 2) && path[len(path)-1] == 4 },
+		},
+		{
+			name: "unwalkable goal", from: 0, to: 12,
+		},
+		{
+			name: "closest to an unwalkable goal", from: 0, to: 12, opts: pathOptions{closest: true}, wantFound: true,
+			// The cell right above the goal is the closest to walk to.
+			check: func(path []int) bool { return path[len(path)-1] == neighborOf(g, 12, 6) },
+		},
+		{
+			name: "too many steps", from: 0, to: 4, opts: pathOptions{maxSteps: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		path, found := g.findPath(tc.from, tc.to, tc.opts)
+		if found != tc.wantFound {
+			t.Errorf("%s: found: want %t, got %t", tc.name, tc.wantFound, found)
+			continue
+		}
+		if tc.check != nil && !tc.check(path) {
+			t.Errorf("%s: unexpected path %v", tc.name, path)
+		}
+	}
+}
+
+// Test_stepCost checks that the cheapest paths are the fastest ones to walk, as the speed validation measures them, and
+// that the estimated costs never exceed them.
+func Test_stepCost(t *testing.T) {
+	// A wall to walk around, one way or another.
+	g := testGameMapGeometry(5, 5, func(cells []retroutil.Cell) {
+		for _, id := range []int{7, 12, 17} {
+			cells[id].Movement = 0
+		}
+	})
+
+	// fastest returns the length of the shortest walk from the cell to every other, in pixels.
+	fastest := func(from int, mode pathMode) []float64 {
+		lengths := make([]float64, len(g.cells))
+		for i := range lengths {
+			lengths[i] = math.Inf(1)
+		}
+		lengths[from] = 0
+		for changed := true; changed; {
+			changed = false
+			for id := range g.cells {
+				for dir := 0; dir < 8; dir++ {
+					next, ok := g.canWalk(id, dir, pathOptions{mode: mode})
+					if ok && lengths[id]+stepLength(dir) < lengths[next]-1e-9 {
+						lengths[next] = lengths[id] + stepLength(dir)
+						changed = true
+					}
+				}
+			}
+		}
+		return lengths
+	}
+
+	type testCase struct {
+		name string
+		mode pathMode
+	}
+
+	testCases := []testCase{
+		{name: "roleplay", mode: pathRoleplay},
+		{name: "fight", mode: pathFight},
+	}
+
+	for _, tc := range testCases {
+		for from := range g.cells {
+			want := fastest(from, tc.mode)
+			for to := range g.cells {
+				if math.IsInf(want[to], 1) {
+					continue
+				}
+
+				path, found := g.findPath(from, to, pathOptions{mode: tc.mode})
+				if !found {
+					t.Errorf("%s: %d to %d: want a path", tc.name, from, to)
+					continue
+				}
+				var got float64
+				current := from
+				for _, next := range path {
+					dir, _ := g.direction(current, next)
+					got += stepLength(dir)
+					current = next
+				}
+				// Costs are rounded to tenths of pixels.
+				if got > want[to]+0.05*float64(len(path)) {
+					t.Errorf("%s: %d to %d: want %.2f pixels, got %.2f", tc.name, from, to, want[to], got)
+				}
+
+				if estimate := float64(g.estimatedCost(from, to, tc.mode)) / 10; estimate > want[to]+0.05*float64(len(path)) {
+					t.Errorf("%s: %d to %d: estimate: want at most %.2f pixels, got %.2f", tc.name, from, to, want[to], estimate)
+				}
+			}
+		}
+	}
+}
+
+func Test_validatedPath(t *testing.T) {
+	g := testGameMapGeometry(5, 5, func(cells []retroutil.Cell) {
+		cells[2].Movement = 0
+	})
+
+	type testCase struct {
+		name     string
+		original []prototyp.CommonDirAndCell
+		want     []prototyp.CommonDirAndCell
+		wantErr  bool
+	}
+
+	testCases := []testCase{
+		{
+			name:     "walkable",
+			original: []prototyp.CommonDirAndCell{{DirId: 1, CellId: 10}, {DirId: 0, CellId: 11}},
+			want:     []prototyp.CommonDirAndCell{{DirId: 1, CellId: 10}, {DirId: 0, CellId: 11}},
+		},
+		{
+			name:     "blocked",
+			original: []prototyp.CommonDirAndCell{{DirId: 0, CellId: 3}},
+			want:     []prototyp.CommonDirAndCell{{DirId: 0, CellId: 1}, {DirId: 1, CellId: 6}, {DirId: 0, CellId: 7}, {DirId: 7, CellId: 3}},
+		},
+		{
+			name:     "off the map",
+			original: []prototyp.CommonDirAndCell{{DirId: 5, CellId: 10}},
+			wantErr:  true,
+		},
+		{
+			name:     "repeated cell",
+			original: []prototyp.CommonDirAndCell{{DirId: 1, CellId: 5}, {DirId: 5, CellId: 0}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		path, err := validatedPath(tc.original, 0, g, pathOptions{})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+			continue
+		}
+		if got := g.dirAndCellsOf(0, path); !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package retropvp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLength   = 107
+	proxyHeaderTimeout = 5 * time.Second
+)
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header, without reading past it, and returns the address of the
+// client. It returns a nil address when the header doesn't carry one, as with health checks.
+func readProxyHeader(r io.Reader) (net.Addr, error) {
+	buf := make([]byte, len(proxyV2Signature))
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(buf, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+
+	if bytes.HasPrefix(buf, []byte("PROXY ")) {
+		return readProxyHeaderV1(r, buf)
+	}
+
+	return nil, errors.New("missing proxy protocol header")
+}
+
+func readProxyHeaderV1(r io.Reader, start []byte) (net.Addr, error) {
+	line := append([]byte(nil), start...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("proxy protocol v1 header is too long")
+		}
+
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil, errors.New("malformed proxy protocol v1 header")
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported proxy protocol v1 protocol: %q", fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, errors.New("malformed proxy protocol v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy protocol v1 source address: %q", fields[2])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy protocol v1 source port: %q", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(r io.Reader) (net.Addr, error) {
+	head := make([]byte, 4)
+	_, err := io.ReadFull(r, head)
+	if err != nil {
+		return nil, err
+	}
+
+	if head[0]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy protocol version: %d", head[0]>>4)
+	}
+	command := head[0] & 0xf
+	family := head[1]
+
+	payload := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported proxy protocol v2 command: %d", command)
+	}
+
+	var ipLen int
+	switch family {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		return nil, nil
+	}
+
+	if len(payload) < ipLen*2+4 {
+		return nil, errors.New("proxy protocol v2 addresses are too short")
+	}
+
+	ip := net.IP(append([]byte(nil), payload[:ipLen]...))
+	port := binary.BigEndian.Uint16(payload[ipLen*2:])
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseTrustedProxies parses IP addresses and CIDR blocks.
+func parseTrustedProxies(sli []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, len(sli))
+	for i, v := range sli {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %q", v)
+			}
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = net.IPv4len * 8
+			}
+			nets[i] = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		nets[i] = ipNet
+	}
+	return nets, nil
+}
+
+func (s *Server) trustedProxy(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, v := range s.trustedProxies {
+		if v.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package retropvp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func Test_readProxyHeader(t *testing.T) {
+	v2 := append(append([]byte(nil), proxyV2Signature...),
+		0x21, 0x11, 0x00, 0x0c,
+		203, 0, 113, 7,
+		10, 0, 0, 1,
+		0x1f, 0x90,
+		0x15, 0xb4,
+	)
+
+	type testCase struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{name: "v1 tcp4", input: []byte("PROXY TCP4 203.0.113.7 10.0.0.1 8080 5556\r\n"), want: "203.0.113.7:8080"},
+		{name: "v1 tcp6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 8080 5556\r\n"), want: "[2001:db8::1]:8080"},
+		{name: "v1 unknown", input: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v2 tcp4", input: v2, want: "203.0.113.7:8080"},
+		{name: "missing header", input: []byte("HCe6beb4c9b8\x00"), wantErr: true},
+		{name: "v1 malformed", input: []byte("PROXY TCP4 203.0.113.7\r\n"), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		r := bytes.NewReader(append(append([]byte(nil), tc.input...), "rest"...))
+		got, err := readProxyHeader(r)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+
+		gotStr := ""
+		if got != nil {
+			gotStr = got.String()
+		}
+		if gotStr != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.name, tc.want, gotStr)
+		}
+
+		// The header must be consumed, and nothing more.
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "rest" {
+			t.Errorf("%s: rest: want %q, got %q", tc.name, "rest", rest)
+		}
+	}
+}
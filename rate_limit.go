@@ -0,0 +1,261 @@
+package retropvp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kralamoure/retroproto"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is the number of packets per second that a client can send, with bursts of up to Burst packets. A zero
+// Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses a rate limit in the format "rate:burst".
+func ParseRateLimit(s string) (RateLimit, error) {
+	rateStr, burstStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit format: %q", s)
+	}
+
+	r, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil {
+		return RateLimit{}, fmt.Errorf("invalid rate limit rate: %q", rateStr)
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil {
+		return RateLimit{}, fmt.Errorf("invalid rate limit burst: %q", burstStr)
+	}
+
+	return RateLimit{Rate: r, Burst: burst}, nil
+}
+
+func (l RateLimit) validate() error {
+	if l.Rate < 0 || math.IsNaN(l.Rate) || math.IsInf(l.Rate, 0) {
+		return errors.New("rate must be a non negative number")
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return errors.New("burst must be positive")
+	}
+	return nil
+}
+
+func (l RateLimit) limiter() *rate.Limiter {
+	if l.Rate == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(l.Rate), l.Burst)
+}
+
+// RateLimitPolicy limits the packets that a client can send by class. Packets over the limit are delayed, until the
+// client sends too many of them within Window, after which it's warned, then its packets are dropped, then it's
+// kicked. A zero threshold disables its step.
+type RateLimitPolicy struct {
+	Default  RateLimit
+	Chat     RateLimit
+	Movement RateLimit
+	Market   RateLimit
+	Exchange RateLimit
+
+	Window    time.Duration
+	WarnAfter int
+	DropAfter int
+	KickAfter int
+}
+
+// DefaultRateLimitPolicy is the policy used when Config.RateLimits is the zero value.
+var DefaultRateLimitPolicy = RateLimitPolicy{
+	Default:   RateLimit{Rate: 20, Burst: 10},
+	Chat:      RateLimit{Rate: 2, Burst: 5},
+	Movement:  RateLimit{Rate: 10, Burst: 5},
+	Market:    RateLimit{Rate: 5, Burst: 5},
+	Exchange:  RateLimit{Rate: 5, Burst: 5},
+	Window:    10 * time.Second,
+	WarnAfter: 10,
+	DropAfter: 20,
+	KickAfter: 50,
+}
+
+func (p RateLimitPolicy) validate() error {
+	limits := map[packetClass]RateLimit{
+		packetClassDefault:  p.Default,
+		packetClassChat:     p.Chat,
+		packetClassMovement: p.Movement,
+		packetClassMarket:   p.Market,
+		packetClassExchange: p.Exchange,
+	}
+	for class, l := range limits {
+		err := l.validate()
+		if err != nil {
+			return fmt.Errorf("invalid %s rate limit: %w", class, err)
+		}
+	}
+	if p.Window < 0 {
+		return errors.New("rate limit window must not be negative")
+	}
+	if p.WarnAfter < 0 || p.DropAfter < 0 || p.KickAfter < 0 {
+		return errors.New("rate limit thresholds must not be negative")
+	}
+	return nil
+}
+
+type packetClass string
+
+const (
+	packetClassDefault  packetClass = "default"
+	packetClassChat     packetClass = "chat"
+	packetClassMovement packetClass = "movement"
+	packetClassMarket   packetClass = "market"
+	packetClassExchange packetClass = "exchange"
+)
+
+var packetClasses = []packetClass{
+	packetClassDefault,
+	packetClassChat,
+	packetClassMovement,
+	packetClassMarket,
+	packetClassExchange,
+}
+
+func packetClassOf(id retroproto.MsgCliId) packetClass {
+	switch id {
+	case retroproto.ChatSend,
+		retroproto.ChatRequestSubscribeChannelAdd,
+		retroproto.ChatRequestSubscribeChannelRemove:
+		return packetClassChat
+	case retroproto.GameActionsSendActions,
+		retroproto.GameActionAck,
+		retroproto.GameActionCancel,
+		retroproto.EmotesSetDirection:
+		return packetClassMovement
+	case retroproto.ExchangeBigStoreType,
+		retroproto.ExchangeBigStoreItemList,
+		retroproto.ExchangeBigStoreSearch,
+		retroproto.ExchangeGetItemMiddlePriceInBigStore,
+		retroproto.ExchangeBigStoreBuy:
+		return packetClassMarket
+	case retroproto.ExchangeRequest,
+		retroproto.ExchangeLeave,
+		retroproto.ExchangePutInShedFromCertificate,
+		retroproto.ExchangePutInShedFromInventory,
+		retroproto.ExchangePutInCertificateFromShed,
+		retroproto.ExchangePutInInventoryFromShed:
+		return packetClassExchange
+	default:
+		return packetClassDefault
+	}
+}
+
+type rateVerdict int
+
+const (
+	rateAllow rateVerdict = iota
+	rateWait
+	rateWarn
+	rateDrop
+	rateKick
+)
+
+// packetLimiter applies a rate limit policy to the packets of a session.
+type packetLimiter struct {
+	policy   RateLimitPolicy
+	limiters map[packetClass]*rate.Limiter
+
+	throttled   int
+	windowStart time.Time
+}
+
+func newPacketLimiter(p RateLimitPolicy) *packetLimiter {
+	return &packetLimiter{
+		policy: p,
+		limiters: map[packetClass]*rate.Limiter{
+			packetClassDefault:  p.Default.limiter(),
+			packetClassChat:     p.Chat.limiter(),
+			packetClassMovement: p.Movement.limiter(),
+			packetClassMarket:   p.Market.limiter(),
+			packetClassExchange: p.Exchange.limiter(),
+		},
+	}
+}
+
+// check decides what to do with a packet of the class received at now, and how long to delay it when it has to wait.
+func (l *packetLimiter) check(class packetClass, now time.Time) (rateVerdict, time.Duration) {
+	r := l.limiters[class].ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return rateAllow, 0
+	}
+
+	if now.Sub(l.windowStart) > l.policy.Window {
+		l.windowStart = now
+		l.throttled = 0
+	}
+	l.throttled++
+
+	switch {
+	case l.policy.KickAfter > 0 && l.throttled >= l.policy.KickAfter:
+		r.CancelAt(now)
+		return rateKick, 0
+	case l.policy.DropAfter > 0 && l.throttled >= l.policy.DropAfter:
+		r.CancelAt(now)
+		return rateDrop, 0
+	case l.policy.WarnAfter > 0 && l.throttled == l.policy.WarnAfter:
+		return rateWarn, delay
+	default:
+		return rateWait, delay
+	}
+}
+
+// rateLimitStats counts the packets that went over the rate limits.
+type rateLimitStats struct {
+	mu               sync.Mutex
+	throttledByClass map[packetClass]int
+	droppedByClass   map[packetClass]int
+	kicked           int
+}
+
+func (st *rateLimitStats) record(class packetClass, verdict rateVerdict) {
+	if verdict == rateAllow {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.throttledByClass == nil {
+		st.throttledByClass = make(map[packetClass]int)
+		st.droppedByClass = make(map[packetClass]int)
+	}
+
+	st.throttledByClass[class]++
+	switch verdict {
+	case rateDrop:
+		st.droppedByClass[class]++
+	case rateKick:
+		st.kicked++
+	}
+}
+
+func (st *rateLimitStats) snapshot() (throttledByClass, droppedByClass map[packetClass]int, kicked int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	throttledByClass = make(map[packetClass]int, len(st.throttledByClass))
+	for k, v := range st.throttledByClass {
+		throttledByClass[k] = v
+	}
+	droppedByClass = make(map[packetClass]int, len(st.droppedByClass))
+	for k, v := range st.droppedByClass {
+		droppedByClass[k] = v
+	}
+	return throttledByClass, droppedByClass, st.kicked
+}
@@ -0,0 +1,43 @@
+package retropvp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_packetLimiter_check(t *testing.T) {
+	l := newPacketLimiter(RateLimitPolicy{
+		Chat:      RateLimit{Rate: 1, Burst: 1},
+		Window:    10 * time.Second,
+		WarnAfter: 2,
+		DropAfter: 3,
+		KickAfter: 4,
+	})
+
+	now := time.Now()
+	type testCase struct {
+		name  string
+		class packetClass
+		at    time.Duration
+		want  rateVerdict
+	}
+
+	// The cases run in order against the same limiter.
+	testCases := []testCase{
+		{name: "within burst", class: packetClassChat, want: rateAllow},
+		{name: "unlimited class", class: packetClassDefault, want: rateAllow},
+		{name: "over limit", class: packetClassChat, want: rateWait},
+		{name: "warn threshold", class: packetClassChat, want: rateWarn},
+		{name: "drop threshold", class: packetClassChat, want: rateDrop},
+		{name: "kick threshold", class: packetClassChat, want: rateKick},
+		{name: "new window", class: packetClassChat, at: 20 * time.Second, want: rateAllow},
+		{name: "new window over limit", class: packetClassChat, at: 20 * time.Second, want: rateWait},
+	}
+
+	for _, tc := range testCases {
+		got, _ := l.check(tc.class, now.Add(tc.at))
+		if got != tc.want {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
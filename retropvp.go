@@ -3,26 +3,58 @@ package retropvp
 
 import (
 	"errors"
-	"math"
+	"fmt"
 	"net"
 	"time"
 
 	"github.com/happybydefault/logging"
 	"github.com/kralamoure/dofus/dofussvc"
 	"github.com/kralamoure/retro/retrosvc"
-	prototyp "github.com/kralamoure/retroproto/typ"
-	"github.com/kralamoure/retroutil"
 )
 
 type Config struct {
-	Id          int
-	Addr        string
-	ConnTimeout time.Duration
-	TicketDur   time.Duration
-	Location    *time.Location
-	Dofus       *dofussvc.Service
-	Retro       *retrosvc.Service
-	Logger      logging.Logger
+	Id int
+	// Deprecated: Addr is the address of a single listener, which is appended to Addrs. Use Addrs instead.
+	Addr                 string
+	Addrs                []string
+	WebSocketAddrs       []string
+	MetricsAddr          string
+	AdminAddr            string
+	AdminToken           string
+	Listeners            []net.Listener
+	ConnTimeout          time.Duration
+	TicketDur            time.Duration
+	HandshakeTimeout     time.Duration
+	MaxConnsPerIP        int
+	MaxUnauthenticated   int
+	TicketFailureLimit   int
+	TicketBanDur         time.Duration
+	ProxyProtocol        bool
+	RateLimits           RateLimitPolicy
+	WarmGameMaps         bool
+	SpeedTolerance       float64
+	SpeedKickAfter       int
+	Violations           ViolationPolicy
+	ViolationStore       ViolationStorer
+	BanStore             BanStorer
+	MarketPriceStore     MarketPriceStorer
+	CharacterStore       CharacterStorer
+	Staff                []StaffMember
+	TrustedProxies       []string
+	GroundItemProtection time.Duration
+	GroundItemLifetime   time.Duration
+	Waypoints            []Waypoint
+	WaypointStore        WaypointStorer
+	WaypointCost         int
+	CheckpointInterval   time.Duration
+	ShutdownWarning      time.Duration
+	ShutdownTimeout      time.Duration
+	ShutdownMessage      string
+	RestartSchedule      string
+	Location             *time.Location
+	Dofus                *dofussvc.Service
+	Retro                *retrosvc.Service
+	Logger               logging.Logger
 }
 
 func NewServer(c Config) (*Server, error) {
@@ -35,6 +67,98 @@ func NewServer(c Config) (*Server, error) {
 	if c.TicketDur < 0 {
 		return nil, errors.New("ticket duration must not be negative")
 	}
+	if c.HandshakeTimeout < 0 {
+		return nil, errors.New("handshake timeout must not be negative")
+	}
+	if c.MaxConnsPerIP < 0 {
+		return nil, errors.New("max connections per ip must not be negative")
+	}
+	if c.MaxUnauthenticated < 0 {
+		return nil, errors.New("max unauthenticated connections must not be negative")
+	}
+	if c.TicketFailureLimit < 0 {
+		return nil, errors.New("ticket failure limit must not be negative")
+	}
+	if c.TicketBanDur < 0 {
+		return nil, errors.New("ticket ban duration must not be negative")
+	}
+	if c.SpeedTolerance < 0 || c.SpeedTolerance > 1 {
+		return nil, errors.New("speed tolerance must be between 0 and 1")
+	}
+	if c.SpeedKickAfter < 0 {
+		return nil, errors.New("speed kick threshold must not be negative")
+	}
+	trustedProxies, err := parseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if c.ProxyProtocol && len(trustedProxies) == 0 {
+		return nil, errors.New("proxy protocol requires trusted proxies")
+	}
+	if c.AdminAddr != "" && c.AdminToken == "" {
+		return nil, errors.New("admin api requires a token")
+	}
+	if c.RateLimits == (RateLimitPolicy{}) {
+		c.RateLimits = DefaultRateLimitPolicy
+	}
+	err = c.RateLimits.validate()
+	if err != nil {
+		return nil, err
+	}
+	if c.Violations == (ViolationPolicy{}) {
+		c.Violations = DefaultViolationPolicy
+	}
+	err = c.Violations.validate()
+	if err != nil {
+		return nil, err
+	}
+	staff := make(map[string]PermissionLevel, len(c.Staff))
+	for _, v := range c.Staff {
+		if _, ok := staff[v.AccountId]; ok {
+			return nil, fmt.Errorf("repeated staff account id: %s", v.AccountId)
+		}
+		staff[v.AccountId] = v.Level
+	}
+	if c.GroundItemProtection < 0 {
+		return nil, errors.New("ground item protection must not be negative")
+	}
+	if c.GroundItemLifetime < 0 {
+		return nil, errors.New("ground item lifetime must not be negative")
+	}
+	if c.CheckpointInterval < 0 {
+		return nil, errors.New("checkpoint interval must not be negative")
+	}
+	if c.ShutdownWarning < 0 {
+		return nil, errors.New("shutdown warning must not be negative")
+	}
+	if c.ShutdownTimeout < 0 {
+		return nil, errors.New("shutdown timeout must not be negative")
+	}
+	if c.ShutdownMessage == "" {
+		c.ShutdownMessage = "The server is restarting in {remaining}."
+	}
+	var restartSchedule schedule
+	if c.RestartSchedule != "" {
+		location := c.Location
+		if location == nil {
+			location = time.UTC
+		}
+		sch, err := parseCronSchedule(c.RestartSchedule, location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid restart schedule: %w", err)
+		}
+		restartSchedule = sch
+	}
+	if c.WaypointCost < 0 {
+		return nil, errors.New("waypoint cost must not be negative")
+	}
+	waypoints := make(map[int]Waypoint, len(c.Waypoints))
+	for _, v := range c.Waypoints {
+		if _, ok := waypoints[v.GameMapId]; ok {
+			return nil, fmt.Errorf("repeated waypoint game map id: %d", v.GameMapId)
+		}
+		waypoints[v.GameMapId] = v
+	}
 	if c.Location == nil {
 		c.Location = time.UTC
 	}
@@ -44,94 +168,91 @@ func NewServer(c Config) (*Server, error) {
 	if c.Retro == nil {
 		return nil, errors.New("nil retro service")
 	}
+	if c.CharacterStore == nil {
+		return nil, errors.New("nil character store")
+	}
 	if c.Logger == nil {
 		c.Logger = logging.Noop{}
 	}
 
-	addr, err := net.ResolveTCPAddr("tcp4", c.Addr)
-	if err != nil {
-		return nil, err
+	if c.Addr != "" {
+		c.Addrs = append(append([]string(nil), c.Addrs...), c.Addr)
+	}
+	if len(c.Addrs) == 0 && len(c.WebSocketAddrs) == 0 && len(c.Listeners) == 0 {
+		return nil, errors.New("no address or listener")
+	}
+	addrs := make([]*net.TCPAddr, len(c.Addrs))
+	for i, v := range c.Addrs {
+		addr, err := net.ResolveTCPAddr("tcp", v)
+		if err != nil {
+			return nil, err
+		}
+		addrs[i] = addr
+	}
+	webSocketAddrs := make([]*net.TCPAddr, len(c.WebSocketAddrs))
+	for i, v := range c.WebSocketAddrs {
+		addr, err := net.ResolveTCPAddr("tcp", v)
+		if err != nil {
+			return nil, err
+		}
+		webSocketAddrs[i] = addr
+	}
+	for _, v := range c.Listeners {
+		if v == nil {
+			return nil, errors.New("nil listener")
+		}
 	}
 	s := &Server{
 		logger:               c.Logger,
 		id:                   c.Id,
-		addr:                 addr,
+		addrs:                addrs,
+		webSocketAddrs:       webSocketAddrs,
+		metricsAddr:          c.MetricsAddr,
+		adminAddr:            c.AdminAddr,
+		adminToken:           c.AdminToken,
+		listeners:            c.Listeners,
 		connTimeout:          c.ConnTimeout,
 		ticketDur:            c.TicketDur,
+		handshakeTimeout:     c.HandshakeTimeout,
+		proxyProtocol:        c.ProxyProtocol,
+		trustedProxies:       trustedProxies,
+		rateLimits:           c.RateLimits,
+		warmGameMaps:         c.WarmGameMaps,
+		speedTolerance:       c.SpeedTolerance,
+		speedKickAfter:       c.SpeedKickAfter,
+		violationPolicy:      c.Violations,
+		violations:           c.ViolationStore,
+		banStore:             c.BanStore,
+		marketPriceStore:     c.MarketPriceStore,
+		characters:           c.CharacterStore,
+		violationScores:      newViolationScores(),
+		staff:                staff,
+		commands:             newCommandRegistry(staffCommands),
+		groundItemProtection: c.GroundItemProtection,
+		groundItemLifetime:   c.GroundItemLifetime,
+		waypoints:            waypoints,
+		waypointStore:        c.WaypointStore,
+		waypointCost:         c.WaypointCost,
+		checkpointInterval:   c.CheckpointInterval,
+		shutdownWarning:      c.ShutdownWarning,
+		shutdownTimeout:      c.ShutdownTimeout,
+		shutdownMessage:      c.ShutdownMessage,
+		restartSchedule:      restartSchedule,
 		location:             c.Location,
 		dofus:                c.Dofus,
 		retro:                c.Retro,
+		scheduler:            newScheduler(c.Logger),
+		metrics:              newMetrics(),
+		guard:                newConnGuard(c.MaxConnsPerIP, c.MaxUnauthenticated, c.TicketFailureLimit, c.TicketBanDur),
+		restart:              make(chan struct{}, 1),
 		sessions:             make(map[*session]struct{}),
 		sessionByAccountId:   make(map[string]*session),
 		sessionByCharacterId: make(map[int]*session),
-	}
-	return s, nil
-}
+		gameMapInstances:     make(map[int]*gameMapInstance),
 
-func validatedPath(original []prototyp.CommonDirAndCell, startingCellId int, gameMapWidth int, cells []retroutil.Cell) ([]prototyp.CommonDirAndCell, error) {
-	if len(original) > 10 {
-		return nil, errors.New("path is too long")
+		knownWaypointsByCharacterId: make(map[int]map[int]struct{}),
+		savePointByCharacterId:      make(map[int]Waypoint),
+		bans:                        make(map[banKey]Ban),
 	}
-
-	if len(cells) < startingCellId+1 {
-		return nil, errors.New("starting cell not found")
-	}
-	current := cells[startingCellId]
-
-	var dirAndCells []prototyp.CommonDirAndCell
-
-	cellIds := make(map[int]struct{})
-	cellIds[current.Id] = struct{}{}
-	for _, v := range original {
-		ix, err := retroutil.DirectionToIndex(v.DirId)
-		if err != nil {
-			return nil, err
-		}
-
-		dirAndCell := prototyp.CommonDirAndCell{
-			DirId:  v.DirId,
-			CellId: -1,
-		}
-
-		for i := 0; i <= 100; i++ {
-			if i == 100 {
-				return nil, errors.New("path is too long")
-			}
-
-			nextId, ok := retroutil.AroundCellNum(current.Id, 0, ix, gameMapWidth, cells)
-			if !ok {
-				return nil, errors.New("invalid next cell")
-			}
-
-			_, ok = cellIds[nextId]
-			if ok {
-				return nil, errors.New("repeated cell")
-			}
-			cellIds[nextId] = struct{}{}
-
-			if len(cells) < nextId+1 {
-				return nil, errors.New("next cell not found")
-			}
-			next := cells[nextId]
-
-			if !next.Active || !next.LineOfSight || next.Movement <= 1 || math.Abs(float64(current.GroundLevel-next.GroundLevel)) > 1 {
-				break
-			}
-
-			dirAndCell.CellId = nextId
-
-			current = next
-
-			if current.Id == v.CellId {
-				break
-			}
-		}
-
-		if dirAndCell.CellId == -1 {
-			break
-		}
-		dirAndCells = append(dirAndCells, dirAndCell)
-	}
-
-	return dirAndCells, nil
+	return s, nil
 }
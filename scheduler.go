@@ -0,0 +1,353 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/happybydefault/logging"
+)
+
+// schedule tells when a job has to run next. It returns false when the job doesn't have to run anymore.
+type schedule interface {
+	next(after time.Time) (time.Time, bool)
+}
+
+type everySchedule time.Duration
+
+func (d everySchedule) next(after time.Time) (time.Time, bool) {
+	return after.Add(time.Duration(d)), true
+}
+
+// onceSchedule runs a job once, at the time. A job added when the time has already come, as with a zero delay, runs
+// right away.
+type onceSchedule time.Time
+
+func (t onceSchedule) next(after time.Time) (time.Time, bool) {
+	return time.Time(t), time.Time(t).After(after)
+}
+
+// cronSchedule is a schedule in the standard five fields cron format: minute, hour, day of month, month and day of week.
+// Each field accepts "*", numbers, ranges ("1-5"), steps ("*/15", "0-30/10") and lists of them ("0,30").
+type cronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	anyDay     bool
+	anyWeekday bool
+
+	location *time.Location
+}
+
+func parseCronSchedule(spec string, location *time.Location) (cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron schedule must have 5 fields: %q", spec)
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+	sch := cronSchedule{
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+		location:   location,
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := [5]*uint64{&sch.minutes, &sch.hours, &sch.days, &sch.months, &sch.weekdays}
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("invalid cron field %q: %w", field, err)
+		}
+		*sets[i] = set
+	}
+
+	return sch, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, errors.New("invalid step")
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+
+			var err error
+			lo, err = strconv.Atoi(loStr)
+			if err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				hi, err = strconv.Atoi(hiStr)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.New("out of range")
+		}
+
+		for n := lo; n <= hi; n += step {
+			set |= 1 << n
+		}
+	}
+	return set, nil
+}
+
+func (c cronSchedule) next(after time.Time) (time.Time, bool) {
+	t := after.In(c.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+			continue
+		}
+		if c.hours&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.location)
+			continue
+		}
+		if c.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// matchesDay follows cron, where a day matches either field when both the day of month and the day of week are
+// restricted.
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	day := c.days&(1<<t.Day()) != 0
+	weekday := c.weekdays&(1<<int(t.Weekday())) != 0
+
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+type job struct {
+	name     string
+	schedule schedule
+	run      func(ctx context.Context) error
+
+	next    time.Time
+	last    time.Time
+	lastErr error
+	runs    int
+	running bool
+}
+
+type jobStatus struct {
+	name    string
+	next    time.Time
+	last    time.Time
+	lastErr error
+	runs    int
+	running bool
+}
+
+type scheduler struct {
+	logger logging.Logger
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	wake chan struct{}
+}
+
+func newScheduler(logger logging.Logger) *scheduler {
+	return &scheduler{
+		logger: logger,
+		jobs:   make(map[string]*job),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (sc *scheduler) add(name string, sch schedule, run func(ctx context.Context) error) error {
+	now := time.Now()
+	next, ok := sch.next(now)
+	if t, once := sch.(onceSchedule); once && !ok {
+		next, ok = time.Time(t), true
+	}
+	if !ok {
+		return errors.New("schedule has no next run")
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, ok := sc.jobs[name]; ok {
+		return fmt.Errorf("job already exists: %q", name)
+	}
+	sc.jobs[name] = &job{
+		name:     name,
+		schedule: sch,
+		run:      run,
+		next:     next,
+	}
+
+	sc.notify()
+
+	return nil
+}
+
+func (sc *scheduler) remove(name string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	_, ok := sc.jobs[name]
+	delete(sc.jobs, name)
+
+	sc.notify()
+
+	return ok
+}
+
+func (sc *scheduler) status() []jobStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sli := make([]jobStatus, 0, len(sc.jobs))
+	for _, v := range sc.jobs {
+		sli = append(sli, jobStatus{
+			name:    v.name,
+			next:    v.next,
+			last:    v.last,
+			lastErr: v.lastErr,
+			runs:    v.runs,
+			running: v.running,
+		})
+	}
+	sort.Slice(sli, func(i, j int) bool { return sli[i].name < sli[j].name })
+
+	return sli
+}
+
+// notify wakes the run loop up so it takes changes into account. sc.mu must be held.
+func (sc *scheduler) notify() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run runs the jobs when they're due until ctx is done. A job doesn't run again while it's still running.
+func (sc *scheduler) run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		var earliest time.Time
+
+		sc.mu.Lock()
+		now := time.Now()
+		for _, j := range sc.jobs {
+			if j.running {
+				continue
+			}
+
+			if !j.next.After(now) {
+				j.running = true
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					sc.runJob(ctx, j)
+				}()
+				continue
+			}
+
+			if earliest.IsZero() || j.next.Before(earliest) {
+				earliest = j.next
+			}
+		}
+		sc.mu.Unlock()
+
+		var t *time.Timer
+		var timerC <-chan time.Time
+		if !earliest.IsZero() {
+			t = time.NewTimer(time.Until(earliest))
+			timerC = t.C
+		}
+
+		select {
+		case <-timerC:
+		case <-sc.wake:
+		case <-ctx.Done():
+		}
+		if t != nil {
+			t.Stop()
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (sc *scheduler) runJob(ctx context.Context, j *job) {
+	started := time.Now()
+	err := j.run(ctx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		sc.logger.Errorw("job failed",
+			"error", err,
+			"job", j.name,
+		)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	j.last = started
+	j.lastErr = err
+	j.runs++
+	j.running = false
+
+	next, ok := j.schedule.next(time.Now())
+	if ok {
+		j.next = next
+	} else if sc.jobs[j.name] == j {
+		delete(sc.jobs, j.name)
+	}
+
+	sc.notify()
+}
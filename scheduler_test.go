@@ -0,0 +1,113 @@
+package retropvp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/happybydefault/logging"
+)
+
+func Test_cronSchedule_next(t *testing.T) {
+	// 2024-01-01 is a Monday.
+	after := time.Date(2024, 1, 1, 10, 30, 15, 0, time.UTC)
+
+	type testCase struct {
+		spec string
+
+		want    time.Time
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{spec: "* * * * *", want: time.Date(2024, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{spec: "0 6 * * *", want: time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC)},
+		{spec: "0 6,12 * * *", want: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{spec: "30 4 * * 0", want: time.Date(2024, 1, 7, 4, 30, 0, 0, time.UTC)},
+		{spec: "0 0 15 * 6", want: time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 3 *", want: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 9-17/4 * * 1-5", want: time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{spec: "0 6 * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "5-1 * * * *", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		sch, err := parseCronSchedule(tc.spec, time.UTC)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: want error", tc.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tc.spec, err)
+			continue
+		}
+
+		got, ok := sch.next(after)
+		if !ok || !got.Equal(tc.want) {
+			t.Errorf("%q: want %s, got %s", tc.spec, tc.want, got)
+		}
+	}
+}
+
+func Test_scheduler_add(t *testing.T) {
+	now := time.Now()
+
+	type testCase struct {
+		name     string
+		schedule schedule
+
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{name: "once later", schedule: onceSchedule(now.Add(time.Hour))},
+		{name: "once without delay", schedule: onceSchedule(now)},
+		{name: "every", schedule: everySchedule(time.Minute)},
+		{name: "never", schedule: cronSchedule{location: time.UTC}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		sc := newScheduler(logging.Noop{})
+		err := sc.add(tc.name, tc.schedule, func(ctx context.Context) error { return nil })
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func Test_scheduler_run_once(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sc := newScheduler(logging.Noop{})
+	ran := make(chan struct{}, 2)
+	err := sc.add("now", onceSchedule(time.Now()), func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go sc.run(ctx)
+
+	select {
+	case <-ran:
+	case <-ctx.Done():
+		t.Fatal("job without delay did not run")
+	}
+
+	// It runs once, and is forgotten afterwards.
+	for len(sc.status()) != 0 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if len(ran) != 0 || ctx.Err() != nil {
+		t.Errorf("want the job to run once and be removed, got %d more runs and %d jobs", len(ran), len(sc.status()))
+	}
+}
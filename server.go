@@ -18,49 +18,73 @@ import (
 	"github.com/kralamoure/retro/retrotyp"
 	"github.com/kralamoure/retroproto/msgsvr"
 	prototyp "github.com/kralamoure/retroproto/typ"
-	"github.com/kralamoure/retroutil"
+	"go.uber.org/atomic"
 )
 
 type Server struct {
-	logger      logging.Logger
-	id          int
-	addr        *net.TCPAddr
-	connTimeout time.Duration
-	ticketDur   time.Duration
-	location    *time.Location
-	dofus       *dofussvc.Service
-	retro       *retrosvc.Service
-
-	ln *net.TCPListener
+	logger               logging.Logger
+	id                   int
+	addrs                []*net.TCPAddr
+	webSocketAddrs       []*net.TCPAddr
+	metricsAddr          string
+	adminAddr            string
+	adminToken           string
+	listeners            []net.Listener
+	connTimeout          time.Duration
+	ticketDur            time.Duration
+	handshakeTimeout     time.Duration
+	proxyProtocol        bool
+	trustedProxies       []*net.IPNet
+	rateLimits           RateLimitPolicy
+	warmGameMaps         bool
+	speedTolerance       float64
+	speedKickAfter       int
+	violationPolicy      ViolationPolicy
+	violations           ViolationStorer
+	banStore             BanStorer
+	marketPriceStore     MarketPriceStorer
+	waypointStore        WaypointStorer
+	characters           CharacterStorer
+	violationScores      *violationScores
+	staff                map[string]PermissionLevel
+	commands             *commandRegistry
+	groundItemProtection time.Duration
+	groundItemLifetime   time.Duration
+	waypoints            map[int]Waypoint
+	waypointCost         int
+	checkpointInterval   time.Duration
+	shutdownWarning      time.Duration
+	shutdownTimeout      time.Duration
+	shutdownMessage      string
+	restartSchedule      schedule
+	location             *time.Location
+	dofus                *dofussvc.Service
+	retro                *retrosvc.Service
+
+	lns          []net.Listener
+	scheduler    *scheduler
+	guard        *connGuard
+	restart      chan struct{}
+	shuttingDown atomic.Bool
+
+	rateLimitStats rateLimitStats
+	metrics        *metrics
 
 	mu                   sync.Mutex
 	sessions             map[*session]struct{}
 	sessionByAccountId   map[string]*session
 	sessionByCharacterId map[int]*session
 
-	cache cache
-}
+	gameMapsMu       sync.Mutex
+	gameMapInstances map[int]*gameMapInstance
 
-type cache struct {
-	static cacheStatic
+	knownWaypointsByCharacterId map[int]map[int]struct{}
+	savePointByCharacterId      map[int]Waypoint
 
-	npcsByMapId           map[int][]retro.NPC
-	markets               map[string]retro.Market
-	marketItemsByMarketId map[string]map[int]retro.MarketItem
-	gameMapCells          map[int][]retroutil.Cell
-}
+	bans map[banKey]Ban
 
-type cacheStatic struct {
-	gameMaps     map[int]retro.GameMap
-	effects      map[int]retro.EffectTemplate
-	itemSets     map[int]retro.ItemSet
-	items        map[int]retro.ItemTemplate
-	npcs         map[int]retro.NPCTemplate
-	npcDialogs   map[int]retro.NPCDialog
-	npcResponses map[int]retro.NPCResponse
-	classes      map[retrotyp.ClassId]retro.Class
-	spells       map[int]retro.Spell
-	mounts       map[int]retro.MountTemplate
+	loadCacheMu  sync.Mutex
+	currentCache atomic.Pointer[cache]
 }
 
 func (s *Server) ListenAndServe(ctx context.Context) error {
@@ -91,94 +115,186 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 		return err
 	}
 
-	ln, err := net.ListenTCP("tcp4", s.addr)
+	err = s.loadBans(ctx)
 	if err != nil {
 		return err
 	}
+
+	lns := append([]net.Listener(nil), s.listeners...)
 	defer func() {
-		ln.Close()
-		s.logger.Infow("stopped listening",
+		for _, ln := range lns {
+			ln.Close()
+			s.logger.Infow("stopped listening",
+				"address", ln.Addr().String(),
+			)
+		}
+	}()
+	for _, addr := range s.addrs {
+		ln, err := net.ListenTCP(tcpNetwork(addr), addr)
+		if err != nil {
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	for _, addr := range s.webSocketAddrs {
+		ln, err := net.ListenTCP(tcpNetwork(addr), addr)
+		if err != nil {
+			return err
+		}
+		lns = append(lns, newWebSocketListener(ln))
+	}
+	for _, ln := range lns {
+		s.logger.Infow("listening",
 			"address", ln.Addr().String(),
 		)
-	}()
-	s.logger.Infow("listening",
-		"address", ln.Addr().String(),
-	)
-	s.ln = ln
+	}
+	s.lns = lns
 
 	err = s.retro.SetGameServerState(ctx, retrotyp.GameServerStateOnline)
 	if err != nil {
 		return err
 	}
 
+	// Sessions outlive ctx so they can be warned and saved before being closed.
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+
 	errCh := make(chan error)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := s.acceptLoop(ctx)
-		if err != nil {
-			select {
-			case errCh <- err:
-			case <-ctx.Done():
+	for _, ln := range lns {
+		ln := ln
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.acceptLoop(serveCtx, ln)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-serveCtx.Done():
+				}
 			}
-		}
-	}()
+		}()
+	}
+
+	if s.adminAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.serveAdmin(serveCtx)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-serveCtx.Done():
+				}
+			}
+		}()
+	}
+
+	if s.metricsAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.serveMetrics(serveCtx)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-serveCtx.Done():
+				}
+			}
+		}()
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := s.maintain(ctx)
+		err := s.maintain(serveCtx)
 		if err != nil {
 			select {
 			case errCh <- err:
-			case <-ctx.Done():
+			case <-serveCtx.Done():
 			}
 		}
 	}()
 
 	select {
 	case <-ctx.Done():
+		s.shutdown(stopServing)
 		return ctx.Err()
+	case <-s.restart:
+		s.shutdown(stopServing)
+		return ErrRestart
 	case err := <-errCh:
 		return err
 	}
 }
 
-func (s *Server) controlAccount(accountId string, sess *session) error {
+func (s *Server) controlAccount(accountId, userId string, sess *session) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	currentSess, ok := s.sessionByAccountId[accountId]
 	if ok {
-		currentSess.conn.Close()
+		currentSess.conn.close()
 		return errors.New("account already logged in")
 	}
 
 	sess.accountId = accountId
+	sess.userId = userId
 	s.sessionByAccountId[accountId] = sess
 
 	return nil
 }
 
 func (s *Server) maintain(ctx context.Context) error {
-	var wg sync.WaitGroup
-	defer wg.Wait()
+	err := s.scheduler.add("delete-invalid-mounts", everySchedule(6*time.Hour), s.deleteInvalidMounts)
+	if err != nil {
+		return err
+	}
 
-	t := time.NewTicker(6 * time.Hour)
-	defer t.Stop()
+	err = s.scheduler.add("prune-conn-guard", everySchedule(1*time.Minute), s.guard.prune)
+	if err != nil {
+		return err
+	}
 
-	for {
-		select {
-		case <-t.C:
-			err := s.deleteInvalidMounts(ctx)
-			if err != nil {
-				return err
-			}
-		case <-ctx.Done():
-			return ctx.Err()
+	err = s.scheduler.add("despawn-ground-items", everySchedule(10*time.Second), s.despawnGroundItems)
+	if err != nil {
+		return err
+	}
+
+	if s.checkpointInterval > 0 {
+		err = s.scheduler.add("save-characters", everySchedule(s.checkpointInterval), s.flushCharacters)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Every day at midnight.
+	daily, err := parseCronSchedule("0 0 * * *", s.location)
+	if err != nil {
+		return err
+	}
+	err = s.scheduler.add("daily-reset", daily, s.dailyReset)
+	if err != nil {
+		return err
+	}
+
+	if s.restartSchedule != nil {
+		err = s.scheduler.add("restart", s.restartSchedule, s.requestRestart)
+		if err != nil {
+			return err
 		}
 	}
+
+	return s.scheduler.run(ctx)
+}
+
+// dailyReset resets what's only kept for a day: the violation scores that decayed away.
+func (s *Server) dailyReset(ctx context.Context) error {
+	n := s.violationScores.prune(s.violationPolicy.HalfLife, time.Now())
+	s.logger.Debugw("reset daily state",
+		"violation_scores", n,
+	)
+	return nil
 }
 
 func (s *Server) deleteInvalidMounts(ctx context.Context) error {
@@ -202,12 +318,12 @@ func (s *Server) deleteInvalidMounts(ctx context.Context) error {
 	return nil
 }
 
-func (s *Server) acceptLoop(ctx context.Context) error {
+func (s *Server) acceptLoop(ctx context.Context, ln net.Listener) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
 	for {
-		conn, err := s.ln.AcceptTCP()
+		conn, err := ln.Accept()
 		if err != nil {
 			return err
 		}
@@ -215,64 +331,158 @@ func (s *Server) acceptLoop(ctx context.Context) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := s.handleClientConn(ctx, conn)
-			if err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) ||
-					errors.Is(err, io.EOF) ||
-					errors.Is(err, context.Canceled) ||
-					errors.Is(err, errInvalidRequest) {
-					s.logger.Debugw(fmt.Errorf("error while handling client connection: %w", err).Error(),
-						"client_address", conn.RemoteAddr().String(),
-					)
-				} else {
-					s.logger.Errorw(fmt.Errorf("error while handling client connection: %w", err).Error(),
-						"client_address", conn.RemoteAddr().String(),
-					)
-				}
-			}
+			s.serveConn(ctx, conn)
 		}()
 	}
 }
 
-func (s *Server) handleClientConn(ctx context.Context, conn *net.TCPConn) error {
+// serveConn resolves the address of the client, reading it from the PROXY protocol header if the connection comes
+// from a trusted proxy, and handles the connection if it's admitted.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	addr := conn.RemoteAddr()
+
+	// WebSocket connections are past their HTTP request already, so they can't start with a PROXY protocol header.
+	_, isWebSocket := conn.(*webSocketConn)
+	if s.proxyProtocol && !isWebSocket && s.trustedProxy(addr) {
+		err := conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		if err == nil {
+			var proxied net.Addr
+			proxied, err = readProxyHeader(conn)
+			if proxied != nil {
+				addr = proxied
+			}
+		}
+		if err == nil {
+			err = conn.SetReadDeadline(time.Time{})
+		}
+		if err != nil {
+			s.logger.Debugw(fmt.Errorf("could not read proxy protocol header: %w", err).Error(),
+				"proxy_address", conn.RemoteAddr().String(),
+			)
+			conn.Close()
+			return
+		}
+	}
+
+	reason, ok := s.guard.admit(addrIP(addr))
+	if !ok {
+		s.logger.Debugw("refused connection",
+			"reason", reason,
+			"client_address", addr.String(),
+		)
+		conn.Close()
+		return
+	}
+
+	err := s.handleClientConn(ctx, conn, addr)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, context.Canceled) ||
+			errors.Is(err, errInvalidRequest) {
+			s.logger.Debugw(fmt.Errorf("error while handling client connection: %w", err).Error(),
+				"client_address", addr.String(),
+			)
+		} else {
+			s.logger.Errorw(fmt.Errorf("error while handling client connection: %w", err).Error(),
+				"client_address", addr.String(),
+			)
+		}
+	}
+}
+
+func (s *Server) handleClientConn(ctx context.Context, conn net.Conn, addr net.Addr) error {
 	sess := &session{
 		svr:         s,
-		conn:        conn,
+		conn:        newStreamTransport(conn),
+		remoteAddr:  addr,
+		ip:          addrIP(addr),
+		out:         make(chan string, sendQueueSize),
+		tasks:       make(chan func(ctx context.Context)),
+		done:        make(chan struct{}),
 		gameActions: make(map[int]msgsvr.GameActions),
 	}
 
+	defer func() {
+		s.guard.release(sess.ip, sess.authenticated)
+	}()
+
 	s.trackSession(sess, true)
 	defer s.trackSession(sess, false)
 
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := sess.flushCharacter(ctx)
+		if err != nil {
+			s.logger.Errorw("could not flush character",
+				"error", err,
+				"character_id", sess.characterId,
+			)
+		}
+	}()
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
 	defer func() {
 		conn.Close()
 		s.logger.Infow("client disconnected",
-			"client_address", conn.RemoteAddr().String(),
+			"client_address", addr.String(),
 		)
 	}()
 	s.logger.Infow("client connected",
-		"client_address", conn.RemoteAddr().String(),
+		"client_address", addr.String(),
 	)
 
-	err := conn.SetKeepAlivePeriod(1 * time.Minute)
-	if err != nil {
-		return err
-	}
-	err = conn.SetKeepAlive(true)
-	if err != nil {
-		return err
+	writeCtx, stopWriting := context.WithCancel(context.Background())
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		err := sess.writePackets(writeCtx)
+		if err != nil {
+			s.logger.Debugw(fmt.Errorf("could not write packets: %w", err).Error(),
+				"client_address", addr.String(),
+			)
+			conn.Close()
+		}
+	}()
+	defer func() {
+		stopWriting()
+		<-writeDone
+	}()
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		err := tcpConn.SetKeepAlivePeriod(1 * time.Minute)
+		if err != nil {
+			return err
+		}
+		err = tcpConn.SetKeepAlive(true)
+		if err != nil {
+			return err
+		}
 	}
 
 	if s.connTimeout > 0 {
-		err = conn.SetReadDeadline(time.Now().UTC().Add(s.connTimeout))
+		err := conn.SetReadDeadline(time.Now().UTC().Add(s.connTimeout))
 		if err != nil {
 			return err
 		}
 	}
 
+	if s.handshakeTimeout > 0 {
+		t := time.AfterFunc(s.handshakeTimeout, func() {
+			if sess.status.Load() == statusExpectingAccountSendTicket {
+				s.logger.Debugw("handshake timed out",
+					"client_address", addr.String(),
+				)
+				conn.Close()
+			}
+		})
+		defer t.Stop()
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -302,102 +512,25 @@ func (s *Server) handleClientConn(ctx context.Context, conn *net.TCPConn) error
 	}
 }
 
-func (s *Server) loadCache(ctx context.Context) error {
-	s.cache.gameMapCells = make(map[int][]retroutil.Cell)
-
-	gameMaps, err := s.retro.GameMaps(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.gameMaps = gameMaps
-
-	effectTemplates, err := s.retro.EffectTemplates(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.effects = effectTemplates
-
-	itemSetTemplates, err := s.retro.ItemSets(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.itemSets = itemSetTemplates
-
-	itemTemplates, err := s.retro.ItemTemplates(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.items = itemTemplates
-
-	npcTemplates, err := s.retro.NPCTemplates(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.npcs = npcTemplates
-
-	npcDialogs, err := s.retro.NPCDialogs(ctx)
-	if err != nil {
-		return err
+// tcpNetwork returns the network to listen on the address with. An IPv4 address only binds IPv4, so that the IPv6
+// wildcard address can be bound too on the same port. An address without IP binds both.
+func tcpNetwork(addr *net.TCPAddr) string {
+	switch {
+	case addr.IP == nil:
+		return "tcp"
+	case addr.IP.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
 	}
-	s.cache.static.npcDialogs = npcDialogs
-
-	npcResponse, err := s.retro.NPCResponses(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.npcResponses = npcResponse
-
-	markets, err := s.retro.Markets(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.markets = markets
-
-	s.cache.marketItemsByMarketId = make(map[string]map[int]retro.MarketItem, len(s.cache.markets))
-	for id := range markets {
-		marketItems, err := s.retro.MarketItemsByMarketId(ctx, id)
-		if err != nil {
-			return err
-		}
-		s.cache.marketItemsByMarketId[id] = marketItems
-	}
-
-	npcs, err := s.retro.NPCs(ctx)
-	if err != nil {
-		return err
-	}
-
-	s.cache.npcsByMapId = make(map[int][]retro.NPC)
-	for _, v := range npcs {
-		if s.cache.npcsByMapId[v.MapId] == nil {
-			s.cache.npcsByMapId[v.MapId] = []retro.NPC{v}
-		} else {
-			s.cache.npcsByMapId[v.MapId] = append(s.cache.npcsByMapId[v.MapId], v)
-		}
-	}
-
-	classes, err := s.retro.Classes(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.classes = classes
-
-	spells, err := s.retro.Spells(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.spells = spells
-
-	mountTemplates, err := s.retro.MountTemplates(ctx)
-	if err != nil {
-		return err
-	}
-	s.cache.static.mounts = mountTemplates
-
-	return nil
 }
 
 func (s *Server) trackSession(sess *session, add bool) {
+	if !add {
+		s.leaveGameMap(sess)
+		s.forgetWaypoints(sess.characterId)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -416,7 +549,7 @@ func (s *Server) gameMovementSpriteCharacter(ctx context.Context, char retro.Cha
 		return
 	}
 
-	items, err := s.retro.CharacterItemsByCharacterId(ctx, char.Id)
+	items, err := s.characterItems(ctx, char.Id)
 	if err != nil {
 		return
 	}
@@ -435,7 +568,7 @@ func (s *Server) gameMovementSpriteCharacter(ctx context.Context, char retro.Cha
 	mountCustomColor3 := ""
 	if char.Mounting {
 		var mount retro.Mount
-		mount, err = s.retro.Mount(ctx, char.MountId)
+		mount, err = s.mount(ctx, char.Id, char.MountId)
 		if err != nil {
 			return
 		}
@@ -503,36 +636,8 @@ func (s *Server) gameMovementSpriteCharacter(ctx context.Context, char retro.Cha
 	return
 }
 
-func (s *Server) sendMsgToMap(ctx context.Context, id int, msg msgOut) error {
-	var wg sync.WaitGroup
-	defer wg.Wait()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	chars, err := s.retro.CharactersByGameMapId(ctx, id)
-	if err != nil {
-		return err
-	}
-
-	for charId := range chars {
-		sess, ok := s.sessionByCharacterId[charId]
-		if !ok {
-			continue
-		}
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			sess.sendMessage(msg)
-		}()
-	}
-
-	return nil
-}
-
 func (s *Server) commonMountData(mount retro.Mount) (data prototyp.CommonMountData, err error) {
-	mountTemplate, ok := s.cache.static.mounts[mount.TemplateId]
+	mountTemplate, ok := s.cache().static.mounts[mount.TemplateId]
 	if !ok {
 		err = errors.New("mout template not found")
 		return
@@ -0,0 +1,109 @@
+package retropvp
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/kralamoure/dofus/dofussvc"
+	"github.com/kralamoure/retro/retrosvc"
+)
+
+func Test_tcpNetwork(t *testing.T) {
+	type testCase struct {
+		addr string
+		want string
+	}
+
+	testCases := []testCase{
+		{addr: ":5556", want: "tcp"},
+		{addr: "0.0.0.0:5556", want: "tcp4"},
+		{addr: "127.0.0.1:5556", want: "tcp4"},
+		{addr: "[::]:5556", want: "tcp6"},
+		{addr: "[::1]:5556", want: "tcp6"},
+	}
+
+	for _, tc := range testCases {
+		addr, err := net.ResolveTCPAddr("tcp", tc.addr)
+		if err != nil {
+			t.Errorf("%s: %s", tc.addr, err)
+			continue
+		}
+
+		got := tcpNetwork(addr)
+		if got != tc.want {
+			t.Errorf("%s: want %s, got %s", tc.addr, tc.want, got)
+		}
+	}
+}
+
+// Test_tcpNetwork_wildcards binds the IPv4 and the IPv6 wildcard addresses on the same port.
+func Test_tcpNetwork_wildcards(t *testing.T) {
+	addr4, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:0")
+	ln4, err := net.ListenTCP(tcpNetwork(addr4), addr4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln4.Close()
+
+	addr6 := &net.TCPAddr{IP: net.IPv6unspecified, Port: ln4.Addr().(*net.TCPAddr).Port}
+	ln6, err := net.ListenTCP(tcpNetwork(addr6), addr6)
+	if err != nil {
+		probe, probeErr := net.ListenTCP("tcp6", &net.TCPAddr{IP: net.IPv6loopback})
+		if probeErr != nil {
+			t.Skip("IPv6 is not available")
+		}
+		probe.Close()
+		t.Fatal(err)
+	}
+	ln6.Close()
+}
+
+func Test_NewServer_addrs(t *testing.T) {
+	svc, err := retrosvc.NewService(retrosvc.Config{Storer: &testRetroStore{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name  string
+		addr  string
+		addrs []string
+
+		want    []string
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{name: "addrs", addrs: []string{"127.0.0.1:5556", "[::1]:5556"}, want: []string{"127.0.0.1:5556", "[::1]:5556"}},
+		{name: "deprecated addr", addr: "127.0.0.1:5557", want: []string{"127.0.0.1:5557"}},
+		{name: "both", addr: "127.0.0.1:5557", addrs: []string{"127.0.0.1:5556"}, want: []string{"127.0.0.1:5556", "127.0.0.1:5557"}},
+		{name: "none", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		s, err := NewServer(Config{
+			Id:             1,
+			Addr:           tc.addr,
+			Addrs:          tc.addrs,
+			CharacterStore: &testCharacterStore{},
+			Dofus:          &dofussvc.Service{},
+			Retro:          svc,
+		})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		var got []string
+		for _, v := range s.addrs {
+			got = append(got, v.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package retropvp
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -21,7 +20,6 @@ import (
 	"github.com/kralamoure/retroproto/msgsvr"
 	prototyp "github.com/kralamoure/retroproto/typ"
 	"go.uber.org/atomic"
-	"golang.org/x/time/rate"
 )
 
 const (
@@ -37,27 +35,49 @@ const (
 	statusIdle // TODO
 )
 
+const (
+	sendQueueSize = 512
+	writeTimeout  = 10 * time.Second
+)
+
 var errNoop = errors.New("no-op")
 var errInvalidRequest = errors.New("invalid request")
 var errNotImplemented = errors.New("not implemented")
 var errNotAllowed = errors.New("not allowed")
+var errSessionClosed = errors.New("session closed")
 
 type session struct {
 	svr         *Server
-	conn        *net.TCPConn
+	conn        transport
+	remoteAddr  net.Addr
+	ip          string
 	status      atomic.Uint32
+	out         chan string
+	tasks       chan func(ctx context.Context) // Run by the goroutine that handles the packets.
+	done        chan struct{}                  // Closed when the packets aren't handled anymore.
 	userId      string
 	accountId   string
 	characterId int
+	permission  PermissionLevel
+
+	authenticated bool
 
 	cache sessionCache
 
+	state     *characterState
+	gameMapId int // Guarded by svr.gameMapsMu.
+
 	busy        atomic.Uint32
 	gameActions map[int]msgsvr.GameActions
+	movement    *movement
+
+	speedViolations    int
+	lastSpeedViolation time.Time
 }
 
 type sessionCache struct {
 	exchangeMarket *retro.Market
+	waypoint       *Waypoint
 }
 
 type msgOut interface {
@@ -65,19 +85,51 @@ type msgOut interface {
 	Serialized() (extra string, err error)
 }
 
+type readResult struct {
+	pkt string
+	err error
+}
+
 func (s *session) receivePackets(ctx context.Context) error {
-	lim := rate.NewLimiter(20, 1)
+	defer close(s.done)
+
+	lim := newPacketLimiter(s.svr.rateLimits)
+
+	// Packets are read by another goroutine so the tasks of other goroutines can run between them.
+	packets := make(chan readResult)
+	stopReading := make(chan struct{})
+	defer close(stopReading)
+	go func() {
+		for {
+			pkt, err := s.conn.readPacket()
+			select {
+			case packets <- readResult{pkt: pkt, err: err}:
+			case <-stopReading:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
 
-	rd := bufio.NewReaderSize(s.conn, 256)
 	for {
-		pkt, err := rd.ReadString('\x00')
+		var pkt string
+		var err error
+		select {
+		case r := <-packets:
+			pkt, err = r.pkt, r.err
+		case task := <-s.tasks:
+			task(ctx)
+			continue
+		}
 		if err != nil {
 			if errors.Is(err, os.ErrDeadlineExceeded) {
 				s.sendMessage(msgsvr.AksServerMessage{Value: "01"})
 			}
 
 			if s.characterId != 0 {
-				char, err := s.svr.retro.Character(ctx, s.characterId)
+				char, err := s.character(ctx)
 				if err != nil {
 					return err
 				}
@@ -90,17 +142,49 @@ func (s *session) receivePackets(ctx context.Context) error {
 
 			return err
 		}
-		err = lim.Wait(ctx)
-		if err != nil {
-			return err
+		pkt = strings.TrimSuffix(pkt, "\n")
+
+		id, _ := retroproto.MsgCliIdByPkt(pkt)
+		class := packetClassOf(id)
+		verdict, delay := lim.check(class, time.Now())
+		s.svr.rateLimitStats.record(class, verdict)
+		switch verdict {
+		case rateWarn:
+			// TODO: hardcode in lang
+			s.sendMessage(msgsvr.InfosMessage{
+				ChatId: protoenum.InfosMessageChatId.Error,
+				Messages: []prototyp.InfosMessageMessage{
+					{
+						Id:   16,
+						Args: []string{"<b>Error</b>", "You are sending too many requests, slow down or you will be disconnected."},
+					},
+				},
+			})
+			fallthrough
+		case rateWait:
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		case rateDrop:
+			s.svr.logger.Debugw("dropped packet over rate limit",
+				"packet_class", class,
+				"client_address", s.remoteAddr.String(),
+			)
+			continue
+		case rateKick:
+			return fmt.Errorf("%w: rate limit exceeded for %s packets", errInvalidRequest, class)
 		}
-		pkt = strings.TrimSuffix(pkt, "\n\x00")
+
 		if pkt == "" {
 			continue
 		}
 
 		if s.svr.connTimeout > 0 {
-			err = s.conn.SetReadDeadline(time.Now().UTC().Add(s.svr.connTimeout))
+			err = s.conn.setReadDeadline(time.Now().UTC().Add(s.svr.connTimeout))
 			if err != nil {
 				return err
 			}
@@ -134,6 +218,7 @@ func (s *session) receivePackets(ctx context.Context) error {
 					},
 				})
 			default:
+				s.recordErrorViolation(ctx, err)
 				return err
 			}
 		}
@@ -143,7 +228,7 @@ func (s *session) receivePackets(ctx context.Context) error {
 func (s *session) handlePacket(pkt string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("recovered from panic: %v", r)
+			err = fmt.Errorf("%w: %v", errRecoveredPanic, r)
 		}
 	}()
 
@@ -152,23 +237,27 @@ func (s *session) handlePacket(pkt string) (err error) {
 	s.svr.logger.Infow("received packet from client",
 		"message_name", name,
 		"packet", pkt,
-		"client_address", s.conn.RemoteAddr().String(),
+		"client_address", s.remoteAddr.String(),
 	)
 	if !ok {
+		s.svr.metrics.received("unknown")
 		s.svr.logger.Debugw("unknown packet",
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
 		return nil
 	}
+	s.svr.metrics.received(name)
 	extra := strings.TrimPrefix(pkt, string(id))
 
 	if !s.frameMessage(id) {
-		s.svr.logger.Debugw("invalid frame",
-			"client_address", s.conn.RemoteAddr().String(),
-		)
-		return errInvalidRequest
+		return fmt.Errorf("%w: %s", errUnexpectedMessage, name)
 	}
 
+	start := time.Now()
+	defer func() {
+		s.svr.metrics.handled(name, time.Since(start), err)
+	}()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -394,7 +483,7 @@ func (s *session) handlePacket(pkt string) (err error) {
 			return err
 		}
 	case retroproto.ExchangeBigStoreSearch:
-		msg := msgcli.ExchangeBigStoreSearch{}
+		msg := exchangeBigStoreSearch{}
 		err := msg.Deserialize(extra)
 		if err != nil {
 			return err
@@ -549,6 +638,19 @@ func (s *session) handlePacket(pkt string) (err error) {
 			return err
 		}
 	case retroproto.GameActionsSendActions:
+		if strings.HasPrefix(extra, gameActionTypeUseObject) {
+			msg := gameActionsUseObject{}
+			err := msg.Deserialize(strings.TrimPrefix(extra, gameActionTypeUseObject))
+			if err != nil {
+				return err
+			}
+			err = s.handleGameActionsUseObject(ctx, msg)
+			if err != nil {
+				return err
+			}
+			break
+		}
+
 		msg := msgcli.GameActionsSendActions{}
 		err := msg.Deserialize(extra)
 		if errors.Is(err, retroproto.ErrNotImplemented) {
@@ -629,6 +731,21 @@ func (s *session) handlePacket(pkt string) (err error) {
 		if err != nil {
 			return err
 		}
+	case retroproto.WaypointsUse:
+		msg := waypointsUse{}
+		err := msg.Deserialize(extra)
+		if err != nil {
+			return err
+		}
+		err = s.handleWaypointsUse(ctx, msg)
+		if err != nil {
+			return err
+		}
+	case retroproto.WaypointsRequestLeave:
+		err := s.handleWaypointsRequestLeave()
+		if err != nil {
+			return err
+		}
 	default:
 		s.sendMessage(msgsvr.InfosMessage{
 			ChatId: protoenum.InfosMessageChatId.Error,
@@ -711,9 +828,108 @@ func (s *session) sendPacket(pkt string) {
 	s.svr.logger.Infow("sent packet to client",
 		"message_name", name,
 		"packet", pkt,
-		"client_address", s.conn.RemoteAddr().String(),
+		"client_address", s.remoteAddr.String(),
 	)
-	fmt.Fprint(s.conn, pkt+"\x00")
+	s.svr.metrics.sent(name)
+
+	select {
+	case s.out <- pkt:
+	default:
+		s.svr.logger.Debugw("send queue overflow",
+			"client_address", s.remoteAddr.String(),
+		)
+		s.conn.close()
+	}
+}
+
+// do runs fn on the goroutine that handles the packets of the session, so it doesn't race with them, and returns its
+// error, or errSessionClosed if the session ended first. from is the session whose goroutine calls do, if any: it keeps
+// running its own tasks while it waits, so two sessions can wait for each other.
+func (s *session) do(ctx context.Context, from *session, fn func(ctx context.Context) error) error {
+	if s == from {
+		return fn(ctx)
+	}
+
+	var fromTasks chan func(ctx context.Context)
+	if from != nil {
+		fromTasks = from.tasks
+	}
+
+	errCh := make(chan error, 1)
+	task := func(ctx context.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("%w: %v", errRecoveredPanic, r)
+			}
+		}()
+		errCh <- fn(ctx)
+	}
+
+	tasks := s.tasks
+	for {
+		select {
+		case tasks <- task:
+			tasks = nil
+		case err := <-errCh:
+			return err
+		case t := <-fromTasks:
+			t(ctx)
+		case <-s.done:
+			select {
+			case err := <-errCh:
+				return err
+			default:
+				return errSessionClosed
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writePackets writes the queued packets to the connection until ctx is done, after which it writes the ones that are
+// still queued.
+func (s *session) writePackets(ctx context.Context) error {
+	// flush writes the packets, and the ones that are already queued too, so they're sent together. The deadline is set
+	// first, as writing may already block when the buffer fills up.
+	flush := func(pkts ...string) error {
+		err := s.conn.setWriteDeadline(time.Now().Add(writeTimeout))
+		if err != nil {
+			return err
+		}
+
+		for _, pkt := range pkts {
+			err := s.conn.writePacket(pkt)
+			if err != nil {
+				return err
+			}
+		}
+		for queued := true; queued; {
+			select {
+			case pkt := <-s.out:
+				err := s.conn.writePacket(pkt)
+				if err != nil {
+					return err
+				}
+			default:
+				queued = false
+			}
+		}
+
+		return s.conn.flush()
+	}
+
+	for {
+		select {
+		case pkt := <-s.out:
+			err := flush(pkt)
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return flush()
+		}
+	}
 }
 
 func (s *session) forgetSpell(ctx context.Context, id int) error {
@@ -722,7 +938,7 @@ func (s *session) forgetSpell(ctx context.Context, id int) error {
 		return nil
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -742,7 +958,7 @@ func (s *session) forgetSpell(ctx context.Context, id int) error {
 		return errors.New("character doesn't know spell")
 	}
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -771,7 +987,7 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 		return errors.New("invalid level")
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -784,7 +1000,7 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 	}
 	char.XP = xp
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -792,13 +1008,13 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 	if level >= originalLevel {
 		char.BonusPoints += (level - originalLevel) * 5
 
-		class, ok := s.svr.cache.static.classes[char.ClassId]
+		class, ok := s.svr.cache().static.classes[char.ClassId]
 		if !ok {
 			return errors.New("class not found")
 		}
 
 		for _, id := range class.Spells {
-			t, ok := s.svr.cache.static.spells[id]
+			t, ok := s.svr.cache().static.spells[id]
 			if !ok {
 				return errors.New("spell not found")
 			}
@@ -834,20 +1050,20 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 		} else {
 			char.BonusPoints = maxBonusPoints - usedBonusPoints
 
-			err := s.svr.retro.UpdateCharacter(ctx, char)
+			err := s.updateCharacter(ctx, char)
 			if err != nil {
 				return err
 			}
 		}
 
-		char, err = s.svr.retro.Character(ctx, s.characterId)
+		char, err = s.character(ctx)
 		if err != nil {
 			return err
 		}
 
 		var spells []retro.CharacterSpell
 		for _, spell := range char.Spells {
-			t, ok := s.svr.cache.static.spells[spell.Id]
+			t, ok := s.svr.cache().static.spells[spell.Id]
 			if !ok {
 				return errors.New("spell not found")
 			}
@@ -862,7 +1078,7 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 	}
 
 	for i, spell := range char.Spells {
-		t, ok := s.svr.cache.static.spells[spell.Id]
+		t, ok := s.svr.cache().static.spells[spell.Id]
 		if !ok {
 			return errors.New("spell not found")
 		}
@@ -878,7 +1094,7 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 		char.Spells[i] = spell
 	}
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -901,8 +1117,180 @@ func (s *session) setLevel(ctx context.Context, level int) error {
 	return nil
 }
 
+func (s *session) addXP(ctx context.Context, xp int) error {
+	if xp < 1 {
+		return errors.New("invalid xp")
+	}
+
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	originalLevel := char.Level()
+
+	target := char.XP + xp
+	if maxXP := retro.CharacterXPFloors[len(retro.CharacterXPFloors)-1]; target > maxXP {
+		target = maxXP
+	}
+	char.XP = target
+
+	if char.Level() != originalLevel {
+		err := s.setLevel(ctx, char.Level())
+		if err != nil {
+			return err
+		}
+
+		char, err = s.character(ctx)
+		if err != nil {
+			return err
+		}
+		char.XP = target
+	}
+
+	err = s.updateCharacter(ctx, char)
+	if err != nil {
+		return err
+	}
+
+	stats, err := s.protoStats(ctx)
+	if err != nil {
+		return err
+	}
+	s.sendMessage(stats)
+
+	return nil
+}
+
+func (s *session) addStat(ctx context.Context, id retrotyp.CharacteristicId, amount int) error {
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch id {
+	case retrotyp.CharacteristicIdVitality:
+		char.Stats.Vitality += amount
+	case retrotyp.CharacteristicIdWisdom:
+		char.Stats.Wisdom += amount
+	case retrotyp.CharacteristicIdStrength:
+		char.Stats.Strength += amount
+	case retrotyp.CharacteristicIdIntelligence:
+		char.Stats.Intelligence += amount
+	case retrotyp.CharacteristicIdChance:
+		char.Stats.Chance += amount
+	case retrotyp.CharacteristicIdAgility:
+		char.Stats.Agility += amount
+	default:
+		return errors.New("characteristic id is invalid")
+	}
+
+	err = s.updateCharacter(ctx, char)
+	if err != nil {
+		return err
+	}
+
+	if id == retrotyp.CharacteristicIdStrength {
+		err = s.sendWeight(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	stats, err := s.protoStats(ctx)
+	if err != nil {
+		return err
+	}
+	s.sendMessage(stats)
+
+	err = s.checkConditions(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// teleportDestination returns the game map if a character can be teleported to its cell.
+func (s *Server) teleportDestination(gameMapId, cellId int) (retro.GameMap, error) {
+	c := s.cache()
+	gameMap, ok := c.static.gameMaps[gameMapId]
+	if !ok {
+		return retro.GameMap{}, fmt.Errorf("%w: invalid game map", errInvalidRequest)
+	}
+
+	geometry, err := c.gameMapGeometry(gameMap)
+	if err != nil {
+		return retro.GameMap{}, err
+	}
+	if !geometry.isWalkable(cellId) {
+		return retro.GameMap{}, fmt.Errorf("%w: invalid cell", errInvalidRequest)
+	}
+
+	return gameMap, nil
+}
+
+func (s *session) teleport(ctx context.Context, gameMapId, cellId int) error {
+	gameMap, ok := s.svr.cache().static.gameMaps[gameMapId]
+	if !ok {
+		return errors.New("invalid game map")
+	}
+
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.svr.sendMsgToMap(ctx, char.GameMapId, msgsvr.GameMovementRemove{Id: char.Id})
+	if err != nil {
+		return err
+	}
+
+	sameGameMap := char.GameMapId == gameMap.Id
+
+	char.GameMapId = gameMap.Id
+	char.Cell = cellId
+
+	err = s.updateCharacter(ctx, char)
+	if err != nil {
+		return err
+	}
+
+	if !sameGameMap {
+		s.sendMessage(msgsvr.GameActions{
+			ActionType: protoenum.GameActionType.LoadGameMap,
+			ActionLoadGameMap: msgsvr.GameActionsActionLoadGameMap{
+				SpriteId:  char.Id,
+				Cinematic: 0,
+			},
+		})
+
+		s.sendMessage(msgsvr.GameMapData{
+			Id:   gameMap.Id,
+			Name: gameMap.Name,
+			Key:  gameMap.Key,
+		})
+
+		s.sendMessage(msgsvr.BasicsTime{Value: time.Now()})
+
+		s.sendMessage(msgsvr.FightsCount{Value: 0}) // TODO
+	}
+
+	sprite, err := s.svr.gameMovementSpriteCharacter(ctx, char, false)
+	if err != nil {
+		return err
+	}
+
+	err = s.svr.sendMsgToMap(ctx, char.GameMapId, msgsvr.GameMovement{Sprites: []msgsvr.GameMovementSprite{sprite}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *session) resetCharacteristics(ctx context.Context) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -916,7 +1304,7 @@ func (s *session) resetCharacteristics(ctx context.Context) error {
 
 	char.BonusPoints = (char.Level() - 1) * 5
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -941,7 +1329,7 @@ func (s *session) resetCharacteristics(ctx context.Context) error {
 }
 
 func (s *session) mountOrDismount(ctx context.Context, mount bool) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -961,7 +1349,7 @@ func (s *session) mountOrDismount(ctx context.Context, mount bool) error {
 	}
 
 	if mount {
-		charItems, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+		charItems, err := s.characterItems(ctx)
 		if err != nil {
 			return err
 		}
@@ -984,7 +1372,7 @@ func (s *session) mountOrDismount(ctx context.Context, mount bool) error {
 
 	char.Mounting = mount
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -992,7 +1380,7 @@ func (s *session) mountOrDismount(ctx context.Context, mount bool) error {
 	// Mount data is not changing (energy or tiredness, for example) in this type of server, so it's not necessary
 	// to send MountEquipSuccess message.
 	/*if mount {
-		mount, err := s.svr.retro.Mount(ctx, char.MountId)
+		mount, err := s.mount(ctx, char.MountId)
 		if err != nil {
 			return err
 		}
@@ -1042,7 +1430,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 		return errors.New("invalid desired position for item")
 	}
 
-	charItems, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	charItems, err := s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -1057,7 +1445,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 		return err
 	}
 
-	charItems, err = s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	charItems, err = s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -1067,7 +1455,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 		return errors.New("item not found")
 	}
 
-	template, ok := s.svr.cache.static.items[item.TemplateId]
+	template, ok := s.svr.cache().static.items[item.TemplateId]
 	if !ok {
 		return errors.New("item template not found")
 	}
@@ -1146,7 +1534,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 		return errNotAllowed
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1161,7 +1549,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 			continue
 		}
 
-		t, ok := s.svr.cache.static.items[v.TemplateId]
+		t, ok := s.svr.cache().static.items[v.TemplateId]
 		if !ok {
 			return errors.New("item template not found")
 		}
@@ -1193,7 +1581,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 	case retrotyp.CharacterItemPositionShield:
 		for _, v := range charItems {
 			if v.Position == retrotyp.CharacterItemPositionWeapon {
-				t, ok := s.svr.cache.static.items[v.TemplateId]
+				t, ok := s.svr.cache().static.items[v.TemplateId]
 				if !ok {
 					return errors.New("item template not found")
 				}
@@ -1227,18 +1615,18 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 				return err
 			}
 
-			char, err = s.svr.retro.Character(ctx, s.characterId)
+			char, err = s.character(ctx)
 			if err != nil {
 				return err
 			}
 
-			mount, err := s.svr.retro.Mount(ctx, char.MountId)
+			mount, err := s.mount(ctx, char.MountId)
 			if err != nil {
 				return err
 			}
 
 			char.MountId = 0
-			err = s.svr.retro.UpdateCharacter(ctx, char)
+			err = s.updateCharacter(ctx, char)
 			if err != nil {
 				return err
 			}
@@ -1250,7 +1638,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 				return errors.New("mount certificate id not found")
 			}
 
-			mountTemplate, ok := s.svr.cache.static.mounts[mount.TemplateId]
+			mountTemplate, ok := s.svr.cache().static.mounts[mount.TemplateId]
 			if !ok {
 				return errors.New("mount template not found")
 			}
@@ -1271,7 +1659,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 				DiceSide: 0,
 			})
 
-			id, err := s.svr.retro.CreateCharacterItem(ctx, mountCertificate)
+			id, err := s.createCharacterItem(ctx, mountCertificate)
 			if err != nil {
 				return err
 			}
@@ -1302,7 +1690,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 			return errors.New("mount id was not found in mount certificate")
 		}
 
-		err := s.svr.retro.DeleteCharacterItem(ctx, item.Id)
+		err := s.deleteCharacterItem(ctx, item.Id)
 		if err != nil {
 			return err
 		}
@@ -1314,12 +1702,12 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 		}
 
 		char.MountId = mountId
-		err = s.svr.retro.UpdateCharacter(ctx, char)
+		err = s.updateCharacter(ctx, char)
 		if err != nil {
 			return err
 		}
 
-		mount, err := s.svr.retro.Mount(ctx, char.MountId)
+		mount, err := s.mount(ctx, char.MountId)
 		if err != nil {
 			return err
 		}
@@ -1374,7 +1762,7 @@ func (s *session) equip(ctx context.Context, id int, position retrotyp.Character
 }
 
 func (s *session) unEquip(ctx context.Context, id int) error {
-	item, err := s.svr.retro.CharacterItem(ctx, id)
+	item, err := s.characterItem(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -1393,7 +1781,7 @@ func (s *session) unEquip(ctx context.Context, id int) error {
 		return err
 	}
 
-	template, ok := s.svr.cache.static.items[item.TemplateId]
+	template, ok := s.svr.cache().static.items[item.TemplateId]
 	if !ok {
 		return errors.New("item template not found")
 	}
@@ -1438,7 +1826,7 @@ func (s *session) moveItemToPosition(ctx context.Context, itemId, quantity int,
 		return errors.New("invalid quantity")
 	}
 
-	charItems, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	charItems, err := s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -1475,7 +1863,7 @@ func (s *session) moveItemToPosition(ctx context.Context, itemId, quantity int,
 		charBatch := charItems[batch.Id]
 		charBatch.Quantity += quantity
 
-		err = s.svr.retro.UpdateCharacterItem(ctx, charBatch)
+		err = s.updateCharacterItem(ctx, charBatch)
 		if err != nil {
 			return err
 		}
@@ -1502,14 +1890,14 @@ func (s *session) moveItemToPosition(ctx context.Context, itemId, quantity int,
 			}
 		}
 
-		item, err = s.svr.retro.CharacterItem(ctx, itemId)
+		item, err = s.characterItem(ctx, itemId)
 		if err != nil {
 			return err
 		}
 
 		if quantity == item.Quantity {
 			item.Position = position
-			err := s.svr.retro.UpdateCharacterItem(ctx, item)
+			err := s.updateCharacterItem(ctx, item)
 			if err != nil {
 				return err
 			}
@@ -1534,7 +1922,7 @@ func (s *session) moveItemToPosition(ctx context.Context, itemId, quantity int,
 				CharacterId: s.characterId,
 			}
 
-			id, err := s.svr.retro.CreateCharacterItem(ctx, newItem)
+			id, err := s.createCharacterItem(ctx, newItem)
 			if err != nil {
 				return err
 			}
@@ -1560,7 +1948,7 @@ func (s *session) moveItemToPosition(ctx context.Context, itemId, quantity int,
 
 func (s *session) addItemToInventory(ctx context.Context, item retro.Item) (id int, err error) {
 	var charItems map[int]retro.CharacterItem
-	charItems, err = s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	charItems, err = s.characterItems(ctx)
 	if err != nil {
 		return
 	}
@@ -1578,7 +1966,7 @@ func (s *session) addItemToInventory(ctx context.Context, item retro.Item) (id i
 		charBatch := charItems[batch.Id]
 		charBatch.Quantity += item.Quantity
 
-		err = s.svr.retro.UpdateCharacterItem(ctx, charBatch)
+		err = s.updateCharacterItem(ctx, charBatch)
 		if err != nil {
 			return
 		}
@@ -1594,7 +1982,7 @@ func (s *session) addItemToInventory(ctx context.Context, item retro.Item) (id i
 			CharacterId: s.characterId,
 		}
 
-		id, err = s.svr.retro.CreateCharacterItem(ctx, charItem)
+		id, err = s.createCharacterItem(ctx, charItem)
 		if err != nil {
 			return
 		}
@@ -1617,12 +2005,60 @@ func (s *session) addItemToInventory(ctx context.Context, item retro.Item) (id i
 	return
 }
 
+func (s *session) pickUpGroundItem(ctx context.Context, gameMapId, cellId int) error {
+	item, ok, protected := s.svr.takeGroundItem(gameMapId, cellId, s.characterId)
+	if protected {
+		// TODO: hardcode in lang
+		s.sendMessage(msgsvr.InfosMessage{
+			ChatId: protoenum.InfosMessageChatId.Error,
+			Messages: []prototyp.InfosMessageMessage{
+				{
+					Id:   16,
+					Args: []string{"<b>Error</b>", "This item can only be picked up by the one who dropped it for now."},
+				},
+			},
+		})
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	_, err := s.addItemToInventory(ctx, item.Item)
+	if err != nil {
+		if !s.svr.returnGroundItem(gameMapId, cellId, item) {
+			s.svr.logger.Errorw("could not return ground item",
+				"game_map_id", gameMapId,
+				"cell_id", cellId,
+				"template_id", item.TemplateId,
+				"quantity", item.Quantity,
+			)
+		}
+		return err
+	}
+
+	err = s.svr.sendMsgToMap(ctx, gameMapId, gameCellObject{Objects: []gameCellObjectObject{{
+		Remove: true,
+		CellId: cellId,
+	}}})
+	if err != nil {
+		return err
+	}
+
+	err = s.sendWeight(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *session) removeItem(ctx context.Context, id, quantity int) error {
 	if quantity < 1 {
 		return errInvalidRequest
 	}
 
-	item, err := s.svr.retro.CharacterItem(ctx, id)
+	item, err := s.characterItem(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -1636,14 +2072,14 @@ func (s *session) removeItem(ctx context.Context, id, quantity int) error {
 	}
 
 	if quantity == item.Quantity {
-		err := s.svr.retro.DeleteCharacterItem(ctx, item.Id)
+		err := s.deleteCharacterItem(ctx, item.Id)
 		if err != nil {
 			return err
 		}
 		s.sendMessage(msgsvr.ItemsRemove{Id: item.Id})
 	} else {
 		item.Quantity -= quantity
-		err := s.svr.retro.UpdateCharacterItem(ctx, item)
+		err := s.updateCharacterItem(ctx, item)
 		if err != nil {
 			return err
 		}
@@ -1699,7 +2135,7 @@ func (s *session) checkConditions(ctx context.Context) error {
 	var changed bool
 
 	for _, position := range positions {
-		char, err := s.svr.retro.Character(ctx, s.characterId)
+		char, err := s.character(ctx)
 		if err != nil {
 			return err
 		}
@@ -1718,7 +2154,7 @@ func (s *session) checkConditions(ctx context.Context) error {
 
 		var item retro.CharacterItem
 
-		items, err := s.svr.retro.CharacterItemsByCharacterId(ctx, char.Id)
+		items, err := s.characterItems(ctx)
 		if err != nil {
 			return err
 		}
@@ -1734,7 +2170,7 @@ func (s *session) checkConditions(ctx context.Context) error {
 			continue
 		}
 
-		itemTemplate, ok := s.svr.cache.static.items[item.TemplateId]
+		itemTemplate, ok := s.svr.cache().static.items[item.TemplateId]
 		if !ok {
 			return errors.New("item template not found")
 		}
@@ -1861,7 +2297,7 @@ func (s *session) sendItemSetBonus(ctx context.Context, id int) error {
 		return errors.New("invalid item set id")
 	}
 
-	charItems, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	charItems, err := s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -1872,7 +2308,7 @@ func (s *session) sendItemSetBonus(ctx context.Context, id int) error {
 			continue
 		}
 
-		t, ok := s.svr.cache.static.items[v.TemplateId]
+		t, ok := s.svr.cache().static.items[v.TemplateId]
 		if !ok {
 			return errors.New("item template not found")
 		}
@@ -1882,7 +2318,7 @@ func (s *session) sendItemSetBonus(ctx context.Context, id int) error {
 		}
 	}
 
-	itemSet, ok := s.svr.cache.static.itemSets[id]
+	itemSet, ok := s.svr.cache().static.itemSets[id]
 	if !ok {
 		return errors.New("item set template not found")
 	}
@@ -1905,12 +2341,12 @@ func (s *session) sendItemSetBonus(ctx context.Context, id int) error {
 }
 
 func (s *session) sendAccessories(ctx context.Context) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	items, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	items, err := s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -1948,14 +2384,14 @@ func (s *session) protoWeight(ctx context.Context) (weight msgsvr.ItemsWeight, e
 	}
 
 	var items map[int]retro.CharacterItem
-	items, err = s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	items, err = s.characterItems(ctx)
 	if err != nil {
 		return
 	}
 
 	var current int
 	for _, item := range items {
-		t, ok := s.svr.cache.static.items[item.TemplateId]
+		t, ok := s.svr.cache().static.items[item.TemplateId]
 		if !ok {
 			err = errors.New("item template not found")
 			return
@@ -1978,7 +2414,7 @@ func (s *session) protoWeight(ctx context.Context) (weight msgsvr.ItemsWeight, e
 }
 
 func (s *session) protoStats(ctx context.Context) (stats msgsvr.AccountStats, err error) {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return
 	}
@@ -2019,12 +2455,12 @@ func (s *session) protoStats(ctx context.Context) (stats msgsvr.AccountStats, er
 }
 
 func (s *session) characteristics(ctx context.Context) (map[retrotyp.CharacteristicId]retrotyp.Characteristic, error) {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	items, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	items, err := s.characterItems(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -2076,7 +2512,7 @@ func (s *session) characteristics(ctx context.Context) (map[retrotyp.Characteris
 
 	for _, item := range items {
 		for _, effect := range item.Effects {
-			t, ok := s.svr.cache.static.effects[effect.Id]
+			t, ok := s.svr.cache().static.effects[effect.Id]
 			if !ok {
 				return nil, errors.New("effect template not found")
 			}
@@ -2102,18 +2538,18 @@ func (s *session) characteristics(ctx context.Context) (map[retrotyp.Characteris
 	}
 
 	if char.Mounting {
-		mount, err := s.svr.retro.Mount(ctx, char.MountId)
+		mount, err := s.mount(ctx, char.MountId)
 		if err != nil {
 			return nil, err
 		}
 
-		mountTemplate, ok := s.svr.cache.static.mounts[mount.TemplateId]
+		mountTemplate, ok := s.svr.cache().static.mounts[mount.TemplateId]
 		if !ok {
 			return nil, errors.New("mount template not found")
 		}
 
 		for _, effect := range mountTemplate.Effects(mount.Level()) {
-			t, ok := s.svr.cache.static.effects[effect.Id]
+			t, ok := s.svr.cache().static.effects[effect.Id]
 			if !ok {
 				return nil, errors.New("effect template not found")
 			}
@@ -2140,7 +2576,7 @@ func (s *session) characteristics(ctx context.Context) (map[retrotyp.Characteris
 
 	itemSetIds := make(map[int]int)
 	for _, item := range items {
-		t, ok := s.svr.cache.static.items[item.TemplateId]
+		t, ok := s.svr.cache().static.items[item.TemplateId]
 		if !ok {
 			return nil, errors.New("item template not found")
 		}
@@ -2151,7 +2587,7 @@ func (s *session) characteristics(ctx context.Context) (map[retrotyp.Characteris
 	}
 
 	for id, quantity := range itemSetIds {
-		t, ok := s.svr.cache.static.itemSets[id]
+		t, ok := s.svr.cache().static.itemSets[id]
 		if !ok {
 			return nil, errors.New("item set template not found")
 		}
@@ -2162,7 +2598,7 @@ func (s *session) characteristics(ctx context.Context) (map[retrotyp.Characteris
 		effects := t.Bonus[ix]
 
 		for _, effect := range effects {
-			t, ok := s.svr.cache.static.effects[effect.Id]
+			t, ok := s.svr.cache().static.effects[effect.Id]
 			if !ok {
 				return nil, errors.New("effect template not found")
 			}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -64,49 +65,70 @@ func (s *session) handleInfosSendScreenInfo(ctx context.Context, m msgcli.InfosS
 func (s *session) handleAccountSendTicket(ctx context.Context, m msgcli.AccountSendTicket) error {
 	t, err := s.svr.retro.UseTicket(ctx, m.Ticket)
 	if err != nil {
+		if errors.Is(err, retro.ErrNotFound) {
+			s.svr.logger.Debugw("ticket not found",
+				"client_address", s.remoteAddr.String(),
+			)
+			s.ticketFailed()
+			s.sendMessage(msgsvr.AccountTicketResponseError{})
+			return errInvalidRequest
+		}
 		return err
 	}
 
 	if t.GameServerId != s.svr.id {
 		s.svr.logger.Debugw("different game server id",
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
+		s.ticketFailed()
 		s.sendMessage(msgsvr.AccountTicketResponseError{})
 		return errInvalidRequest
 	}
 
 	if t.Created.Add(s.svr.ticketDur).Before(time.Now().UTC()) {
 		s.svr.logger.Debugw("ticket is expired",
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
+		s.ticketFailed()
 		s.sendMessage(msgsvr.AccountTicketResponseError{})
 		return errInvalidRequest
 	}
 
-	err = s.svr.controlAccount(t.AccountId, s)
+	account, err := s.svr.dofus.Account(ctx, t.AccountId)
 	if err != nil {
-		s.svr.logger.Debugw("could not control account",
-			"error", err,
-			"client_address", s.conn.RemoteAddr().String(),
-		)
-		s.sendMessage(msgsvr.AccountLoginError{
-			Reason: protoenum.AccountLoginErrorReason.AlreadyLoggedGameServer,
-		})
-		return errInvalidRequest
+		return err
 	}
 
-	account, err := s.svr.dofus.Account(ctx, s.accountId)
+	user, err := s.svr.dofus.User(ctx, account.UserId)
 	if err != nil {
 		return err
 	}
 
-	user, err := s.svr.dofus.User(ctx, account.UserId)
+	ban, ok := s.svr.banned(account.Id, user.Id, s.ip)
+	if ok {
+		s.svr.logger.Debugw("banned",
+			"kind", ban.Kind,
+			"account_id", account.Id,
+			"client_address", s.remoteAddr.String(),
+		)
+		s.sendMessage(ban.loginError(time.Now()))
+		return errInvalidRequest
+	}
+
+	err = s.svr.controlAccount(account.Id, user.Id, s)
 	if err != nil {
-		return err
+		s.svr.logger.Debugw("could not control account",
+			"error", err,
+			"client_address", s.remoteAddr.String(),
+		)
+		s.sendMessage(msgsvr.AccountLoginError{
+			Reason: protoenum.AccountLoginErrorReason.AlreadyLoggedGameServer,
+		})
+		return errInvalidRequest
 	}
-	s.userId = user.Id
+	s.permission = s.svr.permissionLevel(account)
 
-	ip, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	ip, _, err := net.SplitHostPort(s.remoteAddr.String())
 	if err != nil {
 		return err
 	}
@@ -117,15 +139,26 @@ func (s *session) handleAccountSendTicket(ctx context.Context, m msgcli.AccountS
 
 	s.sendMessage(msgsvr.AccountTicketResponseSuccess{KeyId: 0})
 
+	s.svr.guard.authenticate()
+	s.authenticated = true
+
 	s.status.Store(statusExpectingAccountUseKey)
 	return nil
 }
 
+func (s *session) ticketFailed() {
+	if s.svr.guard.ticketFailed(s.ip) {
+		s.svr.logger.Infow("banned ip after invalid tickets",
+			"client_address", s.remoteAddr.String(),
+		)
+	}
+}
+
 func (s *session) handleAccountUseKey(m msgcli.AccountUseKey) error {
 	if m.Id != 0 {
 		s.svr.logger.Debugw("unexpected key id",
 			"key_id", m.Id,
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
 		return errInvalidRequest
 	}
@@ -397,7 +430,7 @@ func (s *session) handleAccountAddCharacter(ctx context.Context, m msgcli.Accoun
 		},
 	}
 
-	class, ok := s.svr.cache.static.classes[classId]
+	class, ok := s.svr.cache().static.classes[classId]
 	if !ok {
 		return errors.New("class not found")
 	}
@@ -459,7 +492,7 @@ func (s *session) handleAccountDeleteCharacter(ctx context.Context, m msgcli.Acc
 	if char.AccountId != s.accountId {
 		s.svr.logger.Debugw("account does not own character",
 			"error", err,
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
 		return errInvalidRequest
 	}
@@ -475,7 +508,7 @@ func (s *session) handleAccountDeleteCharacter(ctx context.Context, m msgcli.Acc
 		if !strings.EqualFold(strings.TrimSpace(m.SecretAnswer), strings.TrimSpace(secretAnswer)) {
 			s.svr.logger.Debugw("wrong secret answer",
 				"error", err,
-				"client_address", s.conn.RemoteAddr().String(),
+				"client_address", s.remoteAddr.String(),
 			)
 			s.sendMessage(msgsvr.AccountCharacterDeleteError{})
 			return nil
@@ -498,20 +531,28 @@ func (s *session) handleAccountSetCharacter(ctx context.Context, m msgcli.Accoun
 	if char.AccountId != s.accountId {
 		s.svr.logger.Debugw("account does not own character",
 			"error", err,
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
 		return errInvalidRequest
 	}
 
+	characterItems, err := s.svr.retro.CharacterItemsByCharacterId(ctx, char.Id)
+	if err != nil {
+		return err
+	}
+
+	err = s.svr.loadWaypoints(ctx, char.Id)
+	if err != nil {
+		return err
+	}
+
 	s.svr.mu.Lock()
 	s.characterId = char.Id
+	s.state = newCharacterState(char, characterItems)
 	s.svr.sessionByCharacterId[char.Id] = s
 	s.svr.mu.Unlock()
 
-	characterItems, err := s.svr.retro.CharacterItemsByCharacterId(ctx, char.Id)
-	if err != nil {
-		return err
-	}
+	s.svr.syncGameMap(s)
 
 	gfxId, err := strconv.Atoi(fmt.Sprintf("%d%d", char.ClassId, char.Sex))
 	if err != nil {
@@ -555,17 +596,17 @@ func (s *session) handleGameCreate(ctx context.Context, m msgcli.GameCreate) err
 	if m.Type != protoenum.GameCreateType.Solo {
 		s.svr.logger.Debugw("wrong game create type",
 			"type", m.Type,
-			"client_address", s.conn.RemoteAddr().String(),
+			"client_address", s.remoteAddr.String(),
 		)
 		return errInvalidRequest
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	items, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	items, err := s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -576,7 +617,7 @@ func (s *session) handleGameCreate(ctx context.Context, m msgcli.GameCreate) err
 			continue
 		}
 
-		template, ok := s.svr.cache.static.items[v.TemplateId]
+		template, ok := s.svr.cache().static.items[v.TemplateId]
 		if !ok {
 			return errors.New("item template not found")
 		}
@@ -592,7 +633,7 @@ func (s *session) handleGameCreate(ctx context.Context, m msgcli.GameCreate) err
 	}
 
 	if char.MountId != 0 {
-		mount, err := s.svr.retro.Mount(ctx, char.MountId)
+		mount, err := s.mount(ctx, char.MountId)
 		if err != nil {
 			return err
 		}
@@ -726,7 +767,7 @@ func (s *session) handleGameCreate(ctx context.Context, m msgcli.GameCreate) err
 		})
 	}
 
-	ip, _, err := net.SplitHostPort(s.conn.RemoteAddr().String())
+	ip, _, err := net.SplitHostPort(s.remoteAddr.String())
 	if err != nil {
 		return err
 	}
@@ -750,7 +791,7 @@ func (s *session) handleGameCreate(ctx context.Context, m msgcli.GameCreate) err
 
 	s.sendMessage(msgsvr.InfosLifeRestoreTimerStart{Interval: time.Second * 2}) // TODO
 
-	gameMap, ok := s.svr.cache.static.gameMaps[char.GameMapId]
+	gameMap, ok := s.svr.cache().static.gameMaps[char.GameMapId]
 	if !ok {
 		return errors.New("invalid game map")
 	}
@@ -834,12 +875,12 @@ func (s *session) handleChatRequestSubscribeChannelRemove(ctx context.Context, m
 }
 
 func (s *session) handleGameGetExtraInformations(ctx context.Context) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	npcs := s.svr.cache.npcsByMapId[char.GameMapId]
+	npcs := s.svr.cache().npcsByMapId[char.GameMapId]
 	npcSprites := make([]msgsvr.GameMovementSprite, len(npcs))
 	for i, v := range npcs {
 		npcSprites[i] = msgsvr.GameMovementSprite{
@@ -865,20 +906,7 @@ func (s *session) handleGameGetExtraInformations(ctx context.Context) error {
 		}
 	}
 
-	s.svr.mu.Lock()
-	chars, err := s.svr.retro.CharactersByGameMapId(ctx, char.GameMapId)
-	if err != nil {
-		s.svr.mu.Unlock()
-		return err
-	}
-
-	for k := range chars {
-		_, ok := s.svr.sessionByCharacterId[k]
-		if !ok {
-			delete(chars, k)
-		}
-	}
-	s.svr.mu.Unlock()
+	chars := s.svr.gameMapCharacters(char.GameMapId)
 
 	charSprites := make([]msgsvr.GameMovementSprite, len(chars))
 	i := 0
@@ -896,6 +924,11 @@ func (s *session) handleGameGetExtraInformations(ctx context.Context) error {
 
 	s.sendMessage(msgsvr.GameMovement{Sprites: sprites})
 
+	cellObjects := s.svr.groundCellObjects(char.GameMapId)
+	if len(cellObjects.Objects) > 0 {
+		s.sendMessage(cellObjects)
+	}
+
 	s.sendMessage(msgsvr.GameMapLoaded{})
 
 	return nil
@@ -913,19 +946,14 @@ func (s *session) handleChatSend(ctx context.Context, m msgcli.ChatSend) error {
 		return nil
 	}
 
-	account, err := s.svr.dofus.Account(ctx, s.accountId)
-	if err != nil {
-		return err
-	}
-
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
 	switch m.ChatChannel {
 	case dofustyp.ChatChannelAdmin:
-		if !account.Admin {
+		if s.permission == PermissionPlayer {
 			s.sendMessage(msgsvr.BasicsNothing{})
 			return nil
 		}
@@ -938,9 +966,9 @@ func (s *session) handleChatSend(ctx context.Context, m msgcli.ChatSend) error {
 			Params:      m.Params,
 		})
 	case dofustyp.ChatChannelPublic:
-		if account.Admin {
+		if s.permission > PermissionPlayer {
 			if len(m.Message) >= 2 && m.Message[0] == '.' {
-				return s.chatCommand(ctx, m.Message[1:])
+				return s.runCommand(ctx, m.Message[1:])
 			}
 		}
 
@@ -975,12 +1003,12 @@ func (s *session) handleDialogCreate(ctx context.Context, m msgcli.DialogCreate)
 
 	i := m.NPCId*-1 - 1
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	npcs := s.svr.cache.npcsByMapId[char.GameMapId]
+	npcs := s.svr.cache().npcsByMapId[char.GameMapId]
 	if len(npcs) <= i {
 		return errInvalidRequest
 	}
@@ -991,7 +1019,7 @@ func (s *session) handleDialogCreate(ctx context.Context, m msgcli.DialogCreate)
 		return errInvalidRequest
 	}
 
-	dialog, ok := s.svr.cache.static.npcDialogs[npc.DialogId]
+	dialog, ok := s.svr.cache().static.npcDialogs[npc.DialogId]
 	if !ok {
 		return fmt.Errorf("dialog does not exist: %d", npc.DialogId)
 	}
@@ -1015,7 +1043,7 @@ func (s *session) handleDialogRequestLeave(ctx context.Context) error {
 func (s *session) handleDialogResponse(ctx context.Context, m msgcli.DialogResponse) error {
 	// TODO: check question is valid for current dialog context (and some other security checks too)
 
-	response, ok := s.svr.cache.static.npcResponses[m.Answer]
+	response, ok := s.svr.cache().static.npcResponses[m.Answer]
 	if !ok {
 		return errInvalidRequest
 	}
@@ -1031,7 +1059,7 @@ func (s *session) handleDialogResponse(ctx context.Context, m msgcli.DialogRespo
 		if err != nil {
 			return err
 		}
-		dialog, ok := s.svr.cache.static.npcDialogs[dialogId]
+		dialog, ok := s.svr.cache().static.npcDialogs[dialogId]
 		if !ok {
 			return errors.New("invalid dialog id")
 		}
@@ -1053,13 +1081,13 @@ func (s *session) handleExchangeRequest(ctx context.Context, m msgcli.ExchangeRe
 		return errInvalidRequest
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
 	i := m.Id*-1 - 1
-	npcs := s.svr.cache.npcsByMapId[char.GameMapId]
+	npcs := s.svr.cache().npcsByMapId[char.GameMapId]
 	if len(npcs) <= i {
 		return errInvalidRequest
 	}
@@ -1070,7 +1098,7 @@ func (s *session) handleExchangeRequest(ctx context.Context, m msgcli.ExchangeRe
 		return errInvalidRequest
 	}
 
-	market, ok := s.svr.cache.markets[npc.MarketId]
+	market, ok := s.svr.cache().markets[npc.MarketId]
 	if !ok {
 		return fmt.Errorf("market does not exist: %q", npc.MarketId)
 	}
@@ -1136,17 +1164,36 @@ func (s *session) handleExchangeBigStoreItemList(ctx context.Context, m msgcli.E
 	return nil
 }
 
-func (s *session) handleExchangeBigStoreSearch(ctx context.Context, m msgcli.ExchangeBigStoreSearch) error {
+func (s *session) handleExchangeBigStoreSearch(ctx context.Context, m exchangeBigStoreSearch) error {
 	if s.cache.exchangeMarket == nil {
 		return errInvalidRequest
 	}
 
-	templateIds, err := s.svr.marketTemplateIdsByItemType(ctx, *s.cache.exchangeMarket, m.ItemType)
-	if err != nil {
-		return err
+	itemType, templateId := m.ItemType, m.TemplateId
+	var templateIds []int
+	if m.Query != "" {
+		q, err := parseMarketQuery(m.Query)
+		if err != nil {
+			s.sendMessage(msgsvr.ExchangeSearchError{})
+			return nil
+		}
+
+		results := s.svr.cache().listings.index(s.cache.exchangeMarket.Id).search(q)
+		itemType, templateIds = rankedTemplateIds(results, m.ItemType)
+		if len(templateIds) == 0 {
+			s.sendMessage(msgsvr.ExchangeSearchError{})
+			return nil
+		}
+		templateId = templateIds[0]
+	} else {
+		var err error
+		templateIds, err = s.svr.marketTemplateIdsByItemType(ctx, *s.cache.exchangeMarket, m.ItemType)
+		if err != nil {
+			return err
+		}
 	}
 
-	items, err := s.svr.marketItemsByTemplateId(ctx, *s.cache.exchangeMarket, m.TemplateId)
+	items, err := s.svr.marketItemsByTemplateId(ctx, *s.cache.exchangeMarket, templateId)
 	if err != nil {
 		return err
 	}
@@ -1159,12 +1206,12 @@ func (s *session) handleExchangeBigStoreSearch(ctx context.Context, m msgcli.Exc
 	s.sendMessage(msgsvr.ExchangeSearchSuccess{})
 
 	s.sendMessage(msgsvr.ExchangeBigStoreTypeItemsList{
-		ItemType:        m.ItemType,
+		ItemType:        itemType,
 		ItemTemplateIds: templateIds,
 	})
 
 	s.sendMessage(msgsvr.ExchangeBigStoreItemsList{
-		TemplateId: m.TemplateId,
+		TemplateId: templateId,
 		Items:      items,
 	})
 
@@ -1189,27 +1236,43 @@ func (s *session) handleExchangeBigStoreBuy(ctx context.Context, m msgcli.Exchan
 		return errInvalidRequest
 	}
 
-	items, ok := s.svr.cache.marketItemsByMarketId[s.cache.exchangeMarket.Id]
+	lot, ok := s.svr.marketLot(*s.cache.exchangeMarket, m.ItemId)
 	if !ok {
 		return errInvalidRequest
 	}
 
-	item, ok := items[m.ItemId]
-	if !ok {
+	if m.QuantityIndex < 1 || m.QuantityIndex > len(lot.Prices) {
+		return errInvalidRequest
+	}
+
+	price := lot.Prices[m.QuantityIndex-1]
+	if price <= 0 {
 		return errInvalidRequest
 	}
 
-	itemTemplate, ok := s.svr.cache.static.items[item.TemplateId]
+	item := lot.Item
+	item.Quantity = marketQuantities(*s.cache.exchangeMarket)[m.QuantityIndex-1]
+
+	itemTemplate, ok := s.svr.cache().static.items[item.TemplateId]
 	if !ok {
 		return errors.New("item template not found")
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
+	if m.Price != price || price > char.Kamas {
+		s.sendMessage(msgsvr.ExchangeBuyError{})
+		return nil
+	}
+
 	if itemTemplate.Type == retrotyp.ItemTypeMountCertificate {
+		if item.Quantity != 1 {
+			return errInvalidRequest
+		}
+
 		mountTemplateId, ok := retro.MountTemplateIdByMountCertificateId[itemTemplate.Id]
 		if !ok {
 			return errors.New("mount template id not found")
@@ -1217,7 +1280,7 @@ func (s *session) handleExchangeBigStoreBuy(ctx context.Context, m msgcli.Exchan
 
 		validity := time.Now().Add(time.Hour * 24 * 20).Truncate(time.Minute)
 
-		mountId, err := s.svr.retro.CreateMount(ctx, retro.Mount{
+		mountId, err := s.createMount(ctx, retro.Mount{
 			TemplateId:  mountTemplateId,
 			CharacterId: 0,
 			Name:        "",
@@ -1239,14 +1302,9 @@ func (s *session) handleExchangeBigStoreBuy(ctx context.Context, m msgcli.Exchan
 		}
 	}
 
-	if item.Price > char.Kamas {
-		s.sendMessage(msgsvr.ExchangeBuyError{})
-		return nil
-	}
-
-	char.Kamas -= item.Price
+	char.Kamas -= price
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -1257,7 +1315,7 @@ func (s *session) handleExchangeBigStoreBuy(ctx context.Context, m msgcli.Exchan
 	}
 	s.sendMessage(stats)
 
-	_, err = s.addItemToInventory(ctx, item.Item)
+	_, err = s.addItemToInventory(ctx, item)
 	if err != nil {
 		return err
 	}
@@ -1269,6 +1327,8 @@ func (s *session) handleExchangeBigStoreBuy(ctx context.Context, m msgcli.Exchan
 
 	s.sendMessage(msgsvr.ExchangeBuySuccess{})
 
+	s.svr.metrics.marketTransaction(price)
+
 	return nil
 }
 
@@ -1277,7 +1337,7 @@ func (s *session) handleItemsDestroy(ctx context.Context, m msgcli.ItemsDestroy)
 		return errInvalidRequest
 	}
 
-	item, err := s.svr.retro.CharacterItem(ctx, m.Id)
+	item, err := s.characterItem(ctx, m.Id)
 	if err != nil {
 		return err
 	}
@@ -1300,13 +1360,13 @@ func (s *session) handleItemsDestroy(ctx context.Context, m msgcli.ItemsDestroy)
 		return err
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 	char.Kamas += m.Quantity
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -1346,7 +1406,86 @@ func (s *session) handleItemsDestroy(ctx context.Context, m msgcli.ItemsDestroy)
 }
 
 func (s *session) handleItemsDrop(ctx context.Context, m msgcli.ItemsDrop) error {
-	return s.handleItemsDestroy(ctx, msgcli.ItemsDestroy(m))
+	if m.Quantity < 1 {
+		return errInvalidRequest
+	}
+
+	item, err := s.characterItem(ctx, m.Id)
+	if err != nil {
+		return err
+	}
+
+	if item.CharacterId != s.characterId {
+		return errInvalidRequest
+	}
+
+	if m.Quantity > item.Quantity {
+		return errInvalidRequest
+	}
+
+	if item.Position != retrotyp.CharacterItemPositionInventory {
+		return errNotAllowed
+	}
+
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := s.svr.cache()
+	gameMap, ok := c.static.gameMaps[char.GameMapId]
+	if !ok {
+		return errors.New("game map not found")
+	}
+
+	geometry, err := c.gameMapGeometry(gameMap)
+	if err != nil {
+		return err
+	}
+
+	dropped := item.Item
+	dropped.Id = 0
+	dropped.Quantity = m.Quantity
+
+	cellId, ok := s.svr.placeGroundItem(gameMap.Id, char.Cell, geometry, groundItem{
+		Item:    dropped,
+		ownerId: char.Id,
+		dropped: time.Now(),
+	})
+	if !ok {
+		// TODO: hardcode in lang
+		s.sendMessage(msgsvr.InfosMessage{
+			ChatId: protoenum.InfosMessageChatId.Error,
+			Messages: []prototyp.InfosMessageMessage{
+				{
+					Id:   16,
+					Args: []string{"<b>Error</b>", "There is no free cell around you."},
+				},
+			},
+		})
+		return nil
+	}
+
+	err = s.removeItem(ctx, item.Id, m.Quantity)
+	if err != nil {
+		s.svr.removeGroundItem(gameMap.Id, cellId)
+		return err
+	}
+
+	err = s.sendWeight(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.svr.sendMsgToMap(ctx, gameMap.Id, gameCellObject{Objects: []gameCellObjectObject{{
+		CellId:     cellId,
+		TemplateId: dropped.TemplateId,
+	}}})
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // TODO
@@ -1355,7 +1494,7 @@ func (s *session) handleItemsRequestMovement(ctx context.Context, m msgcli.Items
 		return errInvalidRequest
 	}
 
-	item, err := s.svr.retro.CharacterItem(ctx, m.Id)
+	item, err := s.characterItem(ctx, m.Id)
 	if err != nil {
 		return err
 	}
@@ -1403,7 +1542,7 @@ func (s *session) handleItemsRequestMovement(ctx context.Context, m msgcli.Items
 		}
 	} else if m.Position >= 35 && m.Position <= 62 {
 		if item.Position == retrotyp.CharacterItemPositionInventory {
-			itemTemplate, ok := s.svr.cache.static.items[item.TemplateId]
+			itemTemplate, ok := s.svr.cache().static.items[item.TemplateId]
 			if !ok {
 				return fmt.Errorf("invalid item template")
 			}
@@ -1445,12 +1584,12 @@ func (s *session) handleAccountBoost(ctx context.Context, m msgcli.AccountBoost)
 		return errors.New("boost amount must be positive")
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	class, ok := s.svr.cache.static.classes[char.ClassId]
+	class, ok := s.svr.cache().static.classes[char.ClassId]
 	if !ok {
 		return errors.New("class not found")
 	}
@@ -1502,7 +1641,7 @@ func (s *session) handleAccountBoost(ctx context.Context, m msgcli.AccountBoost)
 		char.Stats.Agility += bonus
 	}
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -1529,7 +1668,7 @@ func (s *session) handleAccountBoost(ctx context.Context, m msgcli.AccountBoost)
 }
 
 func (s *session) handleSpellsBoost(ctx context.Context, m msgcli.SpellsBoost) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1543,7 +1682,7 @@ func (s *session) handleSpellsBoost(ctx context.Context, m msgcli.SpellsBoost) e
 		}
 		found = true
 
-		t, ok := s.svr.cache.static.spells[spell.Id]
+		t, ok := s.svr.cache().static.spells[spell.Id]
 		if !ok {
 			return errors.New("spell not found")
 		}
@@ -1564,7 +1703,7 @@ func (s *session) handleSpellsBoost(ctx context.Context, m msgcli.SpellsBoost) e
 		return errors.New("character doesn't know spell")
 	}
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -1592,7 +1731,7 @@ func (s *session) handleSpellsMoveToUsed(ctx context.Context, m msgcli.SpellsMov
 		return errors.New("invalid position")
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1616,7 +1755,7 @@ func (s *session) handleSpellsMoveToUsed(ctx context.Context, m msgcli.SpellsMov
 		return errors.New("character doesn't know spell")
 	}
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -1631,11 +1770,7 @@ func (s *session) handleItemsUseNoConfirm(ctx context.Context, m msgcli.ItemsUse
 		return errInvalidRequest
 	}
 
-	if m.SpriteId != 0 || m.Cell != 0 {
-		return errNotImplemented
-	}
-
-	items, err := s.svr.retro.CharacterItemsByCharacterId(ctx, s.characterId)
+	items, err := s.characterItems(ctx)
 	if err != nil {
 		return err
 	}
@@ -1649,13 +1784,16 @@ func (s *session) handleItemsUseNoConfirm(ctx context.Context, m msgcli.ItemsUse
 		return errInvalidRequest
 	}
 
-	t, ok := s.svr.cache.static.items[item.TemplateId]
+	t, ok := s.svr.cache().static.items[item.TemplateId]
 	if !ok {
 		return errors.New("item template not found")
 	}
 
-	switch t.Type {
-	case retrotyp.ItemTypeCandy:
+	if t.Type == retrotyp.ItemTypeCandy {
+		if m.SpriteId != 0 || m.Cell != 0 {
+			return errInvalidRequest
+		}
+
 		err := s.equip(ctx, item.Id, retrotyp.CharacterItemPositionBoostFood)
 		if err != nil {
 			return err
@@ -1670,57 +1808,11 @@ func (s *session) handleItemsUseNoConfirm(ctx context.Context, m msgcli.ItemsUse
 		if err != nil {
 			return err
 		}
-	case retrotyp.ItemTypeUsableItem:
-		switch t.Id {
-		case 7651, 7799:
-			char, err := s.svr.retro.Character(ctx, s.characterId)
-			if err != nil {
-				return err
-			}
 
-			err = s.mountOrDismount(ctx, !char.Mounting)
-			if err != nil {
-				return err
-			}
-		case 8626: // TODO: should change for custom item template
-			char, err := s.svr.retro.Character(ctx, s.characterId)
-			if err != nil {
-				return err
-			}
-
-			mounts, err := s.svr.retro.MountsByCharacterId(ctx, s.characterId)
-			if err != nil {
-				return err
-			}
-
-			var shed []prototyp.CommonMountData
-			for _, mount := range mounts {
-				if mount.Id == char.MountId {
-					continue
-				}
-
-				data, err := s.svr.commonMountData(mount)
-				if err != nil {
-					return err
-				}
-				shed = append(shed, data)
-			}
-
-			s.sendMessage(msgsvr.ExchangeCreateSuccess{
-				Type: retrotyp.ExchangePaddock,
-				Paddock: msgsvr.ExchangeCreateSuccessPaddock{
-					Shed:    shed,
-					Paddock: nil,
-				},
-			})
-		default:
-			return errNotImplemented
-		}
-	default:
-		return errNotImplemented
+		return nil
 	}
 
-	return nil
+	return s.useItem(ctx, item, t, m.SpriteId, m.Cell)
 }
 
 func (s *session) handleEmotesSetDirection(ctx context.Context, m msgcli.EmotesSetDirection) error {
@@ -1728,7 +1820,7 @@ func (s *session) handleEmotesSetDirection(ctx context.Context, m msgcli.EmotesS
 		return errInvalidRequest
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1740,7 +1832,7 @@ func (s *session) handleEmotesSetDirection(ctx context.Context, m msgcli.EmotesS
 
 	char.Direction = m.Dir
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -1757,7 +1849,7 @@ func (s *session) handleEmotesSetDirection(ctx context.Context, m msgcli.EmotesS
 }
 
 func (s *session) handleMountRequestData(ctx context.Context, m msgcli.MountRequestData) error {
-	mount, err := s.svr.retro.Mount(ctx, m.Id)
+	mount, err := s.mount(ctx, m.Id)
 	if err != nil {
 		if errors.Is(err, retro.ErrNotFound) {
 			s.sendMessage(msgsvr.InfosMessage{
@@ -1798,7 +1890,7 @@ func (s *session) handleMountRename(ctx context.Context, m msgcli.MountRename) e
 		return err
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1807,13 +1899,13 @@ func (s *session) handleMountRename(ctx context.Context, m msgcli.MountRename) e
 		return errInvalidRequest
 	}
 
-	mount, err := s.svr.retro.Mount(ctx, char.MountId)
+	mount, err := s.mount(ctx, char.MountId)
 	if err != nil {
 		return err
 	}
 	mount.Name = m.Name
 
-	err = s.svr.retro.UpdateMount(ctx, mount)
+	err = s.updateMount(ctx, mount)
 	if err != nil {
 		return err
 	}
@@ -1829,7 +1921,7 @@ func (s *session) handleMountFree(ctx context.Context) error {
 		return err
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1838,12 +1930,12 @@ func (s *session) handleMountFree(ctx context.Context) error {
 
 	char.MountId = 0
 	char.Kamas += 1
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
 
-	err = s.svr.retro.DeleteMount(ctx, originalMountId)
+	err = s.deleteMount(ctx, originalMountId)
 	if err != nil {
 		return err
 	}
@@ -1860,7 +1952,7 @@ func (s *session) handleMountFree(ctx context.Context) error {
 }
 
 func (s *session) handleMountRide(ctx context.Context) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1874,6 +1966,10 @@ func (s *session) handleMountRide(ctx context.Context) error {
 }
 
 func (s *session) handleGameActionsSendActions(ctx context.Context, m msgcli.GameActionsSendActions) error {
+	if s.svr.shuttingDown.Load() {
+		return errNotAllowed
+	}
+
 	switch m.ActionType {
 	case protoenum.GameActionType.Movement:
 		return s.actionMovement(ctx, m.ActionMovement)
@@ -1888,6 +1984,14 @@ func (s *session) handleGameActionsSendActions(ctx context.Context, m msgcli.Gam
 	}
 }
 
+func (s *session) handleGameActionsUseObject(ctx context.Context, m gameActionsUseObject) error {
+	if s.svr.shuttingDown.Load() {
+		return errNotAllowed
+	}
+
+	return s.actionUseObject(ctx, m)
+}
+
 func (s *session) handleGameActionCancel(ctx context.Context, m msgcli.GameActionCancel) error {
 	gameAction, ok := s.gameActions[m.Id]
 	if !ok {
@@ -1903,7 +2007,7 @@ func (s *session) handleGameActionCancel(ctx context.Context, m msgcli.GameActio
 		return err
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -1921,18 +2025,40 @@ func (s *session) handleGameActionCancel(ctx context.Context, m msgcli.GameActio
 		return s.handleGameActionAck(ctx, msgcli.GameActionAck{Id: m.Id})
 	}
 
+	if s.movement == nil {
+		return errInvalidRequest
+	}
+	step := slices.Index(s.movement.path, cell)
+	if step == -1 {
+		return errInvalidRequest
+	}
+
+	ok, err = s.checkMovementSpeed(ctx, step+1, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.rejectMovement(ctx, m.Id)
+	}
+
 	char.Cell = cell
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
 
 	delete(s.gameActions, m.Id)
 	s.busy.Dec()
+	s.movement = nil
 
 	s.sendMessage(msgsvr.BasicsNothing{})
 
+	err = s.pickUpGroundItem(ctx, char.GameMapId, char.Cell)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -1946,84 +2072,76 @@ func (s *session) handleGameActionAck(ctx context.Context, m msgcli.GameActionAc
 		return errNotImplemented
 	}
 
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
 	final := gameAction.ActionMovement.DirAndCells[len(gameAction.ActionMovement.DirAndCells)-1]
 
+	if s.movement != nil {
+		ok, err = s.checkMovementSpeed(ctx, len(s.movement.path), time.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return s.rejectMovement(ctx, m.Id)
+		}
+	}
+
 	char.Cell = final.CellId
 	char.Direction = final.DirId
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
 
 	delete(s.gameActions, m.Id)
 	s.busy.Dec()
+	s.movement = nil
 
 	s.sendMessage(msgsvr.BasicsNothing{})
 
+	err = s.pickUpGroundItem(ctx, char.GameMapId, char.Cell)
+	if err != nil {
+		return err
+	}
+
 	trigger, err := s.svr.retro.TriggerByGameMapIdAndCellId(ctx, char.GameMapId, char.Cell)
 	if err != nil {
 		if !errors.Is(err, retro.ErrNotFound) {
 			return err
 		}
 	} else {
-		err = s.svr.sendMsgToMap(ctx, char.GameMapId, msgsvr.GameMovementRemove{Id: char.Id})
-		if err != nil {
-			return err
-		}
-
-		char.GameMapId = trigger.TargetGameMapId
-		char.Cell = trigger.TargetCellId
-
-		err = s.svr.retro.UpdateCharacter(ctx, char)
+		err = s.teleport(ctx, trigger.TargetGameMapId, trigger.TargetCellId)
 		if err != nil {
 			return err
 		}
+	}
 
-		s.sendMessage(msgsvr.GameActions{
-			ActionType: protoenum.GameActionType.LoadGameMap,
-			ActionLoadGameMap: msgsvr.GameActionsActionLoadGameMap{
-				SpriteId:  char.Id,
-				Cinematic: 0,
-			},
-		})
-
-		gameMap, ok := s.svr.cache.static.gameMaps[char.GameMapId]
-		if !ok {
-			return errors.New("invalid game map")
-		}
-
-		s.sendMessage(msgsvr.GameMapData{
-			Id:   gameMap.Id,
-			Name: gameMap.Name,
-			Key:  gameMap.Key,
-		})
-
-		s.sendMessage(msgsvr.BasicsTime{Value: time.Now()})
+	return nil
+}
 
-		s.sendMessage(msgsvr.FightsCount{Value: 0}) // TODO
+// rejectMovement ends a movement the client did faster than possible by putting the character back where it
+// started.
+func (s *session) rejectMovement(ctx context.Context, id int) error {
+	from := s.movement.from
 
-		sprite, err := s.svr.gameMovementSpriteCharacter(ctx, char, false)
-		if err != nil {
-			return err
-		}
+	delete(s.gameActions, id)
+	s.busy.Dec()
+	s.movement = nil
 
-		err = s.svr.sendMsgToMap(ctx, char.GameMapId, msgsvr.GameMovement{Sprites: []msgsvr.GameMovementSprite{sprite}})
-		if err != nil {
-			return err
-		}
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return s.teleport(ctx, char.GameMapId, from)
 }
 
 func (s *session) handleExchangePutInShedFromCertificate(ctx context.Context, m msgcli.ExchangePutInShedFromCertificate) error {
-	charItem, err := s.svr.retro.CharacterItem(ctx, m.CertificateId)
+	charItem, err := s.characterItem(ctx, m.CertificateId)
 	if err != nil {
 		return err
 	}
@@ -2051,14 +2169,14 @@ func (s *session) handleExchangePutInShedFromCertificate(ctx context.Context, m
 		return err
 	}
 
-	mount, err := s.svr.retro.Mount(ctx, mountId)
+	mount, err := s.mount(ctx, mountId)
 	if err != nil {
 		return err
 	}
 	mount.Validity = time.Time{}
 	mount.CharacterId = s.characterId
 
-	err = s.svr.retro.UpdateMount(ctx, mount)
+	err = s.updateMount(ctx, mount)
 	if err != nil {
 		return err
 	}
@@ -2077,12 +2195,12 @@ func (s *session) handleExchangePutInShedFromCertificate(ctx context.Context, m
 }
 
 func (s *session) handleExchangePutInShedFromInventory(ctx context.Context, m msgcli.ExchangePutInShedFromInventory) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	mount, err := s.svr.retro.Mount(ctx, m.MountId)
+	mount, err := s.mount(ctx, m.MountId)
 	if err != nil {
 		return err
 	}
@@ -2100,13 +2218,13 @@ func (s *session) handleExchangePutInShedFromInventory(ctx context.Context, m ms
 
 	s.sendMessage(msgsvr.MountUnequip{})
 
-	char, err = s.svr.retro.Character(ctx, s.characterId)
+	char, err = s.character(ctx)
 	if err != nil {
 		return err
 	}
 	char.MountId = 0
 
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -2125,12 +2243,12 @@ func (s *session) handleExchangePutInShedFromInventory(ctx context.Context, m ms
 }
 
 func (s *session) handleExchangePutInCertificateFromShed(ctx context.Context, m msgcli.ExchangePutInCertificateFromShed) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	mount, err := s.svr.retro.Mount(ctx, m.MountId)
+	mount, err := s.mount(ctx, m.MountId)
 	if err != nil {
 		return err
 	}
@@ -2153,7 +2271,7 @@ func (s *session) handleExchangePutInCertificateFromShed(ctx context.Context, m
 	mount.CharacterId = 0
 	mount.Validity = time.Now().Add(time.Hour * 24 * 20).Truncate(time.Minute)
 
-	err = s.svr.retro.UpdateMount(ctx, mount)
+	err = s.updateMount(ctx, mount)
 	if err != nil {
 		return err
 	}
@@ -2188,7 +2306,7 @@ func (s *session) handleExchangePutInCertificateFromShed(ctx context.Context, m
 }
 
 func (s *session) handleExchangePutInInventoryFromShed(ctx context.Context, m msgcli.ExchangePutInInventoryFromShed) error {
-	char, err := s.svr.retro.Character(ctx, s.characterId)
+	char, err := s.character(ctx)
 	if err != nil {
 		return err
 	}
@@ -2200,12 +2318,12 @@ func (s *session) handleExchangePutInInventoryFromShed(ctx context.Context, m ms
 		}
 	}
 
-	char, err = s.svr.retro.Character(ctx, s.characterId)
+	char, err = s.character(ctx)
 	if err != nil {
 		return err
 	}
 
-	mount, err := s.svr.retro.Mount(ctx, m.MountId)
+	mount, err := s.mount(ctx, m.MountId)
 	if err != nil {
 		return err
 	}
@@ -2221,7 +2339,7 @@ func (s *session) handleExchangePutInInventoryFromShed(ctx context.Context, m ms
 	s.sendMessage(msgsvr.ExchangeMountStorageRemove{MountId: mount.Id})
 
 	char.MountId = mount.Id
-	err = s.svr.retro.UpdateCharacter(ctx, char)
+	err = s.updateCharacter(ctx, char)
 	if err != nil {
 		return err
 	}
@@ -2242,63 +2360,3 @@ func (s *session) handleExchangePutInInventoryFromShed(ctx context.Context, m ms
 
 	return nil
 }
-
-func (s *session) chatCommand(ctx context.Context, cmd string) error {
-	if cmd == "" {
-		return errors.New("command string is empty")
-	}
-
-	sli := strings.SplitN(cmd, " ", 2)
-
-	cmd = strings.ToLower(sli[0])
-
-	extra := ""
-	if len(sli) >= 2 {
-		extra = sli[1]
-	}
-
-	switch cmd {
-	case "reset":
-		err := s.resetCharacteristics(ctx)
-		if err != nil {
-			return err
-		}
-
-		s.sendMessage(msgsvr.ChatServerMessage{Message: "<b>Success</b>: Characteristics were reset."})
-	case "level", "lvl":
-		level, err := strconv.Atoi(extra)
-		if err != nil {
-			s.sendMessage(msgsvr.InfosMessage{
-				ChatId: protoenum.InfosMessageChatId.Error,
-				Messages: []prototyp.InfosMessageMessage{
-					{
-						Id:   16,
-						Args: []string{"<b>Error</b>", err.Error()},
-					},
-				},
-			})
-			return nil
-		}
-
-		err = s.setLevel(ctx, level)
-		if err != nil {
-			return err
-		}
-
-		s.sendMessage(msgsvr.ChatServerMessage{Message: fmt.Sprintf("<b>Success</b>: Level set to %d.", level)})
-	case "forget":
-		s.sendMessage(msgsvr.SpellsSpellForgetShow{})
-	default:
-		s.sendMessage(msgsvr.InfosMessage{
-			ChatId: protoenum.InfosMessageChatId.Error,
-			Messages: []prototyp.InfosMessageMessage{
-				{
-					Id:   16,
-					Args: []string{"<b>Error</b>", fmt.Sprintf("Command %q does not exist.", cmd)},
-				},
-			},
-		})
-	}
-
-	return nil
-}
@@ -0,0 +1,122 @@
+package retropvp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTransport is a transport that records what's written to it.
+type testTransport struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (t *testTransport) readPacket() (string, error) {
+	return "", io.EOF
+}
+
+func (t *testTransport) writePacket(pkt string) error {
+	t.record("write " + pkt)
+	return nil
+}
+
+func (t *testTransport) flush() error {
+	t.record("flush")
+	return nil
+}
+
+func (t *testTransport) setReadDeadline(tm time.Time) error {
+	return nil
+}
+
+func (t *testTransport) setWriteDeadline(tm time.Time) error {
+	t.record("deadline")
+	return nil
+}
+
+func (t *testTransport) close() error {
+	return nil
+}
+
+func (t *testTransport) record(event string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.events = append(t.events, event)
+}
+
+// Test_session_writePackets queues packets from several goroutines while they're written, and checks that each of
+// them is written once, in order, after the write deadline of its batch was set.
+func Test_session_writePackets(t *testing.T) {
+	type testCase struct {
+		name      string
+		senders   int
+		perSender int
+	}
+
+	testCases := []testCase{
+		{name: "one sender", senders: 1, perSender: 500},
+		{name: "many senders", senders: 8, perSender: 200},
+	}
+
+	for _, tc := range testCases {
+		tr := &testTransport{}
+		s := &session{conn: tr, out: make(chan string, sendQueueSize)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- s.writePackets(ctx)
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < tc.senders; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < tc.perSender; j++ {
+					s.out <- fmt.Sprintf("%d %d", i, j)
+				}
+			}(i)
+		}
+		wg.Wait()
+		cancel()
+
+		if err := <-errCh; err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		next := make([]int, tc.senders)
+		deadline := false
+		for _, event := range tr.events {
+			switch event {
+			case "deadline":
+				deadline = true
+				continue
+			case "flush":
+				deadline = false
+				continue
+			}
+
+			if !deadline {
+				t.Errorf("%s: %s: want deadline set", tc.name, event)
+			}
+			var i, j int
+			fmt.Sscanf(event, "write %d %d", &i, &j)
+			if j != next[i] {
+				t.Errorf("%s: sender %d: want packet %d, got %d", tc.name, i, next[i], j)
+			}
+			next[i] = j + 1
+		}
+		for i, v := range next {
+			if v != tc.perSender {
+				t.Errorf("%s: sender %d: want %d packets, got %d", tc.name, i, tc.perSender, v)
+			}
+		}
+	}
+}
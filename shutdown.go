@@ -0,0 +1,148 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+// ErrRestart is returned by Server.ListenAndServe when it stopped because a restart was requested.
+var ErrRestart = errors.New("restart requested")
+
+// shutdownWarnings are the remaining durations at which the shutdown countdown is announced, besides its start.
+var shutdownWarnings = []time.Duration{
+	1 * time.Minute,
+	30 * time.Second,
+	10 * time.Second,
+	5 * time.Second,
+}
+
+// shutdown stops accepting connections, warns the players, waits for the actions in progress, returns the items on the
+// ground to their droppers and saves every character, before closing the sessions with stopServing. It gives up
+// waiting when the shutdown timeout is reached.
+func (s *Server) shutdown(stopServing context.CancelFunc) {
+	s.logger.Infow("shutting down")
+
+	ctx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+	}
+
+	for _, ln := range s.lns {
+		ln.Close()
+	}
+
+	s.countDown(ctx)
+
+	s.shuttingDown.Store(true)
+
+	s.waitIdle(ctx)
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.returnGroundItems(flushCtx)
+	if err != nil {
+		s.logger.Errorw("could not return ground items",
+			"error", err,
+		)
+	}
+
+	err = s.flushCharacters(flushCtx)
+	if err != nil {
+		s.logger.Errorw("could not flush characters",
+			"error", err,
+		)
+	}
+
+	stopServing()
+}
+
+// requestRestart makes ListenAndServe shut down and return ErrRestart.
+func (s *Server) requestRestart(ctx context.Context) error {
+	select {
+	case s.restart <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *Server) countDown(ctx context.Context) {
+	if s.shutdownWarning <= 0 {
+		return
+	}
+
+	end := time.Now().Add(s.shutdownWarning)
+	s.sendMsgToAll(msgsvr.ChatServerMessage{Message: s.shutdownCountdownMessage(s.shutdownWarning)})
+
+	for _, v := range shutdownWarnings {
+		if v >= s.shutdownWarning {
+			continue
+		}
+
+		t := time.NewTimer(time.Until(end.Add(-v)))
+		select {
+		case <-t.C:
+			s.sendMsgToAll(msgsvr.ChatServerMessage{Message: s.shutdownCountdownMessage(v)})
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+
+	t := time.NewTimer(time.Until(end))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Server) shutdownCountdownMessage(remaining time.Duration) string {
+	return strings.ReplaceAll(s.shutdownMessage, "{remaining}", remaining.Round(time.Second).String())
+}
+
+// waitIdle waits until no session has an action in progress.
+func (s *Server) waitIdle(ctx context.Context) {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+
+	for {
+		busy := false
+		s.mu.Lock()
+		for sess := range s.sessions {
+			if sess.busy.Load() > 0 {
+				busy = true
+				break
+			}
+		}
+		s.mu.Unlock()
+
+		if !busy {
+			return
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) sendMsgToAll(msg msgOut) {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessionByCharacterId))
+	for _, sess := range s.sessionByCharacterId {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.sendMessage(msg)
+	}
+}
@@ -0,0 +1,89 @@
+package retropvp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/retro"
+)
+
+func Test_Server_shutdown(t *testing.T) {
+	type testCase struct {
+		name        string
+		actionDur   time.Duration
+		timeout     time.Duration
+		wantDrained bool
+	}
+
+	testCases := []testCase{
+		{name: "drained", actionDur: 200 * time.Millisecond, wantDrained: true},
+		{name: "timed out", actionDur: 1 * time.Second, timeout: 300 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		store := &testCharacterStore{}
+		svr := testCharacterSession(store).svr
+		svr.shutdownWarning = 50 * time.Millisecond
+		svr.shutdownMessage = "Restarting in {remaining}."
+		svr.shutdownTimeout = tc.timeout
+
+		sessions := make([]*session, 5)
+		for i := range sessions {
+			sessions[i] = testSession(svr, retro.Character{Id: 100 + i, Name: "Char", ClassId: 1}, nil)
+		}
+
+		// Each session is in the middle of an action that moves its character when it ends.
+		var wg sync.WaitGroup
+		for _, sess := range sessions {
+			sess.busy.Inc()
+			wg.Add(1)
+			go func(sess *session) {
+				defer wg.Done()
+				time.Sleep(tc.actionDur)
+				sess.state.move(2, 10)
+				sess.busy.Dec()
+			}(sess)
+		}
+
+		stopped := make(chan bool, 1)
+		svr.shutdown(func() {
+			idle := true
+			for _, sess := range sessions {
+				if sess.busy.Load() > 0 {
+					idle = false
+				}
+			}
+			stopped <- idle
+		})
+
+		select {
+		case idle := <-stopped:
+			if idle != tc.wantDrained {
+				t.Errorf("%s: idle when stopped: want %t, got %t", tc.name, tc.wantDrained, idle)
+			}
+		default:
+			t.Errorf("%s: want the sessions stopped", tc.name)
+		}
+
+		// What the actions changed before the sessions were stopped is saved.
+		store.mu.Lock()
+		var moved int
+		for _, c := range store.saved {
+			if c.Character != nil && c.Character.GameMapId == 2 {
+				moved++
+			}
+		}
+		store.mu.Unlock()
+
+		wantMoved := 0
+		if tc.wantDrained {
+			wantMoved = len(sessions)
+		}
+		if moved != wantMoved {
+			t.Errorf("%s: saved moves: want %d, got %d", tc.name, wantMoved, moved)
+		}
+
+		wg.Wait()
+	}
+}
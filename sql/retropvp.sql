@@ -0,0 +1,79 @@
+-- Schema and data used by retropvp on top of the ones of retropg.
+
+--
+-- Bans of accounts, users and IP addresses, which are permanent without an end.
+--
+
+CREATE TABLE IF NOT EXISTS retro.bans
+(
+    kind       text        NOT NULL,
+    value      text        NOT NULL,
+    until      timestamptz,
+    reason     text        NOT NULL,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (kind, value)
+);
+
+--
+-- Violations of the clients, scored per account, for auditing.
+--
+
+CREATE TABLE IF NOT EXISTS retro.violations
+(
+    id           bigserial PRIMARY KEY,
+    account_id   uuid,
+    character_id integer,
+    ip           text             NOT NULL,
+    kind         text             NOT NULL,
+    detail       text             NOT NULL,
+    score        double precision NOT NULL,
+    action       text             NOT NULL,
+    created_at   timestamptz      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS violations_account_id_created_at_idx ON retro.violations (account_id, created_at DESC);
+
+--
+-- Waypoints known by each character, and the one saved as the point where recall potions return to.
+--
+
+CREATE TABLE IF NOT EXISTS retro.characters_waypoints
+(
+    character_id integer NOT NULL REFERENCES retro.characters (id) ON DELETE CASCADE,
+    map_id       integer NOT NULL,
+    PRIMARY KEY (character_id, map_id)
+);
+
+CREATE TABLE IF NOT EXISTS retro.characters_save_points
+(
+    character_id integer PRIMARY KEY REFERENCES retro.characters (id) ON DELETE CASCADE,
+    map_id       integer NOT NULL
+);
+
+--
+-- Prices of the market items in the second and third quantities of their markets, 0 when they're not sold in them. The
+-- price column is the one of the first quantity.
+--
+
+ALTER TABLE retro.markets_items
+    ADD COLUMN IF NOT EXISTS price_set_2 integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS price_set_3 integer NOT NULL DEFAULT 0;
+
+--
+-- Use effects of the item templates whose game data doesn't have any, with the custom effects of retropvp: 10001
+-- (0x2711) mounts or dismounts and 10002 (0x2712) opens the shed.
+--
+
+UPDATE retro_static.items
+SET effects = '2711#0#0#0'
+WHERE id IN (7651, 7799);
+
+UPDATE retro_static.items
+SET effects = '2712#0#0#0'
+WHERE id = 8626;
+
+-- Mount certificates are used through effect 995 (0x3e3), whose value on each item is the id of its mount.
+UPDATE retro_static.items
+SET effects = '3e3#0#0#0'
+WHERE type = 97
+  AND effects = '';
@@ -0,0 +1,67 @@
+package retropvp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+)
+
+// transport reads and writes the packets of a session.
+type transport interface {
+	// readPacket reads the next packet, without its delimiter.
+	readPacket() (string, error)
+	// writePacket buffers a packet until the next flush.
+	writePacket(pkt string) error
+	flush() error
+	setReadDeadline(t time.Time) error
+	setWriteDeadline(t time.Time) error
+	close() error
+}
+
+// streamTransport delimits the packets with a null byte over a stream, which is how the game client talks over TCP.
+type streamTransport struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	w    *bufio.Writer
+}
+
+func newStreamTransport(conn net.Conn) *streamTransport {
+	return &streamTransport{
+		conn: conn,
+		rd:   bufio.NewReaderSize(conn, 256),
+		w:    bufio.NewWriterSize(conn, 4096),
+	}
+}
+
+func (t *streamTransport) readPacket() (string, error) {
+	pkt, err := t.rd.ReadString('\x00')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(pkt, "\x00"), nil
+}
+
+func (t *streamTransport) writePacket(pkt string) error {
+	_, err := t.w.WriteString(pkt)
+	if err != nil {
+		return err
+	}
+	return t.w.WriteByte('\x00')
+}
+
+func (t *streamTransport) flush() error {
+	return t.w.Flush()
+}
+
+func (t *streamTransport) setReadDeadline(tm time.Time) error {
+	return t.conn.SetReadDeadline(tm)
+}
+
+func (t *streamTransport) setWriteDeadline(tm time.Time) error {
+	return t.conn.SetWriteDeadline(tm)
+}
+
+func (t *streamTransport) close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,248 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var errRecoveredPanic = errors.New("recovered from panic")
+var errUnexpectedMessage = fmt.Errorf("%w: unexpected message", errInvalidRequest)
+var errTooManyViolations = errors.New("too many violations")
+
+// ViolationKind is a kind of suspicious behavior of a client.
+type ViolationKind string
+
+const (
+	ViolationInvalidPath       ViolationKind = "invalid_path"
+	ViolationUnexpectedMessage ViolationKind = "unexpected_message"
+	ViolationPanic             ViolationKind = "panic"
+	ViolationInvalidRequest    ViolationKind = "invalid_request"
+	ViolationTooFast           ViolationKind = "too_fast"
+)
+
+// violationWeights are how much each kind of violation adds to the score of an account.
+var violationWeights = map[ViolationKind]float64{
+	ViolationInvalidPath:       1,
+	ViolationUnexpectedMessage: 3,
+	ViolationPanic:             2,
+	ViolationInvalidRequest:    2,
+	ViolationTooFast:           3,
+}
+
+// ViolationAction is what's done about an account when a violation brings its score over a threshold.
+type ViolationAction string
+
+const (
+	ViolationActionNone ViolationAction = ""
+	ViolationActionLog  ViolationAction = "log"
+	ViolationActionKick ViolationAction = "kick"
+	ViolationActionBan  ViolationAction = "ban"
+)
+
+// Violation is a suspicious behavior of a client, as recorded in the audit.
+type Violation struct {
+	AccountId   string          `json:"accountId,omitempty"`
+	CharacterId int             `json:"characterId,omitempty"`
+	IP          string          `json:"ip"`
+	Kind        ViolationKind   `json:"kind"`
+	Detail      string          `json:"detail,omitempty"`
+	Score       float64         `json:"score"`
+	Action      ViolationAction `json:"action,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// ViolationStorer persists the violations so they can be audited.
+type ViolationStorer interface {
+	CreateViolation(ctx context.Context, v Violation) error
+	// Violations returns the latest violations, of the account if accountId isn't empty, newest first.
+	Violations(ctx context.Context, accountId string, limit int) ([]Violation, error)
+}
+
+// ViolationPolicy scores the violations of each account, halving the score every HalfLife, and logs, kicks or bans
+// the account for BanDur, or forever if it's zero, once its score reaches the matching threshold. A zero threshold
+// disables its action.
+type ViolationPolicy struct {
+	HalfLife time.Duration
+	LogAt    float64
+	KickAt   float64
+	BanAt    float64
+	BanDur   time.Duration
+}
+
+// DefaultViolationPolicy is the policy used when Config.Violations is the zero value.
+var DefaultViolationPolicy = ViolationPolicy{
+	HalfLife: 30 * time.Minute,
+	LogAt:    5,
+	KickAt:   15,
+	BanAt:    30,
+	BanDur:   1 * time.Hour,
+}
+
+func (p ViolationPolicy) validate() error {
+	if p.HalfLife <= 0 {
+		return errors.New("violation half-life must be positive")
+	}
+	if p.LogAt < 0 || p.KickAt < 0 || p.BanAt < 0 {
+		return errors.New("violation thresholds must not be negative")
+	}
+	if p.BanDur < 0 {
+		return errors.New("violation ban duration must not be negative")
+	}
+	return nil
+}
+
+func (p ViolationPolicy) action(score float64) ViolationAction {
+	switch {
+	case p.BanAt > 0 && score >= p.BanAt:
+		return ViolationActionBan
+	case p.KickAt > 0 && score >= p.KickAt:
+		return ViolationActionKick
+	case p.LogAt > 0 && score >= p.LogAt:
+		return ViolationActionLog
+	}
+	return ViolationActionNone
+}
+
+// minViolationScore is the score under which a score is as good as none.
+const minViolationScore = 0.01
+
+// violationScores are the decaying violation scores of the accounts.
+type violationScores struct {
+	mu          sync.Mutex
+	byAccountId map[string]violationScore
+}
+
+type violationScore struct {
+	value   float64
+	updated time.Time
+}
+
+func newViolationScores() *violationScores {
+	return &violationScores{byAccountId: make(map[string]violationScore)}
+}
+
+// add adds the weight to the score of the account, after decaying it, and returns the new score.
+func (vs *violationScores) add(accountId string, weight float64, halfLife time.Duration, now time.Time) float64 {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	score := vs.byAccountId[accountId]
+	if !score.updated.IsZero() {
+		score.value *= math.Exp2(-float64(now.Sub(score.updated)) / float64(halfLife))
+	}
+	score.value += weight
+	score.updated = now
+
+	vs.byAccountId[accountId] = score
+	return score.value
+}
+
+func (vs *violationScores) reset(accountId string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	delete(vs.byAccountId, accountId)
+}
+
+// prune forgets the scores that decayed under minViolationScore, and returns how many it forgot.
+func (vs *violationScores) prune(halfLife time.Duration, now time.Time) int {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	var n int
+	for accountId, score := range vs.byAccountId {
+		if score.value*math.Exp2(-float64(now.Sub(score.updated))/float64(halfLife)) < minViolationScore {
+			delete(vs.byAccountId, accountId)
+			n++
+		}
+	}
+	return n
+}
+
+// recordViolation scores the violation, acts on it and stores it. It returns errTooManyViolations if the client was
+// kicked or banned.
+func (s *session) recordViolation(ctx context.Context, kind ViolationKind, detail string) error {
+	p := s.svr.violationPolicy
+	now := time.Now()
+
+	v := Violation{
+		AccountId:   s.accountId,
+		CharacterId: s.characterId,
+		IP:          s.ip,
+		Kind:        kind,
+		Detail:      detail,
+		CreatedAt:   now,
+	}
+	// Clients that aren't logged in are disconnected on their first violation anyway.
+	if s.accountId != "" {
+		v.Score = s.svr.violationScores.add(s.accountId, violationWeights[kind], p.HalfLife, now)
+		v.Action = p.action(v.Score)
+	}
+	s.svr.metrics.violation(kind)
+
+	logger := s.svr.logger.Debugw
+	if v.Action != ViolationActionNone {
+		logger = s.svr.logger.Warnw
+	}
+	logger("violation",
+		"kind", kind,
+		"detail", detail,
+		"score", v.Score,
+		"action", v.Action,
+		"account_id", s.accountId,
+		"character_id", s.characterId,
+		"client_address", s.remoteAddr.String(),
+	)
+
+	var err error
+	switch v.Action {
+	case ViolationActionKick:
+		err = errTooManyViolations
+	case ViolationActionBan:
+		// The score is kept if the account couldn't be banned, so the next violation tries again.
+		_, banErr := s.svr.banAccount(ctx, s.accountId, 0, p.BanDur, "violation score")
+		if banErr != nil {
+			s.svr.logger.Errorw("could not ban account",
+				"error", banErr,
+				"account_id", s.accountId,
+			)
+		} else {
+			s.svr.violationScores.reset(s.accountId)
+		}
+		err = errTooManyViolations
+	}
+
+	if s.svr.violations != nil {
+		storeErr := s.svr.violations.CreateViolation(ctx, v)
+		if storeErr != nil {
+			s.svr.logger.Errorw("could not store violation",
+				"error", storeErr,
+				"account_id", s.accountId,
+			)
+		}
+	}
+
+	return err
+}
+
+// recordErrorViolation records the violation that made a packet fail, if any.
+func (s *session) recordErrorViolation(ctx context.Context, err error) {
+	var kind ViolationKind
+	switch {
+	case errors.Is(err, errTooManyViolations):
+		return
+	case errors.Is(err, errRecoveredPanic):
+		kind = ViolationPanic
+	case errors.Is(err, errUnexpectedMessage):
+		kind = ViolationUnexpectedMessage
+	case errors.Is(err, errInvalidRequest):
+		kind = ViolationInvalidRequest
+	default:
+		return
+	}
+	s.recordViolation(ctx, kind, err.Error())
+}
@@ -0,0 +1,63 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ViolationDb is a PostgreSQL ViolationStorer, which stores the violations in the retro.violations table of
+// sql/retropvp.sql.
+type ViolationDb struct {
+	pool *pgxpool.Pool
+}
+
+func NewViolationDb(pool *pgxpool.Pool) (*ViolationDb, error) {
+	if pool == nil {
+		return nil, errors.New("pool is nil")
+	}
+
+	return &ViolationDb{pool: pool}, nil
+}
+
+func (r *ViolationDb) CreateViolation(ctx context.Context, v Violation) error {
+	query := "INSERT INTO retro.violations (account_id, character_id, ip, kind, detail, score, action, created_at)" +
+		" VALUES (NULLIF($1, '')::uuid, NULLIF($2, 0), $3, $4, $5, $6, $7, $8);"
+
+	_, err := r.pool.Exec(ctx, query,
+		v.AccountId, v.CharacterId, v.IP, string(v.Kind), v.Detail, v.Score, string(v.Action), v.CreatedAt)
+	return err
+}
+
+func (r *ViolationDb) Violations(ctx context.Context, accountId string, limit int) ([]Violation, error) {
+	query := "SELECT COALESCE(account_id::text, ''), COALESCE(character_id, 0), ip, kind, detail, score, action," +
+		" created_at" +
+		" FROM retro.violations" +
+		" WHERE $1 = '' OR account_id = NULLIF($1, '')::uuid" +
+		" ORDER BY created_at DESC" +
+		" LIMIT $2;"
+
+	rows, err := r.pool.Query(ctx, query, accountId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	violations := []Violation{}
+	for rows.Next() {
+		var v Violation
+		var kind, action string
+
+		err = rows.Scan(&v.AccountId, &v.CharacterId, &v.IP, &kind, &v.Detail, &v.Score, &action, &v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		v.Kind = ViolationKind(kind)
+		v.Action = ViolationAction(action)
+
+		violations = append(violations, v)
+	}
+
+	return violations, rows.Err()
+}
@@ -0,0 +1,132 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_violationScores_add(t *testing.T) {
+	vs := newViolationScores()
+	start := time.Now()
+	halfLife := 10 * time.Minute
+
+	type testCase struct {
+		name    string
+		elapsed time.Duration
+		weight  float64
+		want    float64
+		action  ViolationAction
+	}
+
+	testCases := []testCase{
+		{name: "first", weight: 4, want: 4, action: ViolationActionNone},
+		{name: "same time", weight: 2, want: 6, action: ViolationActionLog},
+		{name: "after a half-life", elapsed: halfLife, weight: 1, want: 4, action: ViolationActionNone},
+		{name: "burst", elapsed: halfLife, weight: 12, want: 16, action: ViolationActionKick},
+		{name: "more burst", elapsed: halfLife, weight: 20, want: 36, action: ViolationActionBan},
+	}
+
+	for _, tc := range testCases {
+		got := vs.add("a", tc.weight, halfLife, start.Add(tc.elapsed))
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: score: want %v, got %v", tc.name, tc.want, got)
+		}
+		if action := DefaultViolationPolicy.action(got); action != tc.action {
+			t.Errorf("%s: action: want %q, got %q", tc.name, tc.action, action)
+		}
+	}
+
+	if got := vs.add("b", 1, halfLife, start); got != 1 {
+		t.Errorf("another account: want 1, got %v", got)
+	}
+}
+
+func Test_violationScores_prune(t *testing.T) {
+	vs := newViolationScores()
+	now := time.Now()
+	halfLife := time.Hour
+
+	vs.add("recent", 1, halfLife, now.Add(-time.Hour))
+	vs.add("high", 1000, halfLife, now.Add(-10*time.Hour))
+	vs.add("decayed", 1, halfLife, now.Add(-24*time.Hour))
+
+	if n := vs.prune(halfLife, now); n != 1 {
+		t.Errorf("pruned: want 1, got %d", n)
+	}
+	for _, accountId := range []string{"recent", "high"} {
+		if _, ok := vs.byAccountId[accountId]; !ok {
+			t.Errorf("%s: want the score kept", accountId)
+		}
+	}
+	if _, ok := vs.byAccountId["decayed"]; ok {
+		t.Errorf("decayed: want the score forgotten")
+	}
+}
+
+// testBanStore is a BanStorer that fails while fail is set.
+type testBanStore struct {
+	fail bool
+}
+
+func (r *testBanStore) CreateBan(ctx context.Context, b Ban) error {
+	if r.fail {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (r *testBanStore) DeleteBan(ctx context.Context, kind BanKind, value string) error {
+	return nil
+}
+
+func (r *testBanStore) Bans(ctx context.Context) ([]Ban, error) {
+	return nil, nil
+}
+
+func Test_session_recordViolation(t *testing.T) {
+	ctx := context.Background()
+	policy := ViolationPolicy{HalfLife: time.Hour, KickAt: 1.5, BanAt: 3.5}
+
+	type testCase struct {
+		name       string
+		violations int
+		banFails   bool
+		wantErr    error
+		wantBanned bool
+		wantScore  bool
+	}
+
+	testCases := []testCase{
+		{name: "scored", violations: 1, wantScore: true},
+		{name: "kicked", violations: 2, wantErr: errTooManyViolations, wantScore: true},
+		{name: "banned", violations: 4, wantErr: errTooManyViolations, wantBanned: true},
+		{name: "ban failed", violations: 4, banFails: true, wantErr: errTooManyViolations, wantScore: true},
+	}
+
+	for _, tc := range testCases {
+		s := testCharacterSession(&testCharacterStore{})
+		s.accountId = "a"
+		s.svr.violationPolicy = policy
+		s.svr.violationScores = newViolationScores()
+		s.svr.bans = make(map[banKey]Ban)
+		s.svr.banStore = &testBanStore{fail: tc.banFails}
+
+		var err error
+		for i := 0; i < tc.violations; i++ {
+			err = s.recordViolation(ctx, ViolationInvalidPath, "")
+		}
+
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: error: want %v, got %v", tc.name, tc.wantErr, err)
+		}
+		if _, banned := s.svr.banned("a", "", ""); banned != tc.wantBanned {
+			t.Errorf("%s: banned: want %t, got %t", tc.name, tc.wantBanned, banned)
+		}
+		if _, ok := s.svr.violationScores.byAccountId["a"]; ok != tc.wantScore {
+			t.Errorf("%s: score kept: want %t, got %t", tc.name, tc.wantScore, ok)
+		}
+	}
+}
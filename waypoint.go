@@ -0,0 +1,321 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	protoenum "github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgsvr"
+	prototyp "github.com/kralamoure/retroproto/typ"
+)
+
+// Waypoint is a fast travel point, whose zaap stands on the cell. Using the zaap opens the list of known waypoints,
+// learns it and saves it as the point where recall potions return to, and saving it only learns and saves it.
+type Waypoint struct {
+	GameMapId int
+	CellId    int
+	X         int
+	Y         int
+}
+
+// WaypointStorer stores the waypoints each character knows and their save point, by game map id.
+type WaypointStorer interface {
+	// LearnWaypoint adds the waypoint to the known ones of the character and saves it as their save point.
+	LearnWaypoint(ctx context.Context, characterId, gameMapId int) error
+	// CharacterWaypoints returns the waypoints the character knows and their save point, which is 0 if they have none.
+	CharacterWaypoints(ctx context.Context, characterId int) (known []int, savePoint int, err error)
+}
+
+// ParseWaypoint parses a waypoint in the format "gameMapId:cellId:x:y".
+func ParseWaypoint(s string) (Waypoint, error) {
+	sli := strings.Split(s, ":")
+	if len(sli) != 4 {
+		return Waypoint{}, fmt.Errorf("malformed waypoint: %q", s)
+	}
+
+	var n [4]int
+	for i, v := range sli {
+		tmp, err := strconv.Atoi(v)
+		if err != nil {
+			return Waypoint{}, fmt.Errorf("malformed waypoint: %q", s)
+		}
+		n[i] = tmp
+	}
+
+	return Waypoint{
+		GameMapId: n[0],
+		CellId:    n[1],
+		X:         n[2],
+		Y:         n[3],
+	}, nil
+}
+
+func (s *Server) waypointTravelCost(from, to Waypoint) int {
+	dx := from.X - to.X
+	if dx < 0 {
+		dx = -dx
+	}
+	dy := from.Y - to.Y
+	if dy < 0 {
+		dy = -dy
+	}
+	return s.waypointCost * (dx + dy)
+}
+
+// learnWaypoint adds the waypoint to the known ones of the character and saves it as their save point.
+func (s *Server) learnWaypoint(ctx context.Context, characterId int, w Waypoint) error {
+	if s.waypointStore != nil {
+		err := s.waypointStore.LearnWaypoint(ctx, characterId, w.GameMapId)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known, ok := s.knownWaypointsByCharacterId[characterId]
+	if !ok {
+		known = make(map[int]struct{})
+		s.knownWaypointsByCharacterId[characterId] = known
+	}
+	known[w.GameMapId] = struct{}{}
+
+	s.savePointByCharacterId[characterId] = w
+	return nil
+}
+
+// loadWaypoints loads the stored waypoints of the character, if they're stored. The ones that aren't configured
+// anymore are ignored.
+func (s *Server) loadWaypoints(ctx context.Context, characterId int) error {
+	if s.waypointStore == nil {
+		return nil
+	}
+
+	gameMapIds, savePoint, err := s.waypointStore.CharacterWaypoints(ctx, characterId)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int]struct{}, len(gameMapIds))
+	for _, id := range gameMapIds {
+		if _, ok := s.waypoints[id]; ok {
+			known[id] = struct{}{}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.knownWaypointsByCharacterId[characterId] = known
+	w, ok := s.waypoints[savePoint]
+	if ok {
+		s.savePointByCharacterId[characterId] = w
+	} else {
+		delete(s.savePointByCharacterId, characterId)
+	}
+	return nil
+}
+
+// forgetWaypoints forgets the waypoints of the character once they're offline, if they're stored.
+func (s *Server) forgetWaypoints(characterId int) {
+	if s.waypointStore == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.knownWaypointsByCharacterId, characterId)
+	delete(s.savePointByCharacterId, characterId)
+}
+
+func (s *Server) knowsWaypoint(characterId, gameMapId int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.knownWaypointsByCharacterId[characterId][gameMapId]
+	return ok
+}
+
+// waypointArrivalCell returns the cell where the characters traveling to the waypoint arrive, the first walkable one
+// around its zaap.
+func (s *Server) waypointArrivalCell(w Waypoint) (int, error) {
+	c := s.cache()
+	gameMap, ok := c.static.gameMaps[w.GameMapId]
+	if !ok {
+		return 0, errors.New("game map not found")
+	}
+
+	geometry, err := c.gameMapGeometry(gameMap)
+	if err != nil {
+		return 0, err
+	}
+
+	for dir := 0; dir < 8; dir++ {
+		id, ok := geometry.neighbor(w.CellId, dir)
+		if ok && geometry.isWalkable(id) {
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no walkable cell around the zaap of waypoint %d", w.GameMapId)
+}
+
+func (s *Server) savePoint(characterId int) (Waypoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.savePointByCharacterId[characterId]
+	return w, ok
+}
+
+// saveWaypoint learns the waypoint of the zaap on the cell and saves it as the save point of the character.
+func (s *session) saveWaypoint(ctx context.Context, gameMapId, cellId int) error {
+	_, err := s.learnZaapWaypoint(ctx, gameMapId, cellId)
+	return err
+}
+
+// openWaypoint learns the waypoint of the zaap on the cell, like saveWaypoint, and opens the list of the known ones to
+// travel to.
+func (s *session) openWaypoint(ctx context.Context, gameMapId, cellId int) error {
+	current, err := s.learnZaapWaypoint(ctx, gameMapId, cellId)
+	if err != nil {
+		return err
+	}
+
+	msg := waypointsCreate{CurrentGameMapId: current.GameMapId}
+	for _, w := range s.svr.waypoints {
+		if w.GameMapId == current.GameMapId || !s.svr.knowsWaypoint(s.characterId, w.GameMapId) {
+			continue
+		}
+		msg.Waypoints = append(msg.Waypoints, waypointsCreateWaypoint{
+			GameMapId: w.GameMapId,
+			Cost:      s.svr.waypointTravelCost(current, w),
+		})
+	}
+	sort.Slice(msg.Waypoints, func(i, j int) bool { return msg.Waypoints[i].GameMapId < msg.Waypoints[j].GameMapId })
+
+	s.cache.waypoint = &current
+	s.sendMessage(msg)
+
+	return nil
+}
+
+func (s *session) learnZaapWaypoint(ctx context.Context, gameMapId, cellId int) (Waypoint, error) {
+	w, ok := s.svr.waypoints[gameMapId]
+	if !ok || w.CellId != cellId {
+		return Waypoint{}, errInvalidRequest
+	}
+
+	err := s.svr.learnWaypoint(ctx, s.characterId, w)
+	if err != nil {
+		return Waypoint{}, err
+	}
+
+	s.sendMessage(msgsvr.InfosMessage{
+		ChatId: protoenum.InfosMessageChatId.Info,
+		Messages: []prototyp.InfosMessageMessage{
+			{
+				Id: 6,
+			},
+		},
+	})
+
+	return w, nil
+}
+
+func (s *session) handleWaypointsUse(ctx context.Context, m waypointsUse) error {
+	if s.cache.waypoint == nil {
+		return errInvalidRequest
+	}
+	current := *s.cache.waypoint
+
+	target, ok := s.svr.waypoints[m.GameMapId]
+	if !ok || target.GameMapId == current.GameMapId || !s.svr.knowsWaypoint(s.characterId, target.GameMapId) {
+		s.sendMessage(waypointsUseError{})
+		return nil
+	}
+
+	char, err := s.character(ctx)
+	if err != nil {
+		return err
+	}
+
+	if char.GameMapId != current.GameMapId {
+		return errInvalidRequest
+	}
+
+	cellId, err := s.svr.waypointArrivalCell(target)
+	if err != nil {
+		return err
+	}
+
+	cost := s.svr.waypointTravelCost(current, target)
+	if cost > char.Kamas {
+		s.sendMessage(waypointsUseError{})
+		return nil
+	}
+
+	char.Kamas -= cost
+
+	err = s.updateCharacter(ctx, char)
+	if err != nil {
+		return err
+	}
+
+	stats, err := s.protoStats(ctx)
+	if err != nil {
+		return err
+	}
+	s.sendMessage(stats)
+
+	s.cache.waypoint = nil
+	s.sendMessage(waypointsLeave{})
+
+	return s.teleport(ctx, target.GameMapId, cellId)
+}
+
+func (s *session) handleWaypointsRequestLeave() error {
+	s.cache.waypoint = nil
+	s.sendMessage(waypointsLeave{})
+
+	return nil
+}
+
+func (s *session) checkRecall(ctx context.Context, use itemUse) error {
+	_, ok := s.svr.savePoint(use.target.characterId)
+	if !ok {
+		// TODO: hardcode in lang
+		s.sendMessage(msgsvr.InfosMessage{
+			ChatId: protoenum.InfosMessageChatId.Error,
+			Messages: []prototyp.InfosMessageMessage{
+				{
+					Id:   16,
+					Args: []string{"<b>Error</b>", "There is no saved position to return to."},
+				},
+			},
+		})
+		return errNoop
+	}
+
+	return nil
+}
+
+func (s *session) useRecall(ctx context.Context, use itemUse) error {
+	w, ok := s.svr.savePoint(use.target.characterId)
+	if !ok {
+		return errors.New("save point not found")
+	}
+
+	cellId, err := s.svr.waypointArrivalCell(w)
+	if err != nil {
+		return err
+	}
+
+	return use.target.teleport(ctx, w.GameMapId, cellId)
+}
@@ -0,0 +1,88 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// WaypointDb is a PostgreSQL WaypointStorer, which stores the waypoints in the retro.characters_waypoints and
+// retro.characters_save_points tables of sql/retropvp.sql.
+type WaypointDb struct {
+	pool *pgxpool.Pool
+}
+
+func NewWaypointDb(pool *pgxpool.Pool) (*WaypointDb, error) {
+	if pool == nil {
+		return nil, errors.New("pool is nil")
+	}
+
+	return &WaypointDb{pool: pool}, nil
+}
+
+func (r *WaypointDb) LearnWaypoint(ctx context.Context, characterId, gameMapId int) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := "INSERT INTO retro.characters_waypoints (character_id, map_id)" +
+		" VALUES ($1, $2)" +
+		" ON CONFLICT DO NOTHING;"
+
+	_, err = tx.Exec(ctx, query, characterId, gameMapId)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT INTO retro.characters_save_points (character_id, map_id)" +
+		" VALUES ($1, $2)" +
+		" ON CONFLICT (character_id) DO UPDATE" +
+		" SET map_id = EXCLUDED.map_id;"
+
+	_, err = tx.Exec(ctx, query, characterId, gameMapId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *WaypointDb) CharacterWaypoints(ctx context.Context, characterId int) (known []int, savePoint int, err error) {
+	query := "SELECT map_id" +
+		" FROM retro.characters_waypoints" +
+		" WHERE character_id = $1;"
+
+	rows, err := r.pool.Query(ctx, query, characterId)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, 0, err
+		}
+		known = append(known, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = "SELECT map_id" +
+		" FROM retro.characters_save_points" +
+		" WHERE character_id = $1;"
+
+	err = r.pool.QueryRow(ctx, query, characterId).Scan(&savePoint)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, err
+	}
+
+	return known, savePoint, nil
+}
@@ -0,0 +1,220 @@
+package retropvp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retroutil"
+)
+
+func Test_ParseWaypoint(t *testing.T) {
+	type testCase struct {
+		s       string
+		want    Waypoint
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{s: "7411:311:-1:13", want: Waypoint{GameMapId: 7411, CellId: 311, X: -1, Y: 13}},
+		{s: "7411:311:-1", wantErr: true},
+		{s: "7411:311:-1:13:0", wantErr: true},
+		{s: "7411:cell:-1:13", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := ParseWaypoint(tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: want error %t, got %v", tc.s, tc.wantErr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: want %+v, got %+v", tc.s, tc.want, got)
+		}
+	}
+}
+
+func Test_waypointsUse_Deserialize(t *testing.T) {
+	type testCase struct {
+		extra   string
+		want    waypointsUse
+		wantErr bool
+	}
+
+	testCases := []testCase{
+		{extra: "7411", want: waypointsUse{GameMapId: 7411}},
+		{extra: "", wantErr: true},
+		{extra: "7411|1", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		var got waypointsUse
+		err := got.Deserialize(tc.extra)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: want error %t, got %v", tc.extra, tc.wantErr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: want %+v, got %+v", tc.extra, tc.want, got)
+		}
+	}
+}
+
+// testWaypointStore is a WaypointStorer that keeps the waypoints in memory, or fails while fail is set.
+type testWaypointStore struct {
+	fail       bool
+	known      map[int][]int
+	savePoints map[int]int
+}
+
+func (r *testWaypointStore) LearnWaypoint(ctx context.Context, characterId, gameMapId int) error {
+	if r.fail {
+		return errors.New("unavailable")
+	}
+	r.known[characterId] = append(r.known[characterId], gameMapId)
+	r.savePoints[characterId] = gameMapId
+	return nil
+}
+
+func (r *testWaypointStore) CharacterWaypoints(ctx context.Context, characterId int) ([]int, int, error) {
+	if r.fail {
+		return nil, 0, errors.New("unavailable")
+	}
+	return r.known[characterId], r.savePoints[characterId], nil
+}
+
+func Test_Server_learnWaypoint(t *testing.T) {
+	ctx := context.Background()
+	waypoints := map[int]Waypoint{
+		10: {GameMapId: 10, CellId: 100},
+		20: {GameMapId: 20, CellId: 200},
+		30: {GameMapId: 30, CellId: 300},
+	}
+
+	type testCase struct {
+		name          string
+		stored        []int
+		storedSave    int
+		learn         []int
+		fail          bool
+		wantErr       bool
+		wantKnown     []int
+		wantSavePoint int
+	}
+
+	testCases := []testCase{
+		{name: "learned", learn: []int{10, 20}, wantKnown: []int{10, 20}, wantSavePoint: 20},
+		{name: "loaded", stored: []int{10, 30}, storedSave: 10, wantKnown: []int{10, 30}, wantSavePoint: 10},
+		{name: "loaded then learned", stored: []int{10}, storedSave: 10, learn: []int{20}, wantKnown: []int{10, 20}, wantSavePoint: 20},
+		{name: "not configured anymore", stored: []int{10, 40}, storedSave: 40, wantKnown: []int{10}},
+		{name: "store failed", learn: []int{10}, fail: true, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		store := &testWaypointStore{
+			known:      map[int][]int{1: tc.stored},
+			savePoints: map[int]int{1: tc.storedSave},
+		}
+		svr := &Server{
+			waypoints:                   waypoints,
+			waypointStore:               store,
+			knownWaypointsByCharacterId: make(map[int]map[int]struct{}),
+			savePointByCharacterId:      make(map[int]Waypoint),
+		}
+
+		if err := svr.loadWaypoints(ctx, 1); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		store.fail = tc.fail
+		var err error
+		for _, id := range tc.learn {
+			err = svr.learnWaypoint(ctx, 1, waypoints[id])
+		}
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %t, got %v", tc.name, tc.wantErr, err)
+		}
+		store.fail = false
+
+		// What's known once the character is back must be what was known before.
+		svr.forgetWaypoints(1)
+		if err := svr.loadWaypoints(ctx, 1); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		var known []int
+		for _, w := range []int{10, 20, 30, 40} {
+			if svr.knowsWaypoint(1, w) {
+				known = append(known, w)
+			}
+		}
+		if !reflect.DeepEqual(known, tc.wantKnown) {
+			t.Errorf("%s: known: want %v, got %v", tc.name, tc.wantKnown, known)
+		}
+
+		w, ok := svr.savePoint(1)
+		if ok != (tc.wantSavePoint != 0) || w.GameMapId != tc.wantSavePoint {
+			t.Errorf("%s: save point: want %d, got %d", tc.name, tc.wantSavePoint, w.GameMapId)
+		}
+	}
+}
+
+func Test_session_actionUseObject(t *testing.T) {
+	ctx := context.Background()
+	g := testGameMapGeometry(5, 5, func(cells []retroutil.Cell) {
+		cells[12].Movement = 0
+	})
+	next := neighborOf(g, 12, 1)
+
+	type testCase struct {
+		name     string
+		charCell int
+		cellId   int
+		skillId  int
+
+		wantErr    error
+		wantKnown  bool
+		wantOpened bool
+	}
+
+	testCases := []testCase{
+		{name: "used", charCell: next, cellId: 12, skillId: skillIdUseWaypoint, wantKnown: true, wantOpened: true},
+		{name: "saved", charCell: next, cellId: 12, skillId: skillIdSaveWaypoint, wantKnown: true},
+		{name: "not a zaap", charCell: next, cellId: next, skillId: skillIdUseWaypoint, wantErr: errInvalidRequest},
+		{name: "too far", charCell: 0, cellId: 12, skillId: skillIdUseWaypoint, wantErr: errInvalidRequest},
+		{name: "unknown skill", charCell: next, cellId: 12, skillId: 1, wantErr: errNotImplemented},
+	}
+
+	for _, tc := range testCases {
+		s := testCharacterSession(&testCharacterStore{})
+		s.svr.waypoints = map[int]Waypoint{1: {GameMapId: 1, CellId: 12}}
+		s.svr.knownWaypointsByCharacterId = make(map[int]map[int]struct{})
+		s.svr.savePointByCharacterId = make(map[int]Waypoint)
+
+		geometries := newGameMapGeometries()
+		done := make(chan struct{})
+		close(done)
+		geometries.byGameMapId[1] = &geometryCall{done: done, geometry: g}
+		s.svr.currentCache.Store(&cache{
+			static:     cacheStatic{gameMaps: map[int]retro.GameMap{1: {Id: 1, Width: 5}}},
+			geometries: geometries,
+		})
+		s.state.move(1, tc.charCell)
+
+		err := s.actionUseObject(ctx, gameActionsUseObject{CellId: tc.cellId, SkillId: tc.skillId})
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: error: want %v, got %v", tc.name, tc.wantErr, err)
+		}
+
+		if known := s.svr.knowsWaypoint(1, 1); known != tc.wantKnown {
+			t.Errorf("%s: known: want %t, got %t", tc.name, tc.wantKnown, known)
+		}
+		if opened := s.cache.waypoint != nil; opened != tc.wantOpened {
+			t.Errorf("%s: opened: want %t, got %t", tc.name, tc.wantOpened, opened)
+		}
+	}
+}
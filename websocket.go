@@ -0,0 +1,311 @@
+package retropvp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// webSocketListener accepts WebSocket connections over HTTP, which carry the packets with the same framing as TCP.
+type webSocketListener struct {
+	ln  net.Listener
+	srv *http.Server
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func newWebSocketListener(ln net.Listener) *webSocketListener {
+	wl := &webSocketListener{
+		ln:    ln,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	wl.srv = &http.Server{
+		Handler:           http.HandlerFunc(wl.upgrade),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := wl.srv.Serve(ln)
+		wl.closeOnce.Do(func() {
+			wl.err = err
+			close(wl.done)
+		})
+	}()
+
+	return wl
+}
+
+func (wl *webSocketListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected websocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err == nil {
+		_, err = fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n", webSocketAccept(key))
+	}
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	wc := &webSocketConn{conn: conn, rd: brw.Reader}
+	select {
+	case wl.conns <- wc:
+	case <-wl.done:
+		conn.Close()
+	}
+}
+
+func (wl *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-wl.conns:
+		return conn, nil
+	case <-wl.done:
+		if wl.err != nil && !errors.Is(wl.err, http.ErrServerClosed) {
+			return nil, wl.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+func (wl *webSocketListener) Close() error {
+	wl.closeOnce.Do(func() {
+		close(wl.done)
+	})
+	return wl.srv.Close()
+}
+
+func (wl *webSocketListener) Addr() net.Addr {
+	return wl.ln.Addr()
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketConn is a net.Conn whose stream is the payload of the WebSocket data frames. Each write is sent as a text
+// frame.
+type webSocketConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+
+	remaining uint64
+	mask      [4]byte
+	maskPos   int
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		err := c.readFrameHeader()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.rd.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// readFrameHeader reads the next frame header, handling the control frames, until a data frame starts.
+func (c *webSocketConn) readFrameHeader() error {
+	head := make([]byte, 2)
+	_, err := io.ReadFull(c.rd, head)
+	if err != nil {
+		return err
+	}
+
+	opcode := head[0] & 0xf
+	if head[1]&0x80 == 0 {
+		return errors.New("unmasked websocket frame")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(c.rd, ext)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(c.rd, ext)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if err != nil {
+		return err
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.rd, mask[:])
+	if err != nil {
+		return err
+	}
+
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		c.remaining = length
+		c.mask = mask
+		c.maskPos = 0
+		return nil
+	case wsOpClose, wsOpPing, wsOpPong:
+	default:
+		return fmt.Errorf("unsupported websocket opcode: %d", opcode)
+	}
+
+	if length > 125 {
+		return errors.New("websocket control frame is too long")
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.rd, payload)
+	if err != nil {
+		return err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	switch opcode {
+	case wsOpPing:
+		return c.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		c.closeOnce.Do(func() {
+			c.writeFrame(wsOpClose, payload)
+		})
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	err := c.writeFrame(wsOpText, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *webSocketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
+		c.writeFrame(wsOpClose, []byte{0x03, 0xe8})
+	})
+	return c.conn.Close()
+}
+
+func (c *webSocketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *webSocketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *webSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *webSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,103 @@
+package retropvp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func Test_webSocketListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := newWebSocketListener(ln)
+	defer wl.Close()
+
+	client, err := net.Dial("tcp", wl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	_, err = io.WriteString(client, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := wl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rd := bufio.NewReader(client)
+	resp, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status code: want %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept: want %q, got %q", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", got)
+	}
+
+	mask := []byte{1, 2, 3, 4}
+	clientFrame := func(opcode byte, payload string) []byte {
+		frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+		frame = append(frame, mask...)
+		for i := 0; i < len(payload); i++ {
+			frame = append(frame, payload[i]^mask[i%4])
+		}
+		return frame
+	}
+
+	var frames []byte
+	frames = append(frames, clientFrame(wsOpText, "Ai")...)
+	frames = append(frames, clientFrame(wsOpPing, "hi")...)
+	frames = append(frames, clientFrame(wsOpContinuation, "abc\n\x00AL\n\x00")...)
+	_, err = client.Write(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := newStreamTransport(conn)
+	for _, want := range []string{"Aiabc\n", "AL\n"} {
+		got, err := tr.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("packet: want %q, got %q", want, got)
+		}
+	}
+
+	err = tr.writePacket("HG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tr.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range [][]byte{{0x80 | wsOpPong, 2, 'h', 'i'}, {0x80 | wsOpText, 3, 'H', 'G', 0}} {
+		got := make([]byte, len(want))
+		_, err = io.ReadFull(rd, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("server frame: want %v, got %v", want, got)
+		}
+	}
+}